@@ -0,0 +1,345 @@
+// Package address expands the address templates used for team machines and DNS records.
+//
+// A template is ordinary text with team expressions in braces, which are replaced with the
+// expression's value for a team:
+//
+//	10.{T*10}.0.5        team 3 -> 10.30.0.5
+//	fd00:{T:x}::5        team 26 -> fd00:1a::5   (":x" formats the value in hex)
+//	team{T+100}.local    team 1 -> team101.local
+//
+// Expressions use T for the team ID, integers, parentheses and the + - * / % operators with the
+// usual precedence. "<t>" is shorthand for {T}, and in an ip-schema so is an IPv4 octet or IPv6
+// group that is exactly "T" or "t" (as in "192.168.T.5" or "fd00:0:T::5").
+package address
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+// Template is a parsed address template.
+type Template struct {
+	source string
+	parts  []part
+}
+
+// part is a piece of a template: literal text, or an expression and how to format its value.
+type part struct {
+	text string
+	expr expr
+	hex  bool
+}
+
+// Parse parses a template, reporting syntax errors in its expressions.
+func Parse(template string) (*Template, error) {
+	t := &Template{source: template}
+	rest := strings.ReplaceAll(template, "<t>", "{T}")
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return nil, fmt.Errorf("unmatched } in %q", template)
+			}
+			t.parts = append(t.parts, part{text: rest})
+			break
+		}
+		if strings.IndexByte(rest[:start], '}') >= 0 {
+			return nil, fmt.Errorf("unmatched } in %q", template)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, part{text: rest[:start]})
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", template)
+		}
+		body := rest[start+1 : start+end]
+		rest = rest[start+end+1:]
+
+		source, format, _ := strings.Cut(body, ":")
+		if format != "" && format != "x" && format != "d" {
+			return nil, fmt.Errorf("unknown format %q in %q, use x or d", format, template)
+		}
+		e, err := parseExpr(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression {%s} in %q: %w", body, template, err)
+		}
+		t.parts = append(t.parts, part{expr: e, hex: format == "x"})
+	}
+	return t, nil
+}
+
+// ParseSchema parses a machine's ip-schema. On top of Parse it accepts the original schema
+// format, where an octet of an IPv4 address, or a group of an IPv6 one, is the letter T.
+func ParseSchema(schema string) (*Template, error) {
+	sep := "."
+	if strings.Contains(schema, ":") {
+		sep = ":"
+	}
+	groups := strings.Split(schema, sep)
+	if sep == ":" || len(groups) == 4 {
+		for i, group := range groups {
+			if group == "T" || group == "t" {
+				groups[i] = "{T}"
+			}
+		}
+	}
+	t, err := Parse(strings.Join(groups, sep))
+	if err != nil {
+		return nil, err
+	}
+	t.source = schema
+	return t, nil
+}
+
+// String returns the template as it was written.
+func (t *Template) String() string {
+	return t.source
+}
+
+// Expand fills in the template for a team.
+func (t *Template) Expand(teamID int) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.expr == nil {
+			b.WriteString(p.text)
+			continue
+		}
+		value, err := p.expr.eval(teamID)
+		if err != nil {
+			return "", fmt.Errorf("%q for team %d: %w", t.source, teamID, err)
+		}
+		if p.hex {
+			b.WriteString(strconv.FormatInt(int64(value), 16))
+		} else {
+			b.WriteString(strconv.Itoa(value))
+		}
+	}
+	return b.String(), nil
+}
+
+// Host fills in the template for a team and checks that the result is an IP address or hostname.
+func (t *Template) Host(teamID int) (string, error) {
+	host, err := t.Expand(teamID)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateHost(host); err != nil {
+		return "", fmt.Errorf("%q for team %d: %w", t.source, teamID, err)
+	}
+	return host, nil
+}
+
+// Expand parses a template and fills it in for a team.
+func Expand(template string, teamID int) (string, error) {
+	t, err := Parse(template)
+	if err != nil {
+		return "", err
+	}
+	return t.Expand(teamID)
+}
+
+// ForTeam returns a virtual machine's address for a team. An entry in the machine's addresses
+// map, keyed by team ID or name (the ID wins), is used as is; otherwise the ip-schema is expanded.
+func ForTeam(vm enum.VirtualMachine, teamID int, teamName string) (string, error) {
+	if host, ok := vm.Addresses[strconv.Itoa(teamID)]; ok {
+		return host, ValidateHost(host)
+	}
+	if host, ok := vm.Addresses[teamName]; ok {
+		return host, ValidateHost(host)
+	}
+	if vm.IPSchema == "" {
+		return "", fmt.Errorf("no ip-schema or address for team %d", teamID)
+	}
+
+	t, err := ParseSchema(vm.IPSchema)
+	if err != nil {
+		return "", err
+	}
+	return t.Host(teamID)
+}
+
+// ValidateHost checks that host is an IPv4 or IPv6 address or a valid hostname. Names made only
+// of numbers and dots must be IPv4 addresses, so an octet out of range isn't mistaken for a hostname.
+func ValidateHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if host == "" || len(host) > 253 {
+		return fmt.Errorf("%q is not a valid address or hostname", host)
+	}
+
+	numeric := true
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q is not a valid address or hostname", host)
+		}
+		for _, r := range label {
+			isDigit := r >= '0' && r <= '9'
+			if !isDigit && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && r != '-' {
+				return fmt.Errorf("%q is not a valid address or hostname", host)
+			}
+			if !isDigit {
+				numeric = false
+			}
+		}
+	}
+	if numeric {
+		return fmt.Errorf("%q is not a valid IP address", host)
+	}
+	return nil
+}
+
+// expr is a parsed team expression.
+type expr interface {
+	eval(team int) (int, error)
+}
+
+type number int
+
+func (n number) eval(int) (int, error) { return int(n), nil }
+
+type teamID struct{}
+
+func (teamID) eval(team int) (int, error) { return team, nil }
+
+type binary struct {
+	op          byte
+	left, right expr
+}
+
+func (b binary) eval(team int) (int, error) {
+	left, err := b.left.eval(team)
+	if err != nil {
+		return 0, err
+	}
+	right, err := b.right.eval(team)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	case '/', '%':
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if b.op == '/' {
+			return left / right, nil
+		}
+		return left % right, nil
+	}
+	return 0, fmt.Errorf("unknown operator %c", b.op)
+}
+
+// parser is a recursive descent parser over an expression's source:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/" | "%") factor }
+//	factor = number | "T" | "(" expr ")"
+type parser struct {
+	src string
+	pos int
+}
+
+func parseExpr(src string) (expr, error) {
+	p := &parser{src: src}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, fmt.Errorf("unexpected %q", p.src[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) expr() (expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) term() (expr, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/' || op == '%'; op = p.peek() {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) factor() (expr, error) {
+	switch c := p.peek(); {
+	case c == 'T' || c == 't':
+		p.pos++
+		return teamID{}, nil
+	case c == '(':
+		p.pos++
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil {
+			return nil, err
+		}
+		return number(n), nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q", c)
+	}
+}
@@ -0,0 +1,89 @@
+package address
+
+import (
+	"testing"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+func TestParseSchema(t *testing.T) {
+	cases := []struct {
+		schema string
+		team   int
+		want   string
+	}{
+		{"192.168.T.5", 3, "192.168.3.5"},
+		{"10.20.1.t", 7, "10.20.1.7"},
+		{"10.{T*10}.0.5", 3, "10.30.0.5"},
+		{"10.{(T - 1) * 16 + 2}.{T % 4}.1", 5, "10.66.1.1"},
+		{"fd00:{T:x}::5", 26, "fd00:1a::5"},
+		{"fd00:0:T::5", 3, "fd00:0:3::5"},
+		{"team<t>.example.com", 12, "team12.example.com"},
+		{"box{T+100}.local", 1, "box101.local"},
+		{"10.0.0.{T:d}", 9, "10.0.0.9"},
+	}
+	for _, c := range cases {
+		tmpl, err := ParseSchema(c.schema)
+		if err != nil {
+			t.Fatalf("ParseSchema(%q) failed: %v", c.schema, err)
+		}
+		got, err := tmpl.Host(c.team)
+		if err != nil {
+			t.Fatalf("%q for team %d failed: %v", c.schema, c.team, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q for team %d: expected %q, got %q", c.schema, c.team, c.want, got)
+		}
+	}
+
+	for _, schema := range []string{"10.{T*}.0.1", "10.{T.0.1", "10.T}.0.1", "10.{X}.0.1", "10.{T:b}.0.1", "10.{(T+1}.0.1"} {
+		if _, err := ParseSchema(schema); err == nil {
+			t.Fatalf("ParseSchema(%q) should fail", schema)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	// Octets out of range and junk aren't addresses or hostnames
+	for _, schema := range []string{"10.{T*100}.0.1", "10.0.{T/0}.1", "bad_host{T}", "{T}-.local", ""} {
+		tmpl, err := ParseSchema(schema)
+		if err != nil {
+			t.Fatalf("ParseSchema(%q) failed: %v", schema, err)
+		}
+		if host, err := tmpl.Host(3); err == nil {
+			t.Fatalf("%q for team 3 should fail, got %q", schema, host)
+		}
+	}
+}
+
+func TestForTeam(t *testing.T) {
+	vm := enum.VirtualMachine{
+		IPSchema:  "192.168.{T}.5",
+		Addresses: map[string]string{"Blue": "10.0.0.2", "2": "10.0.0.3", "3": "not_valid"},
+	}
+	cases := []struct {
+		id   int
+		name string
+		want string
+	}{
+		{1, "Red", "192.168.1.5"},
+		{1, "Blue", "10.0.0.2"},
+		{2, "Blue", "10.0.0.3"}, // The ID wins over the name
+	}
+	for _, c := range cases {
+		got, err := ForTeam(vm, c.id, c.name)
+		if err != nil {
+			t.Fatalf("ForTeam for team %d (%s) failed: %v", c.id, c.name, err)
+		}
+		if got != c.want {
+			t.Fatalf("ForTeam for team %d (%s): expected %q, got %q", c.id, c.name, c.want, got)
+		}
+	}
+
+	if _, err := ForTeam(vm, 3, "Green"); err == nil {
+		t.Fatal("an invalid mapped address should fail")
+	}
+	if _, err := ForTeam(enum.VirtualMachine{Addresses: vm.Addresses}, 4, "Gold"); err == nil {
+		t.Fatal("a team without a mapped address or ip-schema should fail")
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+)
+
+// AdjustmentInfo is a manual score change as returned by the API.
+type AdjustmentInfo struct {
+	ID        int       `json:"id"`
+	TeamID    int       `json:"team_id"`
+	Delta     int       `json:"delta"` // Negative for penalties
+	Reason    string    `json:"reason"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func adjustmentInfo(a enum.Adjustment) AdjustmentInfo {
+	return AdjustmentInfo{
+		ID:        a.ID,
+		TeamID:    a.TeamID,
+		Delta:     a.Delta,
+		Reason:    a.Reason,
+		Author:    a.Author,
+		CreatedAt: a.CreatedAt,
+	}
+}
+
+// Lists the manual changes made to a team's score
+func ListTeamAdjustments(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		adjustments, err := database.ListAdjustments(db, teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]AdjustmentInfo, 0, len(adjustments))
+		for _, a := range adjustments {
+			results = append(results, adjustmentInfo(a))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// Adds or removes points from a team's score, recording why (admin only)
+func CreateAdjustment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		var body struct {
+			Delta  int    `json:"delta"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Delta == 0 || strings.TrimSpace(body.Reason) == "" {
+			http.Error(w, "a non-zero delta and a reason are required", http.StatusBadRequest)
+			return
+		}
+		if _, err := database.GetTeam(db, teamID); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "team not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		adjustment := enum.Adjustment{TeamID: teamID, Delta: body.Delta, Reason: body.Reason, Author: claims.Name, CreatedAt: time.Now()}
+		id, err := database.CreateAdjustment(db, adjustment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		adjustment.ID = id
+
+		logging.AuditLog(fmt.Sprintf("api %s (%s): team %d score adjusted by %+d: %s", claims.Name, claims.Role, teamID, body.Delta, body.Reason))
+		writeJSON(w, http.StatusCreated, adjustmentInfo(adjustment))
+	}
+}
+
+// SLAViolationInfo is an SLA violation as returned by the API.
+type SLAViolationInfo struct {
+	ID         int       `json:"id"`
+	Service    string    `json:"service"`
+	StartRound int       `json:"start_round"` // The round of the first failed check in the run
+	EndRound   int       `json:"end_round"`   // The round of the last failed check in the run
+	Penalty    int       `json:"penalty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Lists the SLA violations of a team's services, so the team can see why it lost points
+func ListTeamSLAViolations(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		violations, err := database.ListSLAViolations(db, teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]SLAViolationInfo, 0, len(violations))
+		for _, v := range violations {
+			results = append(results, SLAViolationInfo{
+				ID:         v.ID,
+				Service:    v.Service,
+				StartRound: v.StartRound,
+				EndRound:   v.EndRound,
+				Penalty:    v.Penalty,
+				CreatedAt:  v.CreatedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
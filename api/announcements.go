@@ -0,0 +1,199 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+)
+
+// AnnouncementInfo is an announcement as returned by the API.
+type AnnouncementInfo struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	PublishAt time.Time `json:"publish_at"` // Teams don't see the announcement until this time
+	IsVisible bool      `json:"is_visible"`
+}
+
+func announcementInfo(a enum.Announcement) AnnouncementInfo {
+	return AnnouncementInfo{
+		ID:        a.ID,
+		Title:     a.Title,
+		Content:   a.Content,
+		Author:    a.Author,
+		CreatedAt: a.CreatedAt,
+		PublishAt: a.PublishAt,
+		IsVisible: a.Visible,
+	}
+}
+
+// announcementRequest is the body of announcement create and edit requests. Pointers tell an
+// omitted field apart from one being cleared.
+type announcementRequest struct {
+	Title     *string    `json:"title"`
+	Content   *string    `json:"content"`
+	PublishAt *time.Time `json:"publish_at"`
+	IsVisible *bool      `json:"is_visible"`
+}
+
+func writeAnnouncements(w http.ResponseWriter, db *sql.DB, all bool) {
+	announcements, err := database.ListAnnouncements(db, all)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := make([]AnnouncementInfo, 0, len(announcements))
+	for _, a := range announcements {
+		results = append(results, announcementInfo(a))
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// Lists the published announcements, newest first
+func ListAnnouncements(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAnnouncements(w, db, false)
+	}
+}
+
+// Lists every announcement, including hidden and scheduled ones (admin only)
+func ListAllAnnouncements(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAnnouncements(w, db, true)
+	}
+}
+
+// Returns a published announcement
+func GetAnnouncement(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := urlID(w, r, "announcementID")
+		if !ok {
+			return
+		}
+		a, err := database.GetAnnouncement(db, id)
+		if errors.Is(err, database.ErrNotFound) || (err == nil && (!a.Visible || a.PublishAt.After(time.Now()))) {
+			http.Error(w, "announcement not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, announcementInfo(a))
+	}
+}
+
+// Posts an announcement, published immediately unless publish_at is given (admin only)
+func CreateAnnouncement(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body announcementRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Title == nil || *body.Title == "" || body.Content == nil || *body.Content == "" {
+			http.Error(w, "title and content are required", http.StatusBadRequest)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		a := enum.Announcement{Title: *body.Title, Content: *body.Content, Author: claims.Name}
+		if body.PublishAt != nil {
+			a.PublishAt = *body.PublishAt
+		}
+
+		id, err := database.CreateAnnouncement(db, a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if a, err = database.GetAnnouncement(db, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logging.AuditLog(fmt.Sprintf("api %s (%s): announcement %d created", claims.Name, claims.Role, id))
+		writeJSON(w, http.StatusCreated, announcementInfo(a))
+	}
+}
+
+// Edits, reschedules, hides or unhides an announcement (admin only)
+func UpdateAnnouncement(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := urlID(w, r, "announcementID")
+		if !ok {
+			return
+		}
+		var body announcementRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		a, err := database.GetAnnouncement(db, id)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "announcement not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if body.Title != nil {
+			a.Title = *body.Title
+		}
+		if body.Content != nil {
+			a.Content = *body.Content
+		}
+		if body.PublishAt != nil {
+			a.PublishAt = *body.PublishAt
+		}
+		if body.IsVisible != nil {
+			a.Visible = *body.IsVisible
+		}
+		if a.Title == "" || a.Content == "" {
+			http.Error(w, "title and content cannot be empty", http.StatusBadRequest)
+			return
+		}
+
+		if err := database.UpdateAnnouncement(db, a); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): announcement %d updated", claims.Name, claims.Role, id))
+		writeJSON(w, http.StatusOK, announcementInfo(a))
+	}
+}
+
+// Deletes an announcement (admin only)
+func DeleteAnnouncement(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := urlID(w, r, "announcementID")
+		if !ok {
+			return
+		}
+		if err := database.DeleteAnnouncement(db, id); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "announcement not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): announcement %d deleted", claims.Name, claims.Role, id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/go-chi/chi"
+)
+
+// AuthTokens is returned when signing in or refreshing.
+type AuthTokens struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn"` // Seconds until the access token expires
+	Role         string `json:"role"`
+	TeamID       int    `json:"teamId,omitempty"`
+}
+
+// writeJSON encodes v as the response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// identity is who a token is being issued to, either a user account or a team.
+type identity struct {
+	userID int
+	teamID int
+	name   string
+	role   string
+}
+
+func (id identity) subject() string {
+	if id.userID != 0 {
+		return auth.UserSubject(id.userID)
+	}
+	return auth.TeamSubject(id.teamID)
+}
+
+// teamIDIfTeamLogin returns the team ID for team sign ins, which is what refresh tokens record;
+// users are recorded by their user ID alone so their team is looked up fresh.
+func (id identity) teamIDIfTeamLogin() int {
+	if id.userID != 0 {
+		return 0
+	}
+	return id.teamID
+}
+
+// lookupIdentity reloads who a refresh token belongs to, so role and team changes take effect on refresh.
+func lookupIdentity(db *sql.DB, userID, teamID int) (identity, error) {
+	if userID != 0 {
+		user, err := database.GetUser(db, userID)
+		if err != nil {
+			return identity{}, err
+		}
+		return identity{userID: user.ID, teamID: user.TeamID, name: user.Username, role: user.Role}, nil
+	}
+	team, err := database.GetTeam(db, teamID)
+	if err != nil {
+		return identity{}, err
+	}
+	return identity{teamID: team.ID, name: team.Name, role: auth.RoleBlue}, nil
+}
+
+// authenticate checks a username (or email) and password against the users table, then falls back
+// to team names and passwords so teams can sign in with the credentials they were given.
+func authenticate(db *sql.DB, login, password string) (identity, error) {
+	user, err := database.GetUserByLogin(db, login)
+	if err == nil {
+		ok, err := auth.VerifyPassword(password, user.PasswordHash)
+		if err != nil || !ok {
+			return identity{}, database.ErrNotFound
+		}
+		return identity{userID: user.ID, teamID: user.TeamID, name: user.Username, role: user.Role}, nil
+	} else if !errors.Is(err, database.ErrNotFound) {
+		return identity{}, err
+	}
+
+	teamID, err := database.VerifyTeamPassword(db, login, password)
+	if err != nil {
+		return identity{}, err
+	}
+	return identity{teamID: teamID, name: login, role: auth.RoleBlue}, nil
+}
+
+// Signs a user or team in, returning an access token and a refresh token
+func Login(db *sql.DB, cfg auth.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" || body.Password == "" {
+			http.Error(w, "username and password are required", http.StatusBadRequest)
+			return
+		}
+
+		id, err := authenticate(db, body.Username, body.Password)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "invalid username or password", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		accessToken, err := cfg.IssueAccessToken(id.subject(), id.name, id.role, id.teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		refreshToken, refreshHash, err := auth.NewRefreshToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := database.StoreRefreshToken(db, refreshHash, id.userID, id.teamIDIfTeamLogin(), time.Now().Add(cfg.RefreshTTL)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, AuthTokens{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			ExpiresIn:    int(cfg.AccessTTL.Seconds()),
+			Role:         id.role,
+			TeamID:       id.teamID,
+		})
+	}
+}
+
+// decodeRefreshToken reads the {"refreshToken": ...} body used by refresh and logout
+func decodeRefreshToken(r *http.Request) (string, bool) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		return "", false
+	}
+	return body.RefreshToken, true
+}
+
+// Exchanges a refresh token for a new access token
+func Refresh(db *sql.DB, cfg auth.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := decodeRefreshToken(r)
+		if !ok {
+			http.Error(w, "refreshToken is required", http.StatusBadRequest)
+			return
+		}
+
+		userID, teamID, err := database.GetRefreshToken(db, auth.HashRefreshToken(token))
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "invalid or expired refresh token", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		id, err := lookupIdentity(db, userID, teamID)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "account no longer exists", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		accessToken, err := cfg.IssueAccessToken(id.subject(), id.name, id.role, id.teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, AuthTokens{
+			AccessToken: accessToken,
+			ExpiresIn:   int(cfg.AccessTTL.Seconds()),
+			Role:        id.role,
+			TeamID:      id.teamID,
+		})
+	}
+}
+
+// Revokes a refresh token
+func Logout(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := decodeRefreshToken(r)
+		if !ok {
+			http.Error(w, "refreshToken is required", http.StatusBadRequest)
+			return
+		}
+		if err := database.DeleteRefreshToken(db, auth.HashRefreshToken(token)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// urlID parses a numeric URL parameter, writing a 400 response if it isn't one.
+func urlID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil {
+		http.Error(w, name+" must be a number", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// requireTeamAccess only lets through callers allowed to see the team in the {teamID} URL
+// parameter. It must be used after Authenticate.
+func requireTeamAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		claims, ok := auth.FromContext(r.Context())
+		if !ok || !claims.CanViewTeam(teamID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/logging"
+	"github.com/LTSEC/NEST/scoring"
+)
+
+// CredentialChangeInfo is a recorded password change request as returned by the API. New
+// passwords are never returned.
+type CredentialChangeInfo struct {
+	ID        int       `json:"id"`
+	Service   string    `json:"service"`
+	Username  string    `json:"username"`
+	ChangedBy string    `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Submits a password change request: new passwords for users of one of the team's services, which
+// the scoring engine logs in with from then on
+func ChangeTeamCredentials(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		var body struct {
+			Service     string `json:"service"`
+			Credentials []struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			} `json:"credentials"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Service == "" || len(body.Credentials) == 0 {
+			http.Error(w, "service and at least one credential are required", http.StatusBadRequest)
+			return
+		}
+
+		// Only users the service already logs in as can have their passwords changed
+		team, err := database.GetTeam(db, teamID)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "team not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		known, err := scoring.ServiceUsernames(team, body.Service)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		passwords := make(map[string]string, len(body.Credentials))
+		for _, c := range body.Credentials {
+			if c.Password == "" {
+				http.Error(w, "password cannot be empty for "+c.Username, http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(known, c.Username) {
+				http.Error(w, fmt.Sprintf("%s is not a user of %s", c.Username, body.Service), http.StatusBadRequest)
+				return
+			}
+			passwords[c.Username] = c.Password
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		if err := database.ChangeCredentials(db, teamID, body.Service, passwords, claims.Name); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "the team does not have service "+body.Service, http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		usernames := make([]string, 0, len(passwords))
+		for username := range passwords {
+			usernames = append(usernames, username)
+		}
+		slices.Sort(usernames)
+		logging.AuditLog(fmt.Sprintf("api %s (%s): team %d changed %s passwords for %s",
+			claims.Name, claims.Role, teamID, body.Service, strings.Join(usernames, ", ")))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Lists a team's password change requests
+func ListTeamCredentialChanges(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		changes, err := database.ListCredentialChanges(db, teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]CredentialChangeInfo, 0, len(changes))
+		for _, c := range changes {
+			results = append(results, CredentialChangeInfo{
+				ID:        c.ID,
+				Service:   c.Service,
+				Username:  c.Username,
+				ChangedBy: c.ChangedBy,
+				ChangedAt: c.ChangedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
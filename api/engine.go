@@ -0,0 +1,80 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/logging"
+	"github.com/LTSEC/NEST/scoring"
+)
+
+// EngineStatusInfo is the scoring engine's state as returned by the API.
+type EngineStatusInfo struct {
+	State                string     `json:"state"`                   // running, paused or stopped
+	Round                int        `json:"round"`                   // The most recent round to have started
+	NextRoundAt          *time.Time `json:"next_round_at,omitempty"` // When the next round starts, absent while not running
+	NextRoundInSeconds   float64    `json:"next_round_in_seconds"`   // Time until the next round, 0 while not running
+	LastRoundDurationMS  int64      `json:"last_round_duration_ms"`  // How long the last round took to score
+	RefreshTimeInSeconds int        `json:"refresh_time_seconds"`    // How often rounds start
+}
+
+func engineStatusInfo() EngineStatusInfo {
+	status := scoring.GetEngineStatus()
+	info := EngineStatusInfo{
+		State:                status.State,
+		Round:                status.Round,
+		LastRoundDurationMS:  status.LastRoundTime.Milliseconds(),
+		RefreshTimeInSeconds: int(status.RefreshTime.Seconds()),
+	}
+	if !status.NextRoundAt.IsZero() {
+		info.NextRoundAt = &status.NextRoundAt
+		info.NextRoundInSeconds = max(time.Until(status.NextRoundAt).Seconds(), 0)
+	}
+	return info
+}
+
+// Returns the scoring engine's state, current round and round timing
+func GetEngineStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, engineStatusInfo())
+	}
+}
+
+// Runs an engine control (start, stop, pause or resume), recording who did it in the audit log
+// and returning the engine's new status. Controls that don't apply to the current state are a conflict.
+func ControlEngine(action string, control func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := control(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): engine %s", claims.Name, claims.Role, action))
+		writeJSON(w, http.StatusOK, engineStatusInfo())
+	}
+}
+
+// Re-reads main.yaml and swaps it in between rounds, returning what changed (admin only). An
+// invalid configuration is refused and the running one is kept.
+func ReloadConfig(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.FromContext(r.Context())
+		summary, err := scoring.Reload(db)
+		if errors.Is(err, scoring.ErrInvalidConfig) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			logging.AuditLog(fmt.Sprintf("api %s (%s): configuration reloaded, resync failed: %v", claims.Name, claims.Role, err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logging.AuditLog(fmt.Sprintf("api %s (%s): configuration reloaded: %s", claims.Name, claims.Role, summary))
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LTSEC/NEST/events"
+)
+
+func TestStreamEvents(t *testing.T) {
+	server := httptest.NewServer(StreamEvents())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to connect to the stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	readMessage := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read the stream: %v", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	// The connected comment arrives once the handler has subscribed
+	if msg := readMessage(); msg != ": connected\n" {
+		t.Fatalf("unexpected first message %q", msg)
+	}
+
+	events.Publish(events.TypeTransition, events.CheckEvent{Round: 4, TeamID: 2, Service: "vm-0_ssh", Status: "down", Previous: "up"})
+	msg := readMessage()
+	if !strings.Contains(msg, "event: transition\n") ||
+		!strings.Contains(msg, `data: {"round":4,"team_id":2,"service":"vm-0_ssh","status":"down","previous":"up","points":0,"latency_ms":0}`) {
+		t.Fatalf("unexpected event %q", msg)
+	}
+}
@@ -3,8 +3,12 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/LTSEC/NEST/database"
 	"github.com/go-chi/chi"
 )
 
@@ -12,16 +16,28 @@ import (
 type ServiceInfo struct {
 	Points           int  `json:"points"`
 	IsUp             bool `json:"is_up"`
+	IsPartial        bool `json:"is_partial"` // Up, but only some parts of the last check passed
+	Disabled         bool `json:"disabled"`   // Not being scored, for every team or just this one
 	SuccessfulChecks int  `json:"successful_checks"`
 	TotalChecks      int  `json:"total_checks"`
 }
 
+// ScoreTotals breaks a team's score down by where the points came from.
+type ScoreTotals struct {
+	ServicePoints    int `json:"service_points"`
+	InjectPoints     int `json:"inject_points"`
+	AdjustmentPoints int `json:"adjustment_points"` // Manual penalties and bonuses
+	SLAPenalty       int `json:"sla_penalty"`       // Points lost to SLA violations
+	Total            int `json:"total"`
+}
+
 // TeamInfo aggregates team details and their associated services.
 type TeamInfo struct {
 	ID       int                    `json:"ID"`
 	Name     string                 `json:"Name"`
 	Color    string                 `json:"Color"`
 	Services map[string]ServiceInfo `json:"Services"`
+	Totals   *ScoreTotals           `json:"Totals,omitempty"`
 }
 
 // Returns all teams in the database as JSON
@@ -56,7 +72,8 @@ func ListAllTeamScores(db *sql.DB) http.HandlerFunc {
 		// Query all teams, their services, and the points of each service along with additional fields
 		rows, err := db.Query(`
             SELECT t.team_id, t.team_name, t.team_color, s.service_name, 
-                   ts.points, ts.is_up, ts.successful_checks, ts.total_checks
+                   ts.points, ts.is_up, ts.is_partial, ts.successful_checks, ts.total_checks,
+                   s.disabled OR s.retired OR ts.disabled
             FROM teams AS t
             JOIN team_services AS ts ON t.team_id = ts.team_id
             JOIN services AS s ON s.service_id = ts.service_id
@@ -79,10 +96,12 @@ func ListAllTeamScores(db *sql.DB) http.HandlerFunc {
 				serviceName      string
 				serviceScore     int
 				isUp             bool
+				isPartial        bool
 				successfulChecks int
 				totalChecks      int
+				disabled         bool
 			)
-			if err := rows.Scan(&teamID, &teamName, &teamColor, &serviceName, &serviceScore, &isUp, &successfulChecks, &totalChecks); err != nil {
+			if err := rows.Scan(&teamID, &teamName, &teamColor, &serviceName, &serviceScore, &isUp, &isPartial, &successfulChecks, &totalChecks, &disabled); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -100,8 +119,10 @@ func ListAllTeamScores(db *sql.DB) http.HandlerFunc {
 			teamMap[teamID].Services[serviceName] = ServiceInfo{
 				Points:           serviceScore,
 				IsUp:             isUp,
+				IsPartial:        isPartial,
 				SuccessfulChecks: successfulChecks,
 				TotalChecks:      totalChecks,
+				Disabled:         disabled,
 			}
 		}
 		// Check for iteration error
@@ -110,6 +131,24 @@ func ListAllTeamScores(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Add each team's score breakdown
+		totals, err := database.GetTeamTotals(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, total := range totals {
+			if team, exists := teamMap[total.ID]; exists {
+				team.Totals = &ScoreTotals{
+					ServicePoints:    total.ServicePoints,
+					InjectPoints:     total.InjectPoints,
+					AdjustmentPoints: total.AdjustmentPoints,
+					SLAPenalty:       total.SLAPenalty,
+					Total:            total.Total,
+				}
+			}
+		}
+
 		// Convert the map to a slice for JSON output
 		results := make([]TeamInfo, 0, len(teamMap))
 		for _, teamData := range teamMap {
@@ -169,3 +208,78 @@ func ListTeamScore(db *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(results)
 	}
 }
+
+// CheckInfo is a single recorded service check from a team's history.
+type CheckInfo struct {
+	Round     int       `json:"round"`
+	Service   string    `json:"service"`
+	IsUp      bool      `json:"is_up"`
+	IsPartial bool      `json:"is_partial"`
+	Points    int       `json:"points"`
+	LatencyMS int       `json:"latency_ms"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Returns a specific team's full check history, optionally limited to a range of rounds with
+// the "from" and "to" query parameters
+func ListTeamHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID := chi.URLParam(r, "teamID")
+		if teamID == "" {
+			http.Error(w, "teamID not provided", http.StatusBadRequest)
+			return
+		}
+
+		from, to := 0, math.MaxInt32
+		if v := r.URL.Query().Get("from"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "from must be a round number", http.StatusBadRequest)
+				return
+			}
+			from = n
+		}
+		if v := r.URL.Query().Get("to"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "to must be a round number", http.StatusBadRequest)
+				return
+			}
+			to = n
+		}
+
+		rows, err := db.Query(`
+			SELECT sc.round_id, s.service_name, sc.status, sc.is_partial, sc.points, sc.latency_ms,
+			       COALESCE(sc.reason, ''), sc.timestamp
+			FROM service_checks AS sc
+			JOIN team_services AS ts ON ts.team_service_id = sc.team_service_id
+			JOIN services AS s ON s.service_id = ts.service_id
+			WHERE ts.team_id = $1 AND sc.round_id BETWEEN $2 AND $3
+			ORDER BY sc.round_id, s.service_name
+		`, teamID, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		results := []CheckInfo{}
+		for rows.Next() {
+			var c CheckInfo
+			if err := rows.Scan(&c.Round, &c.Service, &c.IsUp, &c.IsPartial, &c.Points, &c.LatencyMS, &c.Reason, &c.Timestamp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			results = append(results, c)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Return JSON
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
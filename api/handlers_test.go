@@ -0,0 +1,26 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestListTeamHistoryRounds(t *testing.T) {
+	// Round ranges that aren't numbers are refused before the history is queried
+	for _, query := range []string{"?from=first", "?to=last", "?from=1&to=2.5"} {
+		r := httptest.NewRequest(http.MethodGet, "/teams/1/history"+query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("teamID", "1")
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+		w := httptest.NewRecorder()
+		ListTeamHistory(nil)(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be refused, got %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+)
+
+// The largest submission, file and text together, a team can upload
+const maxSubmissionSize = 10 << 20
+
+// InjectInfo is an inject as returned by the API.
+type InjectInfo struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Points      int       `json:"points"`
+	ReleaseAt   time.Time `json:"release_at"`
+	DueAt       time.Time `json:"due_at"`
+}
+
+func injectInfo(inject enum.Inject) InjectInfo {
+	return InjectInfo{
+		ID:          inject.ID,
+		Title:       inject.Title,
+		Description: inject.Description,
+		Points:      inject.Points,
+		ReleaseAt:   inject.ReleaseAt,
+		DueAt:       inject.DueAt,
+	}
+}
+
+// SubmissionInfo is an inject submission as returned by the API. The file itself is downloaded
+// separately.
+type SubmissionInfo struct {
+	ID          int        `json:"id"`
+	InjectID    int        `json:"inject_id"`
+	TeamID      int        `json:"team_id"`
+	Content     string     `json:"content"`
+	FileName    string     `json:"file_name,omitempty"`
+	FileSize    int        `json:"file_size,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	IsLate      bool       `json:"is_late"`
+	Score       *int       `json:"score"` // null until graded
+	Comments    string     `json:"comments,omitempty"`
+	GradedBy    string     `json:"graded_by,omitempty"`
+	GradedAt    *time.Time `json:"graded_at,omitempty"`
+}
+
+func submissionInfo(s enum.InjectSubmission) SubmissionInfo {
+	info := SubmissionInfo{
+		ID:          s.ID,
+		InjectID:    s.InjectID,
+		TeamID:      s.TeamID,
+		Content:     s.Content,
+		FileName:    s.FileName,
+		FileSize:    s.FileSize,
+		SubmittedAt: s.SubmittedAt,
+		IsLate:      s.Late,
+	}
+	if s.Graded {
+		info.Score = &s.Score
+		info.Comments = s.Comments
+		info.GradedBy = s.GradedBy
+		info.GradedAt = &s.GradedAt
+	}
+	return info
+}
+
+// injectRequest is the body of inject create and edit requests. Pointers tell an omitted field
+// apart from one being cleared.
+type injectRequest struct {
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	Points      *int       `json:"points"`
+	ReleaseAt   *time.Time `json:"release_at"`
+	DueAt       *time.Time `json:"due_at"`
+}
+
+// apply copies the fields that were set onto an inject
+func (body injectRequest) apply(inject *enum.Inject) {
+	if body.Title != nil {
+		inject.Title = *body.Title
+	}
+	if body.Description != nil {
+		inject.Description = *body.Description
+	}
+	if body.Points != nil {
+		inject.Points = *body.Points
+	}
+	if body.ReleaseAt != nil {
+		inject.ReleaseAt = *body.ReleaseAt
+	}
+	if body.DueAt != nil {
+		inject.DueAt = *body.DueAt
+	}
+}
+
+// checkInject returns why an inject can't be saved, or "" if it can
+func checkInject(inject enum.Inject) string {
+	switch {
+	case inject.Title == "":
+		return "title is required"
+	case inject.Points < 0:
+		return "points cannot be negative"
+	case inject.DueAt.IsZero():
+		return "due_at is required"
+	case !inject.ReleaseAt.IsZero() && inject.DueAt.Before(inject.ReleaseAt):
+		return "due_at must be after release_at"
+	}
+	return ""
+}
+
+// isGrader reports whether the caller can see every inject and submission and grade them.
+func isGrader(claims *auth.Claims) bool {
+	return claims.Role == auth.RoleAdmin || claims.Role == auth.RoleWhite
+}
+
+// loadInjectForCaller fetches the {injectID} inject. Blue team can't see injects before they're
+// released. It writes the error response and returns false if the inject can't be shown.
+func loadInjectForCaller(db *sql.DB, w http.ResponseWriter, r *http.Request) (enum.Inject, *auth.Claims, bool) {
+	injectID, ok := urlID(w, r, "injectID")
+	if !ok {
+		return enum.Inject{}, nil, false
+	}
+	claims, _ := auth.FromContext(r.Context())
+
+	inject, err := database.GetInject(db, injectID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !isGrader(claims) && inject.ReleaseAt.After(time.Now())) {
+		http.Error(w, "inject not found", http.StatusNotFound)
+		return enum.Inject{}, nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return enum.Inject{}, nil, false
+	}
+	return inject, claims, true
+}
+
+// Lists injects in release order. Blue team only sees released injects.
+func ListInjects(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.FromContext(r.Context())
+		injects, err := database.ListInjects(db, !isGrader(claims))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]InjectInfo, 0, len(injects))
+		for _, inject := range injects {
+			results = append(results, injectInfo(inject))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// Returns an inject
+func GetInject(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inject, _, ok := loadInjectForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, injectInfo(inject))
+	}
+}
+
+// Defines an inject (admin, white team)
+func CreateInject(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body injectRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		var inject enum.Inject
+		body.apply(&inject)
+		if msg := checkInject(inject); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		id, err := database.CreateInject(db, inject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if inject, err = database.GetInject(db, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): inject %d created", claims.Name, claims.Role, id))
+		writeJSON(w, http.StatusCreated, injectInfo(inject))
+	}
+}
+
+// Edits an inject's details, points or timing (admin, white team)
+func UpdateInject(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inject, claims, ok := loadInjectForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		var body injectRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		body.apply(&inject)
+		if msg := checkInject(inject); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if err := database.UpdateInject(db, inject); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logging.AuditLog(fmt.Sprintf("api %s (%s): inject %d updated", claims.Name, claims.Role, inject.ID))
+		writeJSON(w, http.StatusOK, injectInfo(inject))
+	}
+}
+
+// Deletes an inject and every submission to it (admin only)
+func DeleteInject(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		injectID, ok := urlID(w, r, "injectID")
+		if !ok {
+			return
+		}
+		if err := database.DeleteInject(db, injectID); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "inject not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): inject %d deleted", claims.Name, claims.Role, injectID))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Lists the submissions to an inject. Graders see every team's, blue team only their own.
+func ListSubmissions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inject, claims, ok := loadInjectForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		teamID := 0
+		if !isGrader(claims) {
+			if claims.TeamID == 0 {
+				http.Error(w, "you are not on a team", http.StatusForbidden)
+				return
+			}
+			teamID = claims.TeamID
+		}
+
+		submissions, err := database.ListSubmissions(db, inject.ID, teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]SubmissionInfo, 0, len(submissions))
+		for _, s := range submissions {
+			results = append(results, submissionInfo(s))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// readSubmission reads a submission from either a multipart form, with an optional "file" part and
+// "content" field, or a JSON body with "content".
+func readSubmission(w http.ResponseWriter, r *http.Request) (content, fileName string, fileData []byte, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSubmissionSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		var body struct {
+			Content string `json:"content"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		return body.Content, "", nil, err
+	}
+
+	if err = r.ParseMultipartForm(maxSubmissionSize); err != nil {
+		return "", "", nil, err
+	}
+	content = r.FormValue("content")
+	file, header, err := r.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return content, "", nil, nil
+	} else if err != nil {
+		return "", "", nil, err
+	}
+	defer file.Close()
+
+	fileData, err = io.ReadAll(file)
+	return content, filepath.Base(header.Filename), fileData, err
+}
+
+// Submits a team's answer to a released inject (blue team). Late submissions are accepted and
+// marked late for the graders.
+func SubmitInject(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inject, claims, ok := loadInjectForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		if claims.TeamID == 0 {
+			http.Error(w, "you are not on a team", http.StatusForbidden)
+			return
+		}
+
+		content, fileName, fileData, err := readSubmission(w, r)
+		if err != nil {
+			http.Error(w, "invalid submission: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(content) == "" && fileData == nil {
+			http.Error(w, "a submission needs content or a file", http.StatusBadRequest)
+			return
+		}
+
+		submission := enum.InjectSubmission{InjectID: inject.ID, TeamID: claims.TeamID, Content: content, FileName: fileName}
+		id, err := database.CreateSubmission(db, submission, fileData)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if submission, err = database.GetSubmission(db, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, submissionInfo(submission))
+	}
+}
+
+// loadSubmissionForCaller fetches the {submissionID} submission, allowing graders to load any and
+// blue team only their own. It writes the error response and returns false if the caller can't.
+func loadSubmissionForCaller(db *sql.DB, w http.ResponseWriter, r *http.Request) (enum.InjectSubmission, *auth.Claims, bool) {
+	submissionID, ok := urlID(w, r, "submissionID")
+	if !ok {
+		return enum.InjectSubmission{}, nil, false
+	}
+	claims, _ := auth.FromContext(r.Context())
+
+	submission, err := database.GetSubmission(db, submissionID)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !claims.CanViewTeam(submission.TeamID)) {
+		http.Error(w, "submission not found", http.StatusNotFound)
+		return enum.InjectSubmission{}, nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return enum.InjectSubmission{}, nil, false
+	}
+	return submission, claims, true
+}
+
+// Returns a submission (graders, or the team that made it)
+func GetSubmission(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		submission, _, ok := loadSubmissionForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, submissionInfo(submission))
+	}
+}
+
+// Downloads a submission's uploaded file (graders, or the team that made it)
+func DownloadSubmissionFile(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		submission, _, ok := loadSubmissionForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		name, data, err := database.GetSubmissionFile(db, submission.ID)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "submission has no file", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		w.Write(data)
+	}
+}
+
+// Grades a submission, replacing any earlier grade (admin, white team)
+func GradeSubmission(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		submission, claims, ok := loadSubmissionForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		var body struct {
+			Score    *int   `json:"score"`
+			Comments string `json:"comments"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Score == nil {
+			http.Error(w, "score is required", http.StatusBadRequest)
+			return
+		}
+
+		inject, err := database.GetInject(db, submission.InjectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if *body.Score < 0 || *body.Score > inject.Points {
+			http.Error(w, fmt.Sprintf("score must be between 0 and %d", inject.Points), http.StatusBadRequest)
+			return
+		}
+
+		if err := database.GradeSubmission(db, submission.ID, *body.Score, body.Comments, claims.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if submission, err = database.GetSubmission(db, submission.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logging.AuditLog(fmt.Sprintf("api %s (%s): submission %d for inject %d graded %d/%d",
+			claims.Name, claims.Role, submission.ID, inject.ID, *body.Score, inject.Points))
+		writeJSON(w, http.StatusOK, submissionInfo(submission))
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+func TestReadSubmission(t *testing.T) {
+	// Plain text answers come in as JSON
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"content": "We patched it"}`))
+	r.Header.Set("Content-Type", "application/json")
+	content, fileName, fileData, err := readSubmission(httptest.NewRecorder(), r)
+	if err != nil || content != "We patched it" || fileName != "" || fileData != nil {
+		t.Fatalf("unexpected JSON submission %q, %q, %q, %v", content, fileName, fileData, err)
+	}
+
+	// Documents come in as a multipart upload, with the file name stripped of any path
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("content", "See attached")
+	part, _ := form.CreateFormFile("file", "../../memo.pdf")
+	part.Write([]byte("%PDF-1.4"))
+	form.Close()
+
+	r = httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	content, fileName, fileData, err = readSubmission(httptest.NewRecorder(), r)
+	if err != nil || content != "See attached" || fileName != "memo.pdf" || string(fileData) != "%PDF-1.4" {
+		t.Fatalf("unexpected multipart submission %q, %q, %q, %v", content, fileName, fileData, err)
+	}
+
+	// Oversized uploads are rejected
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"content": "`+strings.Repeat("a", maxSubmissionSize)+`"}`))
+	if _, _, _, err := readSubmission(httptest.NewRecorder(), r); err == nil {
+		t.Fatal("expected an oversized submission to be rejected")
+	}
+}
+
+func TestCheckInject(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		inject enum.Inject
+		valid  bool
+	}{
+		{enum.Inject{Title: "Password policy", Points: 100, DueAt: now.Add(time.Hour)}, true},
+		{enum.Inject{Title: "Password policy", Points: 100, ReleaseAt: now, DueAt: now.Add(time.Hour)}, true},
+		{enum.Inject{Points: 100, DueAt: now}, false},
+		{enum.Inject{Title: "Negative", Points: -5, DueAt: now}, false},
+		{enum.Inject{Title: "No due time", Points: 100}, false},
+		{enum.Inject{Title: "Backwards", Points: 100, ReleaseAt: now, DueAt: now.Add(-time.Hour)}, false},
+	}
+	for _, c := range cases {
+		if msg := checkInject(c.inject); (msg == "") != c.valid {
+			t.Errorf("checkInject(%+v) = %q, expected valid = %v", c.inject, msg, c.valid)
+		}
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/scoring"
 	"github.com/go-chi/chi"
 )
 
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -22,8 +24,9 @@ func enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-// SetupRouter creates and configures the Chi router
-func SetupRouter(db *sql.DB) *chi.Mux {
+// SetupRouter creates and configures the Chi router. Tokens for authenticated routes are issued
+// and checked with authConfig.
+func SetupRouter(db *sql.DB, authConfig auth.Config) *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(enableCORS)
 
@@ -31,13 +34,123 @@ func SetupRouter(db *sql.DB) *chi.Mux {
 	// TODO: create middlewares package
 	// e.g., r.Use(middleware.Logger)
 
+	// Sign in routes, for user accounts and teams
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/login", Login(db, authConfig))     // Exchange a username/team name and password for tokens
+		r.Post("/refresh", Refresh(db, authConfig)) // Exchange a refresh token for a new access token
+		r.Post("/logout", Logout(db))               // Revoke a refresh token
+	})
+
+	// User account routes
+	r.Route("/users", func(r chi.Router) {
+		r.Use(authConfig.Authenticate)
+		r.With(auth.RequireRole(auth.RoleAdmin)).Get("/", ListUsers(db))
+		r.With(auth.RequireRole(auth.RoleAdmin)).Post("/", CreateUser(db))
+		r.Route("/{userID}", func(r chi.Router) {
+			r.Get("/", GetUser(db))      // Admins, or the user themselves
+			r.Patch("/", UpdateUser(db)) // Admins, or the user themselves
+			r.With(auth.RequireRole(auth.RoleAdmin)).Delete("/", DeleteUser(db))
+		})
+	})
+
+	// Announcement routes, readable by everyone once published
+	r.Route("/announcements", func(r chi.Router) {
+		r.Get("/", ListAnnouncements(db))
+		r.Get("/{announcementID}", GetAnnouncement(db))
+		r.Group(func(r chi.Router) {
+			r.Use(authConfig.Authenticate, auth.RequireRole(auth.RoleAdmin))
+			r.Get("/all", ListAllAnnouncements(db)) // Includes hidden and scheduled announcements
+			r.Post("/", CreateAnnouncement(db))
+			r.Patch("/{announcementID}", UpdateAnnouncement(db)) // Set is_visible to false to hide one
+			r.Delete("/{announcementID}", DeleteAnnouncement(db))
+		})
+	})
+
+	// Inject routes: white team defines and grades injects, blue team answers them
+	r.Route("/injects", func(r chi.Router) {
+		r.Use(authConfig.Authenticate)
+		graders := auth.RequireRole(auth.RoleAdmin, auth.RoleWhite)
+		r.Get("/", ListInjects(db)) // Blue team only sees released injects
+		r.With(graders).Post("/", CreateInject(db))
+		r.Route("/{injectID}", func(r chi.Router) {
+			r.Get("/", GetInject(db))
+			r.With(graders).Patch("/", UpdateInject(db))
+			r.With(auth.RequireRole(auth.RoleAdmin)).Delete("/", DeleteInject(db))
+			r.Get("/submissions", ListSubmissions(db))                                     // Graders see every team's, blue team their own
+			r.With(auth.RequireRole(auth.RoleBlue)).Post("/submissions", SubmitInject(db)) // JSON text or a multipart file upload
+		})
+		r.Route("/submissions/{submissionID}", func(r chi.Router) {
+			r.Get("/", GetSubmission(db))
+			r.Get("/file", DownloadSubmissionFile(db))
+			r.With(graders).Post("/grade", GradeSubmission(db))
+		})
+	})
+
+	// Live scoring events, for scoreboards that would otherwise poll /teams/scores
+	r.Get("/events", StreamEvents())
+
+	// Scoring engine routes, so the game can be run without the CLI
+	r.Route("/engine", func(r chi.Router) {
+		r.Use(authConfig.Authenticate)
+		r.Get("/status", GetEngineStatus()) // Engine state, current round and round timing
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireRole(auth.RoleAdmin, auth.RoleWhite))
+			r.Post("/start", ControlEngine("start", scoring.StartEngine))
+			r.Post("/stop", ControlEngine("stop", scoring.StopEngine))
+			r.Post("/pause", ControlEngine("pause", scoring.PauseEngine))
+			r.Post("/resume", ControlEngine("resume", scoring.ResumeEngine))
+		})
+	})
+
+	// Configuration routes, for picking up changes to main.yaml without a restart
+	r.Route("/config", func(r chi.Router) {
+		r.Use(authConfig.Authenticate, auth.RequireRole(auth.RoleAdmin))
+		r.Post("/reload", ReloadConfig(db))
+	})
+
+	// Service routes, for taking services out of scoring for every team or one, now or later
+	r.Route("/services", func(r chi.Router) {
+		r.Use(authConfig.Authenticate)
+		graders := auth.RequireRole(auth.RoleAdmin, auth.RoleWhite)
+		r.With(graders).Get("/", ListServices(db))
+		r.With(graders).Get("/toggles", ListServiceToggles(db)) // Scheduled toggles, or with ?all=true every toggle
+		r.With(auth.RequireRole(auth.RoleAdmin)).Delete("/toggles/{toggleID}", CancelServiceToggle(db))
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireRole(auth.RoleAdmin))
+			r.Post("/{service}/disable", ToggleService(db, true)) // {service} can also be a box, for all of its services
+			r.Post("/{service}/enable", ToggleService(db, false))
+		})
+	})
+
 	// Team routes
 	r.Route("/teams", func(r chi.Router) {
 		r.Get("/", ListTeams(db))               // Basic list of every team and their data (except passwords)
 		r.Get("/scores", ListAllTeamScores(db)) // List of every team and their data and scores for each service
+		r.Group(func(r chi.Router) {
+			r.Use(authConfig.Authenticate, auth.RequireRole(auth.RoleAdmin))
+			r.Post("/", CreateTeam(db))        // Gets every configured service
+			r.Post("/resync", ResyncTeams(db)) // Reconcile teams and services with the configuration
+		})
 		// List a specific team's scores
 		r.Route("/{teamID}", func(r chi.Router) {
 			r.Get("/scores", ListTeamScore(db))
+			r.With(authConfig.Authenticate, auth.RequireRole(auth.RoleAdmin)).Patch("/", UpdateTeam(db))
+			r.With(authConfig.Authenticate, auth.RequireRole(auth.RoleAdmin)).Delete("/", DeleteTeam(db))
+
+			// Private team data, for admins, white team and the team itself
+			r.Group(func(r chi.Router) {
+				r.Use(authConfig.Authenticate, requireTeamAccess)
+				r.Get("/history", ListTeamHistory(db)) // Every recorded check, optionally filtered by ?from=&to= rounds
+				r.Get("/members", ListTeamMembers(db))
+				r.With(auth.RequireRole(auth.RoleAdmin)).Post("/members", AddTeamMember(db))
+				r.With(auth.RequireRole(auth.RoleAdmin)).Delete("/members/{userID}", RemoveTeamMember(db))
+				r.Get("/adjustments", ListTeamAdjustments(db)) // Penalties and bonuses, with their reasons
+				r.With(auth.RequireRole(auth.RoleAdmin)).Post("/adjustments", CreateAdjustment(db))
+				r.Get("/sla-violations", ListTeamSLAViolations(db))  // Runs of failed checks that cost the team points
+				r.Get("/credentials", ListTeamCredentialChanges(db)) // Password change requests, without the passwords
+				// Submit a password change request, which only the team itself or an admin can do
+				r.With(auth.RequireRole(auth.RoleAdmin, auth.RoleBlue)).Post("/credentials", ChangeTeamCredentials(db))
+			})
 		})
 	})
 
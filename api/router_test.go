@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+)
+
+func TestChangeTeamCredentialsAccess(t *testing.T) {
+	cfg := auth.Config{Secret: []byte("test secret"), AccessTTL: time.Minute}
+	router := SetupRouter(nil, cfg)
+
+	for _, tc := range []struct {
+		role   string
+		teamID int
+		want   int
+	}{
+		{auth.RoleWhite, 0, http.StatusForbidden},  // White team sees every team but can't change their credentials
+		{auth.RoleBlue, 2, http.StatusForbidden},   // Nor can another team
+		{auth.RoleBlue, 1, http.StatusBadRequest},  // The team itself gets as far as the empty request
+		{auth.RoleAdmin, 0, http.StatusBadRequest}, // As do admins
+	} {
+		token, err := cfg.IssueAccessToken("user:1", "tester", tc.role, tc.teamID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/teams/1/credentials", strings.NewReader("{}"))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Fatalf("expected %s of team %d to get %d, got %d", tc.role, tc.teamID, tc.want, w.Code)
+		}
+	}
+}
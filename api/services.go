@@ -0,0 +1,172 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+	"github.com/LTSEC/NEST/scoring"
+	"github.com/go-chi/chi"
+)
+
+// ServiceStatusInfo is a service and whether it is being scored, as returned by the API.
+type ServiceStatusInfo struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Box           string `json:"box"`
+	Disabled      bool   `json:"disabled"`       // Disabled for every team
+	Retired       bool   `json:"retired"`        // No longer in the configuration
+	DisabledTeams []int  `json:"disabled_teams"` // Teams the service is disabled for on their own
+}
+
+// ServiceToggleInfo is a service enable or disable as returned by the API.
+type ServiceToggleInfo struct {
+	ID        int        `json:"id"`
+	Service   string     `json:"service"`
+	TeamID    *int       `json:"team_id"` // null for every team
+	Disabled  bool       `json:"disabled"`
+	RunAt     time.Time  `json:"run_at"`
+	Reason    string     `json:"reason,omitempty"`
+	Author    string     `json:"author"`
+	CreatedAt time.Time  `json:"created_at"`
+	AppliedAt *time.Time `json:"applied_at"` // null while scheduled
+}
+
+func serviceToggleInfo(t enum.ServiceToggle) ServiceToggleInfo {
+	info := ServiceToggleInfo{
+		ID:        t.ID,
+		Service:   t.Service,
+		Disabled:  t.Disabled,
+		RunAt:     t.RunAt,
+		Reason:    t.Reason,
+		Author:    t.Author,
+		CreatedAt: t.CreatedAt,
+	}
+	if t.TeamID != 0 {
+		info.TeamID = &t.TeamID
+	}
+	if !t.AppliedAt.IsZero() {
+		info.AppliedAt = &t.AppliedAt
+	}
+	return info
+}
+
+// Lists every service and whether it is disabled (admin and white team)
+func ListServices(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		services, err := database.ListServices(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]ServiceStatusInfo, 0, len(services))
+		for _, s := range services {
+			results = append(results, ServiceStatusInfo{
+				ID:            s.ID,
+				Name:          s.Name,
+				Box:           s.VMName,
+				Disabled:      s.Disabled,
+				Retired:       s.Retired,
+				DisabledTeams: s.DisabledTeams,
+			})
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// Disables or enables the service, or every service on the box, in the {service} URL parameter,
+// for every team or one, now or at a scheduled time (admin only)
+func ToggleService(db *sql.DB, disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Every field is optional; an empty body toggles the service for every team now
+		var body struct {
+			TeamID int        `json:"team_id"`
+			At     *time.Time `json:"at"`
+			Until  *time.Time `json:"until"` // Disables only, when to enable the service again
+			Reason string     `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		toggle := enum.ServiceToggle{TeamID: body.TeamID, Disabled: disabled, Reason: body.Reason, Author: claims.Name}
+		if body.At != nil {
+			toggle.RunAt = *body.At
+		}
+		var until time.Time
+		if body.Until != nil {
+			until = *body.Until
+		}
+
+		target := chi.URLParam(r, "service")
+		toggles, err := scoring.ToggleServices(db, target, toggle, until)
+		for _, t := range toggles {
+			logging.AuditLog(fmt.Sprintf("api %s (%s): service toggle %d, %s, at %s", claims.Name, claims.Role, t.ID, scoring.DescribeToggle(t), t.RunAt.Format(time.RFC3339)))
+		}
+		if errors.Is(err, scoring.ErrInvalidToggle) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("no service or box named %s, or the team does not have it", target), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]ServiceToggleInfo, 0, len(toggles))
+		for _, t := range toggles {
+			results = append(results, serviceToggleInfo(t))
+		}
+		writeJSON(w, http.StatusCreated, results)
+	}
+}
+
+// Lists the scheduled service toggles, or with ?all=true every toggle (admin and white team)
+func ListServiceToggles(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		toggles, err := database.ListServiceToggles(db, r.URL.Query().Get("all") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]ServiceToggleInfo, 0, len(toggles))
+		for _, t := range toggles {
+			results = append(results, serviceToggleInfo(t))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// Cancels a scheduled service toggle (admin only)
+func CancelServiceToggle(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := urlID(w, r, "toggleID")
+		if !ok {
+			return
+		}
+		if err := database.CancelServiceToggle(db, id); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "no scheduled toggle with that ID", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): service toggle %d cancelled", claims.Name, claims.Role, id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+	"github.com/LTSEC/NEST/scoring"
+)
+
+// hexColor matches the colors teams are shown in, such as #02c21f.
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// teamRequest is the body of a team create or update. Fields left out of an update are unchanged.
+type teamRequest struct {
+	Name     *string `json:"name"`
+	Color    *string `json:"color"`
+	Password *string `json:"password"` // Changing it signs the team out
+}
+
+// validate checks the fields that were given.
+func (body teamRequest) validate() string {
+	if body.Name != nil && (strings.TrimSpace(*body.Name) == "" || len(*body.Name) > 50) {
+		return "name must be between 1 and 50 characters"
+	}
+	if body.Color != nil && !hexColor.MatchString(*body.Color) {
+		return "color must be like #02c21f"
+	}
+	if body.Password != nil && *body.Password == "" {
+		return "password must not be empty"
+	}
+	if body.Password != nil && auth.IsPasswordHash(*body.Password) {
+		if err := auth.ValidatePasswordHash(*body.Password); err != nil {
+			return "invalid password hash: " + err.Error()
+		}
+	}
+	return ""
+}
+
+// Creates a team that isn't in the configuration, such as a late entrant, with every configured
+// service (admin only)
+func CreateTeam(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body teamRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Name == nil || body.Color == nil || body.Password == nil {
+			http.Error(w, "name, color and password are required", http.StatusBadRequest)
+			return
+		}
+		if msg := body.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		team := enum.Team{Name: *body.Name, Color: *body.Color, Password: *body.Password}
+		id, err := scoring.CreateTeam(db, team)
+		if err != nil && id == 0 {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			// The team was made but is missing some of its services; a resync adds them
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): team %d (%s) created", claims.Name, claims.Role, id, team.Name))
+		writeJSON(w, http.StatusCreated, TeamInfo{ID: id, Name: team.Name, Color: team.Color})
+	}
+}
+
+// Renames, recolors or changes the password of a team (admin only)
+func UpdateTeam(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		var body teamRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if msg := body.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		team, err := database.GetTeam(db, teamID)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "team not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		if body.Name != nil && *body.Name != team.Name {
+			if err := database.RenameTeam(db, teamID, *body.Name); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			logging.AuditLog(fmt.Sprintf("api %s (%s): team %d renamed from %s to %s", claims.Name, claims.Role, teamID, team.Name, *body.Name))
+			team.Name = *body.Name
+		}
+		if body.Color != nil && *body.Color != team.Color {
+			if err := database.SetTeamColor(db, teamID, *body.Color); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			logging.AuditLog(fmt.Sprintf("api %s (%s): team %d color changed to %s", claims.Name, claims.Role, teamID, *body.Color))
+			team.Color = *body.Color
+		}
+		if body.Password != nil {
+			if err := database.SetTeamPassword(db, teamID, *body.Password); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			logging.AuditLog(fmt.Sprintf("api %s (%s): team %d password reset", claims.Name, claims.Role, teamID))
+		}
+
+		writeJSON(w, http.StatusOK, TeamInfo{ID: team.ID, Name: team.Name, Color: team.Color})
+	}
+}
+
+// Deletes a team that isn't in the configuration, along with its services and scores (admin only)
+func DeleteTeam(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		team, err := database.GetTeam(db, teamID)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "team not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := scoring.DeleteTeam(db, teamID); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "team not found", http.StatusNotFound)
+			return
+		} else if errors.Is(err, scoring.ErrTeamConfigured) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): team %d (%s) deleted", claims.Name, claims.Role, teamID, team.Name))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Brings the teams and their services in line with the configuration (admin only)
+func ResyncTeams(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary, err := scoring.Resync(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		claims, _ := auth.FromContext(r.Context())
+		logging.AuditLog(fmt.Sprintf("api %s (%s): teams resynced: %s", claims.Name, claims.Role, summary))
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,21 @@
+package api
+
+import "testing"
+
+func TestTeamRequestValidate(t *testing.T) {
+	text := func(s string) *string { return &s }
+
+	if msg := (teamRequest{Name: text("team1"), Color: text("#02c21f"), Password: text("CoffeeBean")}).validate(); msg != "" {
+		t.Fatalf("expected a valid team, got %q", msg)
+	}
+	for _, body := range []teamRequest{
+		{Name: text(" ")},
+		{Color: text("red")},
+		{Password: text("")},
+		{Password: text("$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5")}, // Would make team logins panic
+	} {
+		if msg := body.validate(); msg == "" {
+			t.Fatalf("expected %+v to be refused", body)
+		}
+	}
+}
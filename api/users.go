@@ -0,0 +1,333 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+)
+
+// UserInfo is a user account as returned by the API, without its password hash.
+type UserInfo struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email,omitempty"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Role        string    `json:"role"`
+	TeamID      int       `json:"teamId,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func userInfo(user enum.User) UserInfo {
+	return UserInfo{
+		ID:          user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Role:        user.Role,
+		TeamID:      user.TeamID,
+		CreatedAt:   user.CreatedAt,
+	}
+}
+
+// userRequest is the body of user create and edit requests. Pointers tell an omitted field apart
+// from one being cleared.
+type userRequest struct {
+	Username    string  `json:"username"`
+	Email       *string `json:"email"`
+	DisplayName *string `json:"displayName"`
+	Password    *string `json:"password"`
+	Role        *string `json:"role"`
+	TeamID      *int    `json:"teamId"`
+}
+
+// checkUserTeam validates a user's role and team together: blue team users may belong to a team,
+// other roles can't.
+func checkUserTeam(db *sql.DB, user enum.User) (int, string) {
+	if !auth.ValidRole(user.Role) {
+		return http.StatusBadRequest, "role must be one of admin, white or blue"
+	}
+	if user.TeamID == 0 {
+		return 0, ""
+	}
+	if user.Role != auth.RoleBlue {
+		return http.StatusBadRequest, "only blue team users can belong to a team"
+	}
+	if _, err := database.GetTeam(db, user.TeamID); errors.Is(err, database.ErrNotFound) {
+		return http.StatusBadRequest, "team does not exist"
+	} else if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return 0, ""
+}
+
+// Creates a user account (admin only)
+func CreateUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body userRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if body.Username == "" || body.Password == nil || *body.Password == "" {
+			http.Error(w, "username and password are required", http.StatusBadRequest)
+			return
+		}
+
+		user := enum.User{Username: body.Username, Role: auth.RoleBlue}
+		if body.Email != nil {
+			user.Email = *body.Email
+		}
+		if body.DisplayName != nil {
+			user.DisplayName = *body.DisplayName
+		}
+		if body.Role != nil {
+			user.Role = *body.Role
+		}
+		if body.TeamID != nil {
+			user.TeamID = *body.TeamID
+		}
+		if status, msg := checkUserTeam(db, user); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		hash, err := auth.HashPassword(*body.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user.PasswordHash = hash
+
+		id, err := database.CreateUser(db, user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		user, err = database.GetUser(db, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, userInfo(user))
+	}
+}
+
+// Lists every user account (admin only)
+func ListUsers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := database.ListUsers(db, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]UserInfo, 0, len(users))
+		for _, user := range users {
+			results = append(results, userInfo(user))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// loadUserForCaller fetches the {userID} user, allowing admins to load anyone and other users
+// only themselves. It writes the error response and returns false if the caller can't.
+func loadUserForCaller(db *sql.DB, w http.ResponseWriter, r *http.Request) (enum.User, *auth.Claims, bool) {
+	userID, ok := urlID(w, r, "userID")
+	if !ok {
+		return enum.User{}, nil, false
+	}
+	claims, _ := auth.FromContext(r.Context())
+	if self, isUser := claims.UserID(); claims.Role != auth.RoleAdmin && (!isUser || self != userID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return enum.User{}, nil, false
+	}
+
+	user, err := database.GetUser(db, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return enum.User{}, nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return enum.User{}, nil, false
+	}
+	return user, claims, true
+}
+
+// Returns a user's profile (admins, or the user themselves)
+func GetUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, ok := loadUserForCaller(db, w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, userInfo(user))
+	}
+}
+
+// Edits a user (admins, or the user themselves). Only admins can change a role or team.
+func UpdateUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, claims, ok := loadUserForCaller(db, w, r)
+		if !ok {
+			return
+		}
+
+		var body userRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if (body.Role != nil || body.TeamID != nil) && claims.Role != auth.RoleAdmin {
+			http.Error(w, "only admins can change roles and teams", http.StatusForbidden)
+			return
+		}
+
+		if body.Email != nil {
+			user.Email = *body.Email
+		}
+		if body.DisplayName != nil {
+			user.DisplayName = *body.DisplayName
+		}
+		if body.Role != nil {
+			user.Role = *body.Role
+		}
+		if body.TeamID != nil {
+			user.TeamID = *body.TeamID
+		}
+		if status, msg := checkUserTeam(db, user); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		if body.Password != nil {
+			if *body.Password == "" {
+				http.Error(w, "password cannot be empty", http.StatusBadRequest)
+				return
+			}
+			hash, err := auth.HashPassword(*body.Password)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			user.PasswordHash = hash
+		}
+
+		if err := database.UpdateUser(db, user); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, userInfo(user))
+	}
+}
+
+// Deletes a user account (admin only)
+func DeleteUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := urlID(w, r, "userID")
+		if !ok {
+			return
+		}
+		if err := database.DeleteUser(db, userID); errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Lists the user accounts on a team
+func ListTeamMembers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		users, err := database.ListUsers(db, teamID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		results := make([]UserInfo, 0, len(users))
+		for _, user := range users {
+			results = append(results, userInfo(user))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// Adds a blue team user to a team (admin only)
+func AddTeamMember(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		var body struct {
+			UserID int `json:"userId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.UserID == 0 {
+			http.Error(w, "userId is required", http.StatusBadRequest)
+			return
+		}
+
+		user, err := database.GetUser(db, body.UserID)
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		user.TeamID = teamID
+		if status, msg := checkUserTeam(db, user); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		if err := database.UpdateUser(db, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, userInfo(user))
+	}
+}
+
+// Removes a user from a team (admin only)
+func RemoveTeamMember(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		teamID, ok := urlID(w, r, "teamID")
+		if !ok {
+			return
+		}
+		userID, ok := urlID(w, r, "userID")
+		if !ok {
+			return
+		}
+
+		user, err := database.GetUser(db, userID)
+		if errors.Is(err, database.ErrNotFound) || (err == nil && user.TeamID != teamID) {
+			http.Error(w, "user is not on this team", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		user.TeamID = 0
+		if err := database.UpdateUser(db, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
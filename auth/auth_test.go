@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := HashPassword("CoffeeBean")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !IsPasswordHash(hash) || !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=1,p=4$") {
+		t.Fatalf("unexpected hash format %q", hash)
+	}
+
+	if ok, err := VerifyPassword("CoffeeBean", hash); err != nil || !ok {
+		t.Fatalf("expected the right password to verify, got %v, %v", ok, err)
+	}
+	if ok, err := VerifyPassword("coffeebean", hash); err != nil || ok {
+		t.Fatalf("expected the wrong password to fail, got %v, %v", ok, err)
+	}
+	if _, err := VerifyPassword("CoffeeBean", "CoffeeBean"); err == nil {
+		t.Fatal("expected a plaintext value to be rejected as a hash")
+	}
+
+	// Salts make every hash different
+	again, _ := HashPassword("CoffeeBean")
+	if again == hash {
+		t.Fatal("expected two hashes of the same password to differ")
+	}
+}
+
+func TestMalformedPasswordHashes(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	if err := ValidatePasswordHash("$argon2id$v=19$m=65536,t=1,p=4$" + salt + "$" + key); err != nil {
+		t.Fatalf("expected a well formed hash to be accepted, got %v", err)
+	}
+
+	// Each of these would make argon2 panic or run away, so they are refused without hashing
+	for _, hash := range []string{
+		"$argon2id$v=19$m=65536,t=0,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=0,t=1,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=4$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=300$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=4$$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=4$" + salt + "$",
+		"$argon2id$v=19$m=65536,t=1,p=4$not base64!$" + key,
+		"$argon2id$v=18$m=65536,t=1,p=4$" + salt + "$" + key,
+		"$argon2id$",
+	} {
+		if err := ValidatePasswordHash(hash); err == nil {
+			t.Fatalf("expected %q to be refused", hash)
+		}
+		if ok, err := VerifyPassword("CoffeeBean", hash); ok || err == nil {
+			t.Fatalf("expected verifying against %q to fail, got %v, %v", hash, ok, err)
+		}
+	}
+}
+
+func TestRandomPassword(t *testing.T) {
+	password, err := RandomPassword(16)
+	if err != nil {
+		t.Fatalf("RandomPassword failed: %v", err)
+	}
+	if len(password) != 16 || strings.Trim(password, passwordAlphabet) != "" {
+		t.Fatalf("unexpected password %q", password)
+	}
+	if again, _ := RandomPassword(16); again == password {
+		t.Fatal("expected two random passwords to differ")
+	}
+}
+
+func TestTokensAndMiddleware(t *testing.T) {
+	cfg := Config{Secret: []byte("test-secret"), AccessTTL: time.Minute, RefreshTTL: time.Hour}
+
+	token, err := cfg.IssueAccessToken(TeamSubject(3), "Team 3", RoleBlue, 3)
+	if err != nil {
+		t.Fatalf("IssueAccessToken failed: %v", err)
+	}
+	claims, err := cfg.ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken failed: %v", err)
+	}
+	if claims.Role != RoleBlue || claims.TeamID != 3 || claims.Name != "Team 3" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if _, ok := claims.UserID(); ok {
+		t.Fatal("a team login should not have a user ID")
+	}
+	if !claims.CanViewTeam(3) || claims.CanViewTeam(4) {
+		t.Fatal("blue team should only see its own team")
+	}
+
+	// A token signed with another key, or expired, is rejected
+	other := Config{Secret: []byte("other-secret"), AccessTTL: time.Minute}
+	if _, err := other.ParseAccessToken(token); err == nil {
+		t.Fatal("expected a token signed with another key to be rejected")
+	}
+	expired := Config{Secret: cfg.Secret, AccessTTL: -time.Minute}
+	old, _ := expired.IssueAccessToken(UserSubject(1), "admin", RoleAdmin, 0)
+	if _, err := cfg.ParseAccessToken(old); err == nil {
+		t.Fatal("expected an expired token to be rejected")
+	}
+
+	handler := cfg.Authenticate(RequireRole(RoleAdmin, RoleWhite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	request := func(header string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	admin, _ := cfg.IssueAccessToken(UserSubject(1), "admin", RoleAdmin, 0)
+	if code := request("Bearer " + admin); code != http.StatusNoContent {
+		t.Fatalf("expected admin to be let through, got %d", code)
+	}
+	if code := request("Bearer " + token); code != http.StatusForbidden {
+		t.Fatalf("expected blue team to be forbidden, got %d", code)
+	}
+	if code := request(""); code != http.StatusUnauthorized {
+		t.Fatalf("expected no token to be unauthorized, got %d", code)
+	}
+
+	// Refresh tokens are stored hashed
+	refresh, hash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("NewRefreshToken failed: %v", err)
+	}
+	if hash == refresh || HashRefreshToken(refresh) != hash {
+		t.Fatal("expected the stored hash to be derived from the token")
+	}
+}
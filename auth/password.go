@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters for new hashes. Existing hashes carry their own parameters, so these can
+// be raised later without invalidating stored passwords.
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// HashPassword hashes a password with argon2id, returning it in the PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+// passwordAlphabet leaves out characters that are easy to mix up when a password is read aloud
+// or copied off a screen, like 0 and O or 1 and l.
+const passwordAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// RandomPassword generates a password of length characters, for handing out to teams.
+func RandomPassword(length int) (string, error) {
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordAlphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate password: %w", err)
+		}
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// IsPasswordHash reports whether s looks like a hash produced by HashPassword.
+func IsPasswordHash(s string) bool {
+	return strings.HasPrefix(s, "$argon2id$")
+}
+
+// The most memory, in KiB, a hash may ask for, so a hash can't make every login use up the
+// engine's memory.
+const maxArgonMemory = 1024 * 1024
+
+// argonHash is a decoded argon2id hash.
+type argonHash struct {
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// decodePasswordHash parses an argon2id hash in the format HashPassword produces, checking its
+// parameters are ones argon2id can run with.
+func decodePasswordHash(encoded string) (argonHash, error) {
+	var h argonHash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return h, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return h, fmt.Errorf("invalid argon2 parameters %q: %w", parts[3], err)
+	}
+	if h.time < 1 || h.threads < 1 {
+		return h, fmt.Errorf("invalid argon2 parameters %q: t and p must be at least 1", parts[3])
+	}
+	if h.memory < 8*uint32(h.threads) || h.memory > maxArgonMemory {
+		return h, fmt.Errorf("invalid argon2 parameters %q: m must be between 8*p and %d", parts[3], maxArgonMemory)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.salt) == 0 {
+		return h, fmt.Errorf("invalid salt: %q", parts[4])
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return h, fmt.Errorf("invalid hash: %q", parts[5])
+	}
+	return h, nil
+}
+
+// ValidatePasswordHash checks that encoded is an argon2id hash that VerifyPassword can check
+// passwords against, for hashes supplied by hand rather than made by HashPassword.
+func ValidatePasswordHash(encoded string) error {
+	_, err := decodePasswordHash(encoded)
+	return err
+}
+
+// VerifyPassword reports whether password matches the encoded argon2id hash.
+func VerifyPassword(password, encoded string) (bool, error) {
+	h, err := decodePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	got := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(got, h.key) == 1, nil
+}
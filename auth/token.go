@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles a signed in user can hold
+const (
+	RoleAdmin = "admin" // Runs the competition, can do anything
+	RoleBlue  = "blue"  // A competing team, limited to its own team
+
+	// White team: can see every team's private data, start, stop, pause and resume the engine,
+	// and create, edit and grade injects. It can't change teams, users, scores or services, and
+	// routes should say so explicitly rather than assume it is read only.
+	RoleWhite = "white"
+)
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleWhite || role == RoleBlue
+}
+
+// Config holds the key and lifetimes used to issue tokens.
+type Config struct {
+	Secret     []byte        // The HMAC key access tokens are signed with
+	AccessTTL  time.Duration // How long an access token is valid for
+	RefreshTTL time.Duration // How long a refresh token is valid for
+}
+
+// Claims are the contents of an access token.
+type Claims struct {
+	Name   string `json:"name"`              // The username, or team name for team logins
+	Role   string `json:"role"`              // One of the Role constants
+	TeamID int    `json:"team_id,omitempty"` // The team the holder belongs to, if any
+	jwt.RegisteredClaims
+}
+
+// UserSubject and TeamSubject build the subject of a token for a user account or a team login.
+func UserSubject(userID int) string { return fmt.Sprintf("user:%d", userID) }
+func TeamSubject(teamID int) string { return fmt.Sprintf("team:%d", teamID) }
+
+// UserID returns the user ID the claims were issued to, or false for team logins.
+func (c *Claims) UserID() (int, bool) {
+	var id int
+	if _, err := fmt.Sscanf(c.Subject, "user:%d", &id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// IssueAccessToken signs a new access token for the given subject.
+func (cfg Config) IssueAccessToken(subject, name, role string, teamID int) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Name:   name,
+		Role:   role,
+		TeamID: teamID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    "nest",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(cfg.Secret)
+}
+
+// ParseAccessToken verifies an access token and returns its claims.
+func (cfg Config) ParseAccessToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return cfg.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer("nest"), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if !ValidRole(claims.Role) {
+		return nil, fmt.Errorf("token has unknown role %q", claims.Role)
+	}
+	return claims, nil
+}
+
+// NewRefreshToken generates an opaque refresh token, returning the token to hand to the client
+// and the hash to store. Only the hash is ever stored, so a leaked table can't be replayed.
+func NewRefreshToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the stored form of a refresh token.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomSecret returns a random signing key, for when no secret is configured.
+func RandomSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return secret, nil
+}
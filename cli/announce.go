@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+)
+
+// prompt asks a question and reads the answer on its own line.
+func prompt(question string) (string, error) {
+	logging.ConsoleLogMessage(question)
+	line, err := rl.Readline()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// parseTime reads when something should happen, such as an announcement being published: blank
+// for now, a delay such as +10m, or a time in RFC 3339 or "2006-01-02 15:04" (local time) form.
+func parseTime(input string, now time.Time) (time.Time, error) {
+	switch {
+	case input == "":
+		return now, nil
+	case strings.HasPrefix(input, "+"):
+		delay, err := time.ParseDuration(input[1:])
+		if err != nil || delay < 0 {
+			return time.Time{}, fmt.Errorf("invalid delay %q", input)
+		}
+		return now.Add(delay), nil
+	}
+	if t, err := time.Parse(time.RFC3339, input); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", input, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use +10m, 2006-01-02 15:04 or RFC 3339", input)
+}
+
+// announce handles the announce command: with no arguments it posts a new announcement,
+// otherwise it lists or hides them.
+func announce(db *sql.DB, tokens []string) {
+	if len(tokens) == 1 {
+		createAnnouncement(db)
+		return
+	}
+
+	switch strings.ToLower(tokens[1]) {
+	case "list":
+		listAnnouncements(db)
+	case "hide", "show":
+		if len(tokens) != 3 {
+			logging.ConsoleLogMessage("Usage: announce [hide|show] <id>")
+			return
+		}
+		id, err := strconv.Atoi(tokens[2])
+		if err != nil {
+			logging.ConsoleLogError("Invalid announcement ID. Must be an integer.")
+			return
+		}
+		setAnnouncementVisible(db, id, strings.ToLower(tokens[1]) == "show")
+	default:
+		logging.ConsoleLogMessage("Usage: announce [list|hide <id>|show <id>]")
+	}
+}
+
+func createAnnouncement(db *sql.DB) {
+	title, err := prompt("Title: ")
+	if err != nil || title == "" {
+		logging.ConsoleLogError("An announcement needs a title.")
+		return
+	}
+	content, err := prompt("Content: ")
+	if err != nil || content == "" {
+		logging.ConsoleLogError("An announcement needs some content.")
+		return
+	}
+	when, err := prompt("Publish at (blank for now, +10m, or 2006-01-02 15:04): ")
+	if err != nil {
+		return
+	}
+	publishAt, err := parseTime(when, time.Now())
+	if err != nil {
+		logging.ConsoleLogError(err.Error())
+		return
+	}
+
+	id, err := database.CreateAnnouncement(db, enum.Announcement{Title: title, Content: content, Author: "console", PublishAt: publishAt})
+	if err != nil {
+		logging.ConsoleLogError("Error creating announcement: " + err.Error())
+		return
+	}
+	logging.AuditLog(fmt.Sprintf("announcement %d created, publishing at %s", id, publishAt.Format(time.RFC3339)))
+	logging.ConsoleLogSuccess(fmt.Sprintf("Announcement %d created, publishing at %s.", id, publishAt.Format("2006-01-02 15:04:05")))
+}
+
+func listAnnouncements(db *sql.DB) {
+	announcements, err := database.ListAnnouncements(db, true)
+	if err != nil {
+		logging.ConsoleLogError("Error listing announcements: " + err.Error())
+		return
+	}
+	if len(announcements) == 0 {
+		logging.ConsoleLogMessage("No announcements.")
+		return
+	}
+
+	now := time.Now()
+	for _, a := range announcements {
+		state := "published"
+		if !a.Visible {
+			state = "hidden"
+		} else if a.PublishAt.After(now) {
+			state = "scheduled"
+		}
+		fmt.Printf("%d\t%s\t%-9s\t%s (%s)\n", a.ID, a.PublishAt.Format("2006-01-02 15:04"), state, a.Title, a.Author)
+	}
+}
+
+func setAnnouncementVisible(db *sql.DB, id int, visible bool) {
+	a, err := database.GetAnnouncement(db, id)
+	if err != nil {
+		logging.ConsoleLogError(fmt.Sprintf("Error loading announcement %d: %v", id, err))
+		return
+	}
+	a.Visible = visible
+	if err := database.UpdateAnnouncement(db, a); err != nil {
+		logging.ConsoleLogError("Error updating announcement: " + err.Error())
+		return
+	}
+	logging.ConsoleLogSuccess(fmt.Sprintf("Announcement %d updated.", id))
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		input string
+		want  time.Time
+	}{
+		{"", now},
+		{"+10m", now.Add(10 * time.Minute)},
+		{"+1h30m", now.Add(90 * time.Minute)},
+		{"2025-03-01 14:30", time.Date(2025, 3, 1, 14, 30, 0, 0, time.Local)},
+		{"2025-03-01T14:30:00Z", time.Date(2025, 3, 1, 14, 30, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, err := parseTime(c.input, now)
+		if err != nil {
+			t.Errorf("parseTime(%q) failed: %v", c.input, err)
+		} else if !got.Equal(c.want) {
+			t.Errorf("parseTime(%q) = %v, want %v", c.input, got, c.want)
+		}
+	}
+
+	for _, input := range []string{"+-5m", "+soon", "tomorrow", "2025-03-01"} {
+		if _, err := parseTime(input, now); err == nil {
+			t.Errorf("expected parseTime(%q) to fail", input)
+		}
+	}
+}
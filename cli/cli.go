@@ -19,7 +19,6 @@ import (
 )
 
 const (
-	auditLogFile  = "audit.log"
 	historyFile   = "cli_history.txt"
 	historyMaxLen = 100 // Maximum number of commands to keep in memory
 )
@@ -105,7 +104,7 @@ func RunCLI(db *sql.DB, Version string, newlogger *logging.Logger) {
 		}
 
 		// Audit log the entered command.
-		auditLog(line)
+		logging.AuditLog(line)
 
 		// Process the command.
 		processCommand(line, db, Version)
@@ -118,21 +117,6 @@ func clearTerminal() {
 	fmt.Print("\033[H\033[2J")
 }
 
-// auditLog writes a timestamped log of each command to a file.
-func auditLog(action string) {
-	f, err := os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error opening audit log file: %v\n", err)
-		return
-	}
-	defer f.Close()
-	timestamp := time.Now().Format(time.RFC3339)
-	logLine := fmt.Sprintf("%s: %s\n", timestamp, action)
-	if _, err := f.WriteString(logLine); err != nil {
-		fmt.Printf("Error writing to audit log file: %v\n", err)
-	}
-}
-
 // processCommand tokenizes the input and calls the appropriate function.
 func processCommand(input string, db *sql.DB, Version string) {
 	tokens := strings.Fields(input)
@@ -150,13 +134,15 @@ func processCommand(input string, db *sql.DB, Version string) {
 	case "version", "--version":
 		printVersion(Version) // Assuming printVersion() internally uses logging or fmt, update if necessary
 	case "score":
-		// Expected: score check
+		// Expected: score check, or score adjust <team id> <delta> <reason>
 		if len(tokens) > 1 && tokens[1] == "check" {
 			if err := database.CheckTeamScores(db); err != nil {
 				logging.ConsoleLogError("Error checking team scores: " + err.Error())
 			}
+		} else if len(tokens) > 1 && tokens[1] == "adjust" {
+			adjustScore(db, tokens)
 		} else {
-			logging.ConsoleLogMessage("Usage: score check")
+			logging.ConsoleLogMessage("Usage: score [check|adjust <team id> <delta> <reason>]")
 		}
 	case "uptime":
 		// Expected: uptime validate
@@ -177,48 +163,9 @@ func processCommand(input string, db *sql.DB, Version string) {
 			logging.ConsoleLogMessage("Usage: report generate")
 		}
 	case "team":
-		if len(tokens) < 2 {
-			logging.ConsoleLogMessage("Usage: team [create|edit|view]")
-			return
-		}
-		subcmd := strings.ToLower(tokens[1])
-		switch subcmd {
-		case "create":
-			// Usage: team create <name>
-			if len(tokens) != 3 {
-				logging.ConsoleLogMessage("Usage: team create <name>")
-				return
-			}
-
-			newTeam := enum.Team{
-				Name:  tokens[2],
-				Color: generateRandomColor(),
-			}
-
-			if err := database.AddTeamToDatabase(db, newTeam, rl); err != nil {
-				logging.ConsoleLogError("Error adding team to database: " + err.Error())
-			}
-		case "edit":
-			// Usage: team edit <id> <newname>
-			if len(tokens) != 4 {
-				logging.ConsoleLogMessage("Usage: team edit <id> <newname>")
-				return
-			}
-			id, err := strconv.Atoi(tokens[2])
-			if err != nil {
-				logging.ConsoleLogError("Invalid team ID. Must be an integer.")
-				return
-			}
-			if err := database.EditTeam(id, tokens[3], db); err != nil {
-				logging.ConsoleLogError("Error editing team: " + err.Error())
-			}
-		case "view":
-			if err := database.ViewTeams(db); err != nil {
-				logging.ConsoleLogError("Error viewing teams: " + err.Error())
-			}
-		default:
-			logging.ConsoleLogMessage("Unknown team command. Use: team [create|edit|view]")
-		}
+		team(db, tokens)
+	case "service":
+		service(db, tokens)
 	case "logs":
 		// Expected: logs view <logtype>
 		if len(tokens) > 2 && tokens[1] == "view" {
@@ -233,14 +180,25 @@ func processCommand(input string, db *sql.DB, Version string) {
 		} else {
 			logging.ConsoleLogMessage("Usage: logs view <logtype>")
 		}
+	case "announce":
+		announce(db, tokens)
+	case "migrate":
+		Migrate(db, tokens)
+	case "config":
+		// Expected: config reload
+		if len(tokens) == 2 && tokens[1] == "reload" {
+			reloadConfig(db)
+		} else {
+			logging.ConsoleLogMessage("Usage: config reload")
+		}
 	case "start":
-		scoring.StartEngine()
+		engineControl(scoring.StartEngine, "Engine started.")
 	case "stop":
-		scoring.StopEngine()
+		engineControl(scoring.StopEngine, "Engine stopped.")
 	case "pause":
-		scoring.PauseEngine()
+		engineControl(scoring.PauseEngine, "Engine paused.")
 	case "resume":
-		scoring.ResumeEngine()
+		engineControl(scoring.ResumeEngine, "Engine resumed.")
 	case "state":
 		logging.ConsoleLogMessage(scoring.GetEngineState())
 	default:
@@ -248,6 +206,56 @@ func processCommand(input string, db *sql.DB, Version string) {
 	}
 }
 
+// adjustScore records a manual score change: score adjust <team id> <delta> <reason>
+func adjustScore(db *sql.DB, tokens []string) {
+	if len(tokens) < 5 {
+		logging.ConsoleLogMessage("Usage: score adjust <team id> <delta> <reason>")
+		return
+	}
+	teamID, err := strconv.Atoi(tokens[2])
+	if err != nil {
+		logging.ConsoleLogError("Invalid team ID. Must be an integer.")
+		return
+	}
+	delta, err := strconv.Atoi(tokens[3])
+	if err != nil || delta == 0 {
+		logging.ConsoleLogError("Invalid delta. Must be a non-zero integer, such as 50 or -50.")
+		return
+	}
+	if _, err := database.GetTeam(db, teamID); err != nil {
+		logging.ConsoleLogError(fmt.Sprintf("Error finding team %d: %v", teamID, err))
+		return
+	}
+
+	adjustment := enum.Adjustment{TeamID: teamID, Delta: delta, Reason: strings.Join(tokens[4:], " "), Author: "console"}
+	if _, err := database.CreateAdjustment(db, adjustment); err != nil {
+		logging.ConsoleLogError("Error adjusting score: " + err.Error())
+		return
+	}
+	logging.ConsoleLogSuccess(fmt.Sprintf("Team %d's score adjusted by %+d.", teamID, delta))
+}
+
+// reloadConfig re-reads main.yaml and reports what changed.
+func reloadConfig(db *sql.DB) {
+	logging.ConsoleLogMessage("Reloading the configuration, waiting for the current round to finish...")
+	summary, err := scoring.Reload(db)
+	if err != nil {
+		logging.ConsoleLogError("Error reloading the configuration: " + err.Error())
+		return
+	}
+	logging.AuditLog(fmt.Sprintf("configuration reloaded: %s", summary))
+	logging.ConsoleLogSuccess(fmt.Sprintf("Configuration reloaded: %s.", summary))
+}
+
+// engineControl runs an engine control and reports how it went.
+func engineControl(control func() error, success string) {
+	if err := control(); err != nil {
+		logging.ConsoleLogError(fmt.Sprintf("Could not change the engine state: %v", err))
+		return
+	}
+	logging.ConsoleLogSuccess(success)
+}
+
 func printHelp() {
 	helpText := `
 Available commands:
@@ -257,15 +265,35 @@ Available commands:
   version | --version              					- Show CLI version.
   
   score check                      					- Check team scores.
+  score adjust <team id> <delta> <reason>			- Add (or with a negative delta, remove) points from a team.
   uptime validate                  					- Validate service uptime.
   report generate                  					- Generate a YAML report.
 
-  team create <name>           						- Create a new team.
+  team create <name>           						- Create a new team with every configured service.
   team edit <id> <newname>           				- Edit an existing team.
+  team color <id> <color>          					- Change a team's color, such as #02c21f.
+  team delete <id>                 					- Delete a team that isn't in main.yaml and all of its scores.
   team view                        					- View all teams.
+  team resync                      					- Bring the teams and services in the database in line with main.yaml.
+  team password reset <id>         					- Give a team a new password (typed or generated) and sign it out.
+  team password hash               					- Print the hash of a password, for the teams section of main.yaml.
+
+  service list                     					- List services, who they are disabled for, and scheduled toggles.
+  service disable <service|box> [team id]			- Stop scoring a service or box, now or later and optionally until a set time.
+  service enable <service|box> [team id]			- Score a service or box again, now or later.
+  service cancel <toggle id>       					- Cancel a scheduled disable or enable.
 
   logs view <logtype>              					- View logs.
 
+  announce                         					- Post an announcement, optionally scheduled.
+  announce list                    					- List every announcement.
+  announce hide|show <id>          					- Hide or unhide an announcement.
+
+  migrate status                   					- List the database migrations and whether they are applied.
+  migrate up                       					- Apply the pending database migrations.
+
+  config reload                    					- Re-read main.yaml and apply it between rounds, without a restart.
+
   start                            					- Start the engine.
   stop                             					- Stop the engine.
   pause                            					- Pause the engine.
@@ -280,7 +308,7 @@ func printVersion(Version string) {
 }
 
 func viewAuditLogs() {
-	data, err := ioutil.ReadFile(auditLogFile)
+	data, err := ioutil.ReadFile(logging.AuditLogFile)
 	if err != nil {
 		fmt.Printf("Error reading audit logs: %v\n", err)
 		return
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/logging"
+)
+
+// errMigrateUsage is returned by Migrate when it isn't given a known subcommand.
+var errMigrateUsage = errors.New("usage: migrate [status|up]")
+
+// Migrate handles the migrate command: migrate status lists every database migration and
+// whether it has been applied, migrate up applies the pending ones. It is also run before
+// startup by "scoring-engine migrate", which exits with a failure if Migrate returns an error.
+func Migrate(db *sql.DB, tokens []string) error {
+	if len(tokens) != 2 {
+		logging.ConsoleLogMessage("Usage: migrate [status|up]")
+		return errMigrateUsage
+	}
+
+	switch strings.ToLower(tokens[1]) {
+	case "status":
+		migrations, err := database.MigrationStatus(db)
+		if err != nil {
+			logging.ConsoleLogError("Error reading migrations: " + err.Error())
+			return err
+		}
+		for _, m := range migrations {
+			state := "pending"
+			if m.Applied() {
+				state = "applied " + m.AppliedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%04d\t%-28s\t%s\n", m.Version, m.Name, state)
+		}
+	case "up":
+		applied, err := database.Migrate(db)
+		for _, m := range applied {
+			logging.ConsoleLogSuccess(fmt.Sprintf("Applied migration %04d_%s.", m.Version, m.Name))
+		}
+		if err != nil {
+			logging.ConsoleLogError("Error migrating the database: " + err.Error())
+			return err
+		}
+		if len(applied) == 0 {
+			logging.ConsoleLogMessage("The database is up to date.")
+		}
+	default:
+		logging.ConsoleLogMessage("Usage: migrate [status|up]")
+		return errMigrateUsage
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+	"github.com/LTSEC/NEST/scoring"
+)
+
+// service handles the service commands, for taking services out of scoring and putting them back.
+func service(db *sql.DB, tokens []string) {
+	if len(tokens) < 2 {
+		logging.ConsoleLogMessage("Usage: service [list|disable|enable|cancel]")
+		return
+	}
+
+	switch strings.ToLower(tokens[1]) {
+	case "list":
+		listServices(db)
+	case "disable", "enable":
+		// Usage: service disable|enable <service|box> [team id]
+		if len(tokens) != 3 && len(tokens) != 4 {
+			logging.ConsoleLogMessage(fmt.Sprintf("Usage: service %s <service|box> [team id]", strings.ToLower(tokens[1])))
+			return
+		}
+		teamID := 0
+		if len(tokens) == 4 {
+			id, ok := parseTeamID(tokens[3])
+			if !ok {
+				return
+			}
+			teamID = id
+		}
+		toggleService(db, tokens[2], teamID, strings.ToLower(tokens[1]) == "disable")
+	case "cancel":
+		// Usage: service cancel <toggle id>
+		if len(tokens) != 3 {
+			logging.ConsoleLogMessage("Usage: service cancel <toggle id>")
+			return
+		}
+		id, err := strconv.Atoi(tokens[2])
+		if err != nil {
+			logging.ConsoleLogError("Invalid toggle ID. Must be an integer.")
+			return
+		}
+		if err := database.CancelServiceToggle(db, id); errors.Is(err, database.ErrNotFound) {
+			logging.ConsoleLogError(fmt.Sprintf("There is no scheduled toggle %d.", id))
+		} else if err != nil {
+			logging.ConsoleLogError("Error cancelling toggle: " + err.Error())
+		} else {
+			logging.AuditLog(fmt.Sprintf("service toggle %d cancelled", id))
+			logging.ConsoleLogSuccess(fmt.Sprintf("Toggle %d cancelled.", id))
+		}
+	default:
+		logging.ConsoleLogMessage("Unknown service command. Use: service [list|disable|enable|cancel]")
+	}
+}
+
+// toggleService disables or enables a service, or every service on a box, asking when and why.
+func toggleService(db *sql.DB, target string, teamID int, disabled bool) {
+	action := "Enable"
+	if disabled {
+		action = "Disable"
+	}
+	when, err := prompt(action + " at (blank for now, +10m, or 2006-01-02 15:04): ")
+	if err != nil {
+		return
+	}
+	runAt, err := parseTime(when, time.Now())
+	if err != nil {
+		logging.ConsoleLogError(err.Error())
+		return
+	}
+
+	var until time.Time
+	if disabled {
+		answer, err := prompt("Enable again at (blank to leave it disabled, +1h, or 2006-01-02 15:04): ")
+		if err != nil {
+			return
+		}
+		if answer != "" {
+			// A delay counts from when the service is disabled
+			if until, err = parseTime(answer, runAt); err != nil {
+				logging.ConsoleLogError(err.Error())
+				return
+			}
+		}
+	}
+	reason, err := prompt("Reason (optional): ")
+	if err != nil {
+		return
+	}
+
+	toggle := enum.ServiceToggle{TeamID: teamID, Disabled: disabled, RunAt: runAt, Reason: reason, Author: "console"}
+	toggles, err := scoring.ToggleServices(db, target, toggle, until)
+	for _, t := range toggles {
+		logging.AuditLog(fmt.Sprintf("service toggle %d, %s, at %s", t.ID, scoring.DescribeToggle(t), t.RunAt.Format(time.RFC3339)))
+		if t.AppliedAt.IsZero() {
+			logging.ConsoleLogSuccess(fmt.Sprintf("Toggle %d scheduled for %s: %s.", t.ID, t.RunAt.Format("2006-01-02 15:04:05"), scoring.DescribeToggle(t)))
+		} else {
+			logging.ConsoleLogSuccess(fmt.Sprintf("Toggle %d: %s.", t.ID, scoring.DescribeToggle(t)))
+		}
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		logging.ConsoleLogError(fmt.Sprintf("There is no service or box named %s, or the team does not have it.", target))
+	} else if err != nil {
+		logging.ConsoleLogError("Error toggling service: " + err.Error())
+	}
+}
+
+// listServices prints every service with who it is disabled for, then the scheduled toggles.
+func listServices(db *sql.DB) {
+	services, err := database.ListServices(db)
+	if err != nil {
+		logging.ConsoleLogError("Error listing services: " + err.Error())
+		return
+	}
+	if len(services) == 0 {
+		logging.ConsoleLogMessage("No services.")
+	}
+	for _, s := range services {
+		state := "enabled"
+		switch {
+		case s.Retired:
+			state = "retired"
+		case s.Disabled:
+			state = "disabled"
+		case len(s.DisabledTeams) > 0:
+			teams := make([]string, 0, len(s.DisabledTeams))
+			for _, id := range s.DisabledTeams {
+				teams = append(teams, strconv.Itoa(id))
+			}
+			state = "disabled for teams " + strings.Join(teams, ", ")
+		}
+		fmt.Printf("%d\t%s\t%s\n", s.ID, s.Name, state)
+	}
+
+	toggles, err := database.ListServiceToggles(db, false)
+	if err != nil {
+		logging.ConsoleLogError("Error listing scheduled toggles: " + err.Error())
+		return
+	}
+	if len(toggles) == 0 {
+		return
+	}
+	logging.ConsoleLogMessage("Scheduled toggles:")
+	for i := len(toggles) - 1; i >= 0; i-- { // Soonest first
+		t := toggles[i]
+		fmt.Printf("%d\t%s\t%s (%s)\n", t.ID, t.RunAt.Format("2006-01-02 15:04"), scoring.DescribeToggle(t), t.Author)
+	}
+}
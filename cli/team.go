@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+	"github.com/LTSEC/NEST/scoring"
+)
+
+// teamPasswordLength is the length of the passwords generated when a team's password is reset.
+const teamPasswordLength = 16
+
+// promptPassword asks for a password without echoing it or saving it to the CLI history.
+func promptPassword(question string) (string, error) {
+	password, err := rl.ReadPassword(question)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(password)), nil
+}
+
+// hexColor matches the colors teams are shown in, such as #02c21f.
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// team handles the team commands, for managing teams while the game runs.
+func team(db *sql.DB, tokens []string) {
+	if len(tokens) < 2 {
+		logging.ConsoleLogMessage("Usage: team [create|edit|color|delete|view|resync|password]")
+		return
+	}
+
+	switch strings.ToLower(tokens[1]) {
+	case "create":
+		// Usage: team create <name>
+		if len(tokens) != 3 {
+			logging.ConsoleLogMessage("Usage: team create <name>")
+			return
+		}
+		createTeam(db, tokens[2])
+	case "edit":
+		// Usage: team edit <id> <newname>
+		if len(tokens) != 4 {
+			logging.ConsoleLogMessage("Usage: team edit <id> <newname>")
+			return
+		}
+		id, ok := parseTeamID(tokens[2])
+		if !ok {
+			return
+		}
+		if err := database.EditTeam(id, tokens[3], db); err != nil {
+			logging.ConsoleLogError("Error editing team: " + err.Error())
+		}
+	case "color":
+		// Usage: team color <id> <color>
+		if len(tokens) != 4 || !hexColor.MatchString(tokens[3]) {
+			logging.ConsoleLogMessage("Usage: team color <id> <color>, where color is like #02c21f")
+			return
+		}
+		id, ok := parseTeamID(tokens[2])
+		if !ok {
+			return
+		}
+		if err := database.SetTeamColor(db, id, tokens[3]); errors.Is(err, database.ErrNotFound) {
+			logging.ConsoleLogError(fmt.Sprintf("Team %d does not exist.", id))
+		} else if err != nil {
+			logging.ConsoleLogError("Error changing team color: " + err.Error())
+		} else {
+			logging.ConsoleLogSuccess(fmt.Sprintf("Team %d's color changed to %s.", id, tokens[3]))
+		}
+	case "delete":
+		// Usage: team delete <id>
+		if len(tokens) != 3 {
+			logging.ConsoleLogMessage("Usage: team delete <id>")
+			return
+		}
+		if id, ok := parseTeamID(tokens[2]); ok {
+			deleteTeam(db, id)
+		}
+	case "view":
+		if err := database.ViewTeams(db); err != nil {
+			logging.ConsoleLogError("Error viewing teams: " + err.Error())
+		}
+	case "resync":
+		summary, err := scoring.Resync(db)
+		if err != nil {
+			logging.ConsoleLogError("Error resyncing teams: " + err.Error())
+			return
+		}
+		logging.ConsoleLogSuccess(fmt.Sprintf("Resync complete: %s.", summary))
+	case "password":
+		teamPassword(db, tokens)
+	default:
+		logging.ConsoleLogMessage("Unknown team command. Use: team [create|edit|color|delete|view|resync|password]")
+	}
+}
+
+// parseTeamID reads a team ID argument, reporting when it isn't a number.
+func parseTeamID(arg string) (int, bool) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		logging.ConsoleLogError("Invalid team ID. Must be an integer.")
+		return 0, false
+	}
+	return id, true
+}
+
+// createTeam adds a team at runtime, asking for its password, and gives it every configured service.
+func createTeam(db *sql.DB, name string) {
+	password, err := promptPassword("Enter a password: ")
+	if err != nil || password == "" {
+		logging.ConsoleLogError("No password given.")
+		return
+	}
+
+	id, err := scoring.CreateTeam(db, enum.Team{Name: name, Password: password, Color: generateRandomColor()})
+	if err != nil {
+		logging.ConsoleLogError("Error adding team to database: " + err.Error())
+		return
+	}
+	logging.ConsoleLogSuccess(fmt.Sprintf("Team %s created with ID %d.", name, id))
+}
+
+// deleteTeam deletes a team after the name is typed back, since its scores go with it.
+func deleteTeam(db *sql.DB, id int) {
+	team, err := database.GetTeam(db, id)
+	if errors.Is(err, database.ErrNotFound) {
+		logging.ConsoleLogError(fmt.Sprintf("Team %d does not exist.", id))
+		return
+	} else if err != nil {
+		logging.ConsoleLogError("Error finding team: " + err.Error())
+		return
+	}
+	if scoring.IsConfiguredTeam(id) {
+		logging.ConsoleLogError(fmt.Sprintf("Team %s is in the configuration; remove it from main.yaml and reload first, or the next resync brings it back.", team.Name))
+		return
+	}
+
+	answer, err := prompt(fmt.Sprintf("This deletes %s and all of its scores. Type the team's name to confirm: ", team.Name))
+	if err != nil || answer != team.Name {
+		logging.ConsoleLogMessage("Team not deleted.")
+		return
+	}
+	if err := scoring.DeleteTeam(db, id); err != nil {
+		logging.ConsoleLogError("Error deleting team: " + err.Error())
+		return
+	}
+	logging.AuditLog(fmt.Sprintf("team %d (%s) deleted", id, team.Name))
+	logging.ConsoleLogSuccess(fmt.Sprintf("Team %s deleted.", team.Name))
+}
+
+// teamPassword handles team password: team password reset <id> gives a team a new password and
+// team password hash prints the hash of a password for the teams section of the YAML. Passwords
+// are asked for rather than taken as arguments, which would put them in the audit log.
+func teamPassword(db *sql.DB, tokens []string) {
+	if len(tokens) < 3 {
+		logging.ConsoleLogMessage("Usage: team password [reset <id>|hash]")
+		return
+	}
+
+	switch strings.ToLower(tokens[2]) {
+	case "reset":
+		if len(tokens) != 4 {
+			logging.ConsoleLogMessage("Usage: team password reset <id>")
+			return
+		}
+		if id, ok := parseTeamID(tokens[3]); ok {
+			resetTeamPassword(db, id)
+		}
+	case "hash":
+		password, err := promptPassword("Password to hash: ")
+		if err != nil || password == "" {
+			logging.ConsoleLogError("No password given.")
+			return
+		}
+		hash, err := auth.HashPassword(password)
+		if err != nil {
+			logging.ConsoleLogError("Error hashing password: " + err.Error())
+			return
+		}
+		fmt.Println(hash)
+	default:
+		logging.ConsoleLogMessage("Usage: team password [reset <id>|hash]")
+	}
+}
+
+// resetTeamPassword rotates a team's password, to one that is typed in or, if left blank, a
+// random one, and signs the team out.
+func resetTeamPassword(db *sql.DB, id int) {
+	team, err := database.GetTeam(db, id)
+	if errors.Is(err, database.ErrNotFound) {
+		logging.ConsoleLogError(fmt.Sprintf("Team %d does not exist.", id))
+		return
+	} else if err != nil {
+		logging.ConsoleLogError("Error finding team: " + err.Error())
+		return
+	}
+
+	password, err := promptPassword(fmt.Sprintf("New password for %s (leave blank to generate one): ", team.Name))
+	if err != nil {
+		logging.ConsoleLogError("Error reading password: " + err.Error())
+		return
+	}
+	generated := password == ""
+	if generated {
+		if password, err = auth.RandomPassword(teamPasswordLength); err != nil {
+			logging.ConsoleLogError("Error generating password: " + err.Error())
+			return
+		}
+	}
+
+	if err := database.SetTeamPassword(db, id, password); err != nil {
+		logging.ConsoleLogError("Error resetting password: " + err.Error())
+		return
+	}
+	logging.AuditLog(fmt.Sprintf("team %d password reset", id))
+	logging.ConsoleLogSuccess(fmt.Sprintf("Password for %s reset, the team has been signed out.", team.Name))
+	if generated {
+		logging.ConsoleLogMessage("New password: " + password)
+	}
+}
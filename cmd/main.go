@@ -6,10 +6,15 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/LTSEC/NEST/api"
+	"github.com/LTSEC/NEST/auth"
 	"github.com/LTSEC/NEST/cli"
 	"github.com/LTSEC/NEST/database"
 	"github.com/LTSEC/NEST/enum"
@@ -34,6 +39,11 @@ const (
 )
 
 func main() {
+	// "validate [path]" checks a configuration and exits, without the database or the engine
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
+
 	// Initalizer the logger
 	logger := new(logging.Logger)
 	logger.StartLog()
@@ -47,18 +57,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Automatically load the main yaml in gameconfigs
-	gameconfigs := filepath.Join(projectRoot, "gameconfigs")
-	mainconfig := filepath.Join(gameconfigs, "main.yaml")
-
-	yamlConfig, err = parser.ParseYAML(gameconfigs, mainconfig)
-	if err != nil {
-		logger.LogMessage(fmt.Sprintf("There was an error in startup when parsing the yaml configuration: %v", err), "ERROR")
-		logging.ConsoleLogError("Error parsing yaml, see logs for details.")
-		logging.ConsoleLogError("Startup failed")
-		os.Exit(1)
-	}
-
 	// Get the database configuration to the local database
 	cfg := enum.DatabaseConfig{
 		User:     getEnv("DATABASE_USER", "root"),
@@ -68,9 +66,6 @@ func main() {
 		DBName:   getEnv("DATABASE_NAME", "scoring"),
 	}
 
-	// Get the database's schema
-	schemaFP := filepath.Join(projectRoot, "database", "schema.sql")
-
 	// Create the database
 	if err := database.CreateDatabase(cfg, logger); err != nil {
 		logger.LogMessage(fmt.Sprintf("There was an error in startup when creating the NEST database: %v", err), "ERROR")
@@ -79,27 +74,75 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Set up the schema
-	if err := database.SetupSchema(cfg, schemaFP); err != nil {
-		logger.LogMessage(fmt.Sprintf("There was an error in startup when setting up the NEST database schema: %v", err), "ERROR")
-		logging.ConsoleLogError("Error setting up database, see logs for details.")
+	db, err := connectToDatabase(cfg)
+	if err != nil {
+		logger.LogMessage("There was an error in startup when connecting to the NEST database: %e", "ERROR")
+		logging.ConsoleLogError("Failed to connect to the NEST database, see logs for details.")
 		logging.ConsoleLogError("Startup failed")
 		os.Exit(1)
 	}
 
-	db, err := connectToDatabase(cfg)
+	// "migrate [status|up]" manages the schema and exits, so pending migrations can be applied
+	// when NEST_AUTO_MIGRATE is off
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := cli.Migrate(db, os.Args[1:]); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Bring the schema up to date
+	if err := migrateDatabase(db, logger); err != nil {
+		logger.LogMessage(fmt.Sprintf("There was an error in startup when migrating the NEST database: %v", err), "ERROR")
+		logging.ConsoleLogError(fmt.Sprintf("Error migrating database: %v", err))
+		logging.ConsoleLogError("Startup failed")
+		os.Exit(1)
+	}
+
+	// Automatically load the main yaml in gameconfigs
+	gameconfigs := filepath.Join(projectRoot, "gameconfigs")
+	mainconfig := filepath.Join(gameconfigs, "main.yaml")
+
+	yamlConfig, err = parser.ParseYAML(gameconfigs, mainconfig)
 	if err != nil {
-		logger.LogMessage("There was an error in startup when connecting to the NEST database: %e", "ERROR")
-		logging.ConsoleLogError("Failed to connect to the NEST database, see logs for details.")
+		logger.LogMessage(fmt.Sprintf("There was an error in startup when parsing the yaml configuration: %v", err), "ERROR")
+		logging.ConsoleLogError("Error parsing yaml, see logs for details.")
 		logging.ConsoleLogError("Startup failed")
 		os.Exit(1)
 	}
 
+	// Hash any team passwords stored in plain text by earlier versions
+	if hashed, err := database.HashTeamPasswords(db); err != nil {
+		logger.LogMessage(fmt.Sprintf("There was an error in startup when hashing team passwords: %v", err), "ERROR")
+		logging.ConsoleLogError("Error hashing team passwords, see logs for details.")
+		logging.ConsoleLogError("Startup failed")
+		os.Exit(1)
+	} else if hashed > 0 {
+		logger.LogMessage(fmt.Sprintf("Hashed %d team passwords stored in plain text.", hashed), "STATUS")
+	}
+
 	// Run the initalizer for the scoring component so its prepped when ready to start on CLI
+	scoring.ConfigFolder, scoring.ConfigFile = gameconfigs, mainconfig
 	go scoring.Initalize(db, yamlConfig, logger)
+	go reloadOnSignal(db, logger)
+
+	// Set up the signing key and the first admin account for the API
+	authConfig, err := loadAuthConfig(logger)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("There was an error in startup when configuring API authentication: %v", err), "ERROR")
+		logging.ConsoleLogError("Error configuring API authentication, see logs for details.")
+		logging.ConsoleLogError("Startup failed")
+		os.Exit(1)
+	}
+	if err := bootstrapAdmin(db, logger); err != nil {
+		logger.LogMessage(fmt.Sprintf("There was an error in startup when creating the admin account: %v", err), "ERROR")
+		logging.ConsoleLogError("Error creating the admin account, see logs for details.")
+		logging.ConsoleLogError("Startup failed")
+		os.Exit(1)
+	}
 
 	// Set up RESTful API
-	router := api.SetupRouter(db)
+	router := api.SetupRouter(db, authConfig)
 
 	// Clear the console before CLI runs
 	fmt.Print("\033[H\033[2J")
@@ -123,6 +166,39 @@ func main() {
 
 }
 
+// validateConfig checks the configuration at the path in args, gameconfigs/main.yaml by default,
+// printing every problem found. It returns the exit code: 1 if there are errors, which would stop
+// NEST from starting or a service from being scored, and 0 if there are only warnings or none.
+func validateConfig(args []string) int {
+	if len(args) > 1 {
+		logging.ConsoleLogMessage("Usage: validate [path to main.yaml]")
+		return 2
+	}
+	path := filepath.Join("gameconfigs", "main.yaml")
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	errs, warnings := parser.Validate(filepath.Dir(path), path)
+	for _, warning := range warnings {
+		logging.ConsoleLogMessage(fmt.Sprintf("%sWarning:%s %v", Yellow, Reset, warning))
+	}
+	for _, err := range errs {
+		logging.ConsoleLogError(err.Error())
+	}
+
+	switch {
+	case len(errs) > 0:
+		logging.ConsoleLogError(fmt.Sprintf("%s is invalid: %d errors, %d warnings.", path, len(errs), len(warnings)))
+		return 1
+	case len(warnings) > 0:
+		logging.ConsoleLogSuccess(fmt.Sprintf("%s is valid, with %d warnings.", path, len(warnings)))
+	default:
+		logging.ConsoleLogSuccess(fmt.Sprintf("%s is valid.", path))
+	}
+	return 0
+}
+
 // getEnv fetches an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -142,6 +218,102 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// loadAuthConfig builds the API's token configuration from the environment. Without
+// NEST_JWT_SECRET a random key is used, so everyone is signed out when NEST restarts.
+func loadAuthConfig(logger *logging.Logger) (auth.Config, error) {
+	cfg := auth.Config{
+		Secret:     []byte(getEnv("NEST_JWT_SECRET", "")),
+		AccessTTL:  time.Duration(getEnvAsInt("NEST_ACCESS_TOKEN_MINUTES", 15)) * time.Minute,
+		RefreshTTL: time.Duration(getEnvAsInt("NEST_REFRESH_TOKEN_HOURS", 24*7)) * time.Hour,
+	}
+	if len(cfg.Secret) == 0 {
+		secret, err := auth.RandomSecret()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Secret = secret
+		logger.LogMessage("NEST_JWT_SECRET is not set, using a random signing key; sign ins will not survive a restart.", "WARNING")
+	}
+	return cfg, nil
+}
+
+// defaultPasswords are passwords bootstrapAdmin refuses, since they are the first ones tried
+// against a published API.
+var defaultPasswords = []string{"admin", "password", "changeme", "nest"}
+
+// bootstrapAdmin creates the admin account named by NEST_ADMIN_USER and NEST_ADMIN_PASSWORD
+// if it doesn't exist yet, so there is someone who can create the other accounts. An empty or
+// default password, or one that is the user name, is refused rather than used.
+func bootstrapAdmin(db *sql.DB, logger *logging.Logger) error {
+	username, password := getEnv("NEST_ADMIN_USER", ""), getEnv("NEST_ADMIN_PASSWORD", "")
+	if username == "" {
+		return nil
+	}
+	if password == "" || password == username || slices.Contains(defaultPasswords, strings.ToLower(password)) {
+		return fmt.Errorf("NEST_ADMIN_PASSWORD must be set to a password that isn't empty, a default or the user name")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	created, err := database.EnsureUser(db, enum.User{Username: username, PasswordHash: hash, Role: auth.RoleAdmin})
+	if err != nil {
+		return err
+	}
+	if created {
+		logger.LogMessage(fmt.Sprintf("Created admin account %s.", username), "INFO")
+	}
+	return nil
+}
+
+// migrateDatabase applies the pending database migrations. With NEST_AUTO_MIGRATE=false they are
+// not applied, and startup is refused until they are reviewed and applied with
+// "scoring-engine migrate up", since the rest of NEST expects the current schema.
+func migrateDatabase(db *sql.DB, logger *logging.Logger) error {
+	if getEnv("NEST_AUTO_MIGRATE", "true") == "false" {
+		migrations, err := database.MigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for _, m := range migrations {
+			if !m.Applied() {
+				pending++
+			}
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d database migrations are pending and NEST_AUTO_MIGRATE is off; apply them with \"scoring-engine migrate up\"", pending)
+		}
+		return nil
+	}
+
+	applied, err := database.Migrate(db)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		logger.LogMessage(fmt.Sprintf("Applied %d database migrations.", len(applied)), "STATUS")
+	}
+	return nil
+}
+
+// reloadOnSignal reloads the configuration whenever NEST receives SIGHUP, as with "config reload".
+func reloadOnSignal(db *sql.DB, logger *logging.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		summary, err := scoring.Reload(db)
+		if err != nil {
+			logger.LogMessage(fmt.Sprintf("There was an error reloading the configuration on SIGHUP: %v", err), "ERROR")
+			logging.ConsoleLogError("Error reloading the configuration, see logs for details.")
+			continue
+		}
+		logging.AuditLog(fmt.Sprintf("configuration reloaded on SIGHUP: %s", summary))
+		logging.ConsoleLogSuccess(fmt.Sprintf("Configuration reloaded: %s.", summary))
+	}
+}
+
 // Establishes a connection to the PostgreSQL database.
 func connectToDatabase(cfg enum.DatabaseConfig) (*sql.DB, error) {
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+// CreateAdjustment records a manual change to a team's score and returns its ID.
+func CreateAdjustment(db *sql.DB, adjustment enum.Adjustment) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO adjustments (team_id, delta, reason, author)
+		VALUES ($1, $2, $3, $4)
+		RETURNING adjustment_id
+	`, adjustment.TeamID, adjustment.Delta, adjustment.Reason, adjustment.Author).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to adjust team %d's score: %w", adjustment.TeamID, err)
+	}
+	return id, nil
+}
+
+// ListAdjustments returns the adjustments made to a team's score, or to every team's if teamID
+// is 0, oldest first.
+func ListAdjustments(db *sql.DB, teamID int) ([]enum.Adjustment, error) {
+	query := `SELECT adjustment_id, team_id, delta, reason, author, created_at FROM adjustments`
+	args := []any{}
+	if teamID != 0 {
+		query += ` WHERE team_id = $1`
+		args = append(args, teamID)
+	}
+	query += ` ORDER BY created_at, adjustment_id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []enum.Adjustment{}
+	for rows.Next() {
+		var a enum.Adjustment
+		if err := rows.Scan(&a.ID, &a.TeamID, &a.Delta, &a.Reason, &a.Author, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	return adjustments, rows.Err()
+}
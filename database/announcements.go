@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+const announcementColumns = `announcement_id, title, content, author, created_at, publish_at, is_visible`
+
+func scanAnnouncement(row userScanner) (enum.Announcement, error) {
+	var a enum.Announcement
+	err := row.Scan(&a.ID, &a.Title, &a.Content, &a.Author, &a.CreatedAt, &a.PublishAt, &a.Visible)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+// CreateAnnouncement adds an announcement and returns its ID. A zero PublishAt publishes it immediately.
+func CreateAnnouncement(db *sql.DB, a enum.Announcement) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO announcements (title, content, author, publish_at, is_visible)
+		VALUES ($1, $2, $3, COALESCE($4, CURRENT_TIMESTAMP), TRUE)
+		RETURNING announcement_id
+	`, a.Title, a.Content, a.Author, nullable(a.PublishAt)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create announcement: %w", err)
+	}
+	return id, nil
+}
+
+// GetAnnouncement returns the announcement with the given ID, or ErrNotFound.
+func GetAnnouncement(db *sql.DB, id int) (enum.Announcement, error) {
+	return scanAnnouncement(db.QueryRow(`SELECT `+announcementColumns+` FROM announcements WHERE announcement_id = $1`, id))
+}
+
+// ListAnnouncements returns announcements newest first. Unless all is set, only those that are
+// visible and whose publish time has passed are returned.
+func ListAnnouncements(db *sql.DB, all bool) ([]enum.Announcement, error) {
+	query := `SELECT ` + announcementColumns + ` FROM announcements`
+	if !all {
+		query += ` WHERE is_visible AND publish_at <= CURRENT_TIMESTAMP`
+	}
+	query += ` ORDER BY publish_at DESC, announcement_id DESC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := []enum.Announcement{}
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, rows.Err()
+}
+
+// UpdateAnnouncement saves an announcement's title, content, publish time and visibility.
+func UpdateAnnouncement(db *sql.DB, a enum.Announcement) error {
+	res, err := db.Exec(`
+		UPDATE announcements SET title = $1, content = $2, publish_at = $3, is_visible = $4
+		WHERE announcement_id = $5
+	`, a.Title, a.Content, a.PublishAt, a.Visible, a.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update announcement %d: %w", a.ID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteAnnouncement removes an announcement for good.
+func DeleteAnnouncement(db *sql.DB, id int) error {
+	res, err := db.Exec(`DELETE FROM announcements WHERE announcement_id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete announcement %d: %w", id, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+// ChangeCredentials saves the new passwords, by username, a team has submitted for one of its
+// services in a password change request, and records who made each change. It returns
+// ErrNotFound if the team doesn't have the service.
+func ChangeCredentials(db *sql.DB, teamID int, serviceName string, passwords map[string]string, changedBy string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var teamServiceID int
+	err = tx.QueryRowContext(ctx, `
+		SELECT ts.team_service_id FROM team_services ts
+		JOIN services s ON s.service_id = ts.service_id
+		WHERE ts.team_id = $1 AND s.service_name = $2
+	`, teamID, serviceName).Scan(&teamServiceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to find service %s for team %d: %w", serviceName, teamID, err)
+	}
+
+	usernames := make([]string, 0, len(passwords))
+	for username := range passwords {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	for _, username := range usernames {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO service_credentials (team_service_id, username, password)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (team_service_id, username) DO UPDATE SET password = EXCLUDED.password, updated_at = now()
+		`, teamServiceID, username, passwords[username])
+		if err != nil {
+			return fmt.Errorf("failed to save the password for %s: %w", username, err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO credential_changes (team_service_id, username, changed_by) VALUES ($1, $2, $3)
+		`, teamServiceID, username, changedBy)
+		if err != nil {
+			return fmt.Errorf("failed to record the password change for %s: %w", username, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetCredentials returns the passwords a team has changed for a service, by username.
+func GetCredentials(db *sql.DB, teamID, serviceID int) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT c.username, c.password FROM service_credentials c
+		JOIN team_services ts ON ts.team_service_id = c.team_service_id
+		WHERE ts.team_id = $1 AND ts.service_id = $2
+	`, teamID, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make(map[string]string)
+	for rows.Next() {
+		var username, password string
+		if err := rows.Scan(&username, &password); err != nil {
+			return nil, err
+		}
+		credentials[username] = password
+	}
+	return credentials, rows.Err()
+}
+
+// ListCredentialChanges returns a team's password change requests, oldest first.
+func ListCredentialChanges(db *sql.DB, teamID int) ([]enum.CredentialChange, error) {
+	rows, err := db.Query(`
+		SELECT c.change_id, ts.team_id, s.service_name, c.username, c.changed_by, c.changed_at
+		FROM credential_changes c
+		JOIN team_services ts ON ts.team_service_id = c.team_service_id
+		JOIN services s ON s.service_id = ts.service_id
+		WHERE ts.team_id = $1
+		ORDER BY c.changed_at, c.change_id
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []enum.CredentialChange{}
+	for rows.Next() {
+		var c enum.CredentialChange
+		if err := rows.Scan(&c.ID, &c.TeamID, &c.Service, &c.Username, &c.ChangedBy, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
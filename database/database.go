@@ -4,15 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/LTSEC/NEST/enum"
 	"github.com/LTSEC/NEST/logging"
-	"github.com/chzyer/readline"
 	"github.com/go-yaml/yaml"
 	_ "github.com/lib/pq"
 )
@@ -54,88 +51,6 @@ func CreateDatabase(cfg enum.DatabaseConfig, newlogger *logging.Logger) error {
 	return nil
 }
 
-// SetupSchema connects to the "scoring" database and sets up the tables.
-func SetupSchema(cfg enum.DatabaseConfig, schemaFilePath string) error {
-	logger.LogMessage("Database schema setup initalized", "STATUS")
-	// Connect to the "scoring" database
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=scoring sslmode=disable", cfg.Host, cfg.Port, cfg.User, cfg.Password)
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect to scoring database: %w", err)
-	}
-	defer db.Close()
-
-	// Read the schema SQL from file
-	schema, err := ioutil.ReadFile(schemaFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to read schema file: %w", err)
-	}
-
-	// Set up the schema
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err = db.ExecContext(ctx, string(schema))
-	if err != nil {
-		logger.LogMessage(fmt.Sprintf("Nonfatal error occured while setting up the database schema %v", err), "ERROR")
-	}
-	logger.LogMessage("Database schema setup successfully completed.", "STATUS")
-
-	return nil
-}
-
-// Adds a team to the database, returns any errors
-func AddTeamToDatabase(db *sql.DB, team enum.Team, rl *readline.Instance) error {
-	var query string
-	var ctx context.Context
-	var cancel context.CancelFunc
-
-	// Check if a password is included in call
-	if team.Password != "" {
-		query = `INSERT INTO teams (team_name, team_password, team_color) VALUES ($1, $2, $3) ON CONFLICT (team_name) DO NOTHING;`
-		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-	} else {
-		// Get the password
-		logging.ConsoleLogMessage("Enter a password: ")
-		line, err := rl.Readline()
-		if err != nil {
-			// Handle Ctrl+C: if interrupted, quit the CLI
-			if err == readline.ErrInterrupt {
-				logging.ConsoleLogMessage("Exiting CLI.")
-				os.Exit(0)
-			}
-			logging.ConsoleLogError(fmt.Sprintf("Error reading line: %v", err))
-			logger.LogMessage(fmt.Sprintf("Error reading line: %v", err), "ERROR")
-			os.Exit(2)
-		}
-
-		// Clean up the line input
-		line = strings.TrimSpace(line)
-		if line == "" {
-			return fmt.Errorf("Error: No input")
-		}
-
-		// Set the password to the line input
-		team.Password = line
-
-		// Add to database
-		query = `INSERT INTO teams (team_name, team_password, team_color) VALUES ($1, $2, $3) ON CONFLICT (team_name) DO NOTHING;`
-		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-	}
-
-	_, err := db.ExecContext(ctx, query, team.Name, team.Password, team.Color)
-	if err != nil {
-		logger.LogMessage(fmt.Sprintf("Failed to insert team '%s' into the database: %v", team.Name, err), "ERROR")
-		return err
-	}
-
-	logger.LogMessage(fmt.Sprintf("Team %s added successfully.", team.Name), "INFO")
-
-	return nil
-}
-
 // EditTeam updates the team name for the specified team.
 func EditTeam(id int, newName string, db *sql.DB) error {
 	query := `UPDATE teams SET team_name = $1 WHERE team_id = $2`
@@ -158,36 +73,67 @@ func EditTeam(id int, newName string, db *sql.DB) error {
 	return nil
 }
 
+// GetTeamTotals returns every team's score, highest first, made up of the points from their
+// services, from graded injects and from manual adjustments, less any SLA penalties. Each inject
+// counts a team's best graded submission.
+func GetTeamTotals(db *sql.DB) ([]enum.TeamTotal, error) {
+	rows, err := db.Query(`
+		WITH service_points AS (
+			SELECT team_id, SUM(points) AS points FROM team_services GROUP BY team_id
+		), inject_points AS (
+			SELECT team_id, SUM(best) AS points FROM (
+				SELECT team_id, inject_id, MAX(score) AS best
+				FROM inject_submissions
+				WHERE score IS NOT NULL
+				GROUP BY team_id, inject_id
+			) AS graded
+			GROUP BY team_id
+		), adjustment_points AS (
+			SELECT team_id, SUM(delta) AS points FROM adjustments GROUP BY team_id
+		), sla_penalties AS (
+			SELECT ts.team_id, SUM(v.penalty) AS points
+			FROM sla_violations v
+			JOIN team_services ts ON ts.team_service_id = v.team_service_id
+			GROUP BY ts.team_id
+		)
+		SELECT t.team_id, t.team_name, COALESCE(sp.points, 0), COALESCE(ip.points, 0), COALESCE(ap.points, 0), COALESCE(sla.points, 0)
+		FROM teams t
+		LEFT JOIN service_points sp ON sp.team_id = t.team_id
+		LEFT JOIN inject_points ip ON ip.team_id = t.team_id
+		LEFT JOIN adjustment_points ap ON ap.team_id = t.team_id
+		LEFT JOIN sla_penalties sla ON sla.team_id = t.team_id
+		ORDER BY COALESCE(sp.points, 0) + COALESCE(ip.points, 0) + COALESCE(ap.points, 0) - COALESCE(sla.points, 0) DESC, t.team_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []enum.TeamTotal
+	for rows.Next() {
+		var total enum.TeamTotal
+		if err := rows.Scan(&total.ID, &total.Name, &total.ServicePoints, &total.InjectPoints, &total.AdjustmentPoints, &total.SLAPenalty); err != nil {
+			return nil, err
+		}
+		total.Total = total.ServicePoints + total.InjectPoints + total.AdjustmentPoints - total.SLAPenalty
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
 // CheckTeamScores queries the database for each team's total score and prints the results.
 func CheckTeamScores(db *sql.DB) error {
 	logging.ConsoleLogMessage("Team Scores:")
 
-	query := `
-        SELECT t.team_id, t.team_name, COALESCE(SUM(ts.points), 0) AS total_points
-        FROM teams t
-        LEFT JOIN team_services ts ON t.team_id = ts.team_id
-        GROUP BY t.team_id, t.team_name
-        ORDER BY total_points DESC;
-    `
-	rows, err := db.Query(query)
+	totals, err := GetTeamTotals(db)
 	if err != nil {
 		logger.LogMessage(fmt.Sprintf("Error querying team scores: %v", err), "ERROR")
 		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var teamID int
-		var teamName string
-		var totalPoints int
-		if err := rows.Scan(&teamID, &teamName, &totalPoints); err != nil {
-			logger.LogMessage(fmt.Sprintf("Error scanning row: %v", err), "ERROR")
-			continue
-		}
-		logging.ConsoleLogMessage(fmt.Sprintf("Team ID: %d, Name: %s, Score: %d\n", teamID, teamName, totalPoints))
-	}
-	if err = rows.Err(); err != nil {
-		logger.LogMessage(fmt.Sprintf("Row error: %v", err), "ERROR")
+	for _, total := range totals {
+		logging.ConsoleLogMessage(fmt.Sprintf("Team ID: %d, Name: %s, Score: %d (services: %d, injects: %d, adjustments: %+d, SLA penalties: -%d)\n",
+			total.ID, total.Name, total.Total, total.ServicePoints, total.InjectPoints, total.AdjustmentPoints, total.SLAPenalty))
 	}
 
 	return nil
@@ -213,10 +159,11 @@ func GetAllTeams(db *sql.DB) ([]enum.ScoringTeam, error) {
 	return teams, nil
 }
 
-// Gets a team's services from the SQL database
+// Gets a team's services from the SQL database. A service is disabled if it is disabled for every
+// team or just this one, or has been retired.
 func GetTeamServices(db *sql.DB, teamID int) ([]enum.ScoringService, error) {
 	query := `
-		SELECT s.service_id, s.service_name, s.box_name, s.disabled
+		SELECT s.service_id, s.service_name, s.box_name, s.disabled OR s.retired OR ts.disabled
 		FROM services s
 		JOIN team_services ts ON s.service_id = ts.service_id
 		WHERE ts.team_id = $1
@@ -311,39 +258,78 @@ func ValidateServiceUptime(db *sql.DB) error {
 // as Logs/report.yaml
 func GenerateReport(db *sql.DB) error {
 	// Define the structure for each team's report.
+	type AdjustmentReport struct {
+		Delta  int    `yaml:"delta"`
+		Reason string `yaml:"reason"`
+		Author string `yaml:"author"`
+		Time   string `yaml:"time"`
+	}
+	type SLAViolationReport struct {
+		Service    string `yaml:"service"`
+		StartRound int    `yaml:"start_round"`
+		EndRound   int    `yaml:"end_round"`
+		Penalty    int    `yaml:"penalty"`
+	}
 	type TeamReport struct {
-		TeamID      int    `yaml:"team_id"`
-		TeamName    string `yaml:"team_name"`
-		TotalPoints int    `yaml:"total_points"`
+		TeamID           int                  `yaml:"team_id"`
+		TeamName         string               `yaml:"team_name"`
+		ServicePoints    int                  `yaml:"service_points"`
+		InjectPoints     int                  `yaml:"inject_points"`
+		AdjustmentPoints int                  `yaml:"adjustment_points"`
+		SLAPenalty       int                  `yaml:"sla_penalty"`
+		TotalPoints      int                  `yaml:"total_points"`
+		Adjustments      []AdjustmentReport   `yaml:"adjustments,omitempty"`
+		SLAViolations    []SLAViolationReport `yaml:"sla_violations,omitempty"`
+	}
+
+	totals, err := GetTeamTotals(db)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error querying teams for report: %v", err), "ERROR")
+		return err
+	}
+	adjustments, err := ListAdjustments(db, 0)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error querying adjustments for report: %v", err), "ERROR")
+		return err
+	}
+	adjustmentsByTeam := make(map[int][]AdjustmentReport)
+	for _, a := range adjustments {
+		adjustmentsByTeam[a.TeamID] = append(adjustmentsByTeam[a.TeamID], AdjustmentReport{
+			Delta:  a.Delta,
+			Reason: a.Reason,
+			Author: a.Author,
+			Time:   a.CreatedAt.Format(time.RFC3339),
+		})
 	}
 
-	// Query to retrieve team scores.
-	query := `
-        SELECT t.team_id, t.team_name, COALESCE(SUM(ts.points), 0) AS total_points
-        FROM teams t
-        LEFT JOIN team_services ts ON t.team_id = ts.team_id
-        GROUP BY t.team_id, t.team_name
-        ORDER BY total_points DESC;
-    `
-	rows, err := db.Query(query)
+	violations, err := ListSLAViolations(db, 0)
 	if err != nil {
-		logger.LogMessage(fmt.Sprintf("Error querying teams for report: %v", err), "ERROR")
+		logger.LogMessage(fmt.Sprintf("Error querying SLA violations for report: %v", err), "ERROR")
 		return err
 	}
-	defer rows.Close()
+	violationsByTeam := make(map[int][]SLAViolationReport)
+	for _, v := range violations {
+		violationsByTeam[v.TeamID] = append(violationsByTeam[v.TeamID], SLAViolationReport{
+			Service:    v.Service,
+			StartRound: v.StartRound,
+			EndRound:   v.EndRound,
+			Penalty:    v.Penalty,
+		})
+	}
 
 	var teamsReport []TeamReport
-	for rows.Next() {
-		var tr TeamReport
-		if err := rows.Scan(&tr.TeamID, &tr.TeamName, &tr.TotalPoints); err != nil {
-			logger.LogMessage(fmt.Sprintf("Error scanning team report row: %v", err), "ERROR")
-			continue
-		}
-		teamsReport = append(teamsReport, tr)
-	}
-	if err = rows.Err(); err != nil {
-		logger.LogMessage(fmt.Sprintf("Row error: %v", err), "ERROR")
-		return err
+	for _, total := range totals {
+		teamsReport = append(teamsReport, TeamReport{
+			TeamID:           total.ID,
+			TeamName:         total.Name,
+			ServicePoints:    total.ServicePoints,
+			InjectPoints:     total.InjectPoints,
+			AdjustmentPoints: total.AdjustmentPoints,
+			SLAPenalty:       total.SLAPenalty,
+			TotalPoints:      total.Total,
+			Adjustments:      adjustmentsByTeam[total.ID],
+			SLAViolations:    violationsByTeam[total.ID],
+		})
 	}
 
 	// Build the overall report structure.
@@ -382,15 +368,104 @@ func GenerateReport(db *sql.DB) error {
 	return nil
 }
 
-// UpdateServiceScore updates the score a team has for a certain service, as well as its status (up/down)
-func UpdateServiceScore(db *sql.DB, teamID int, serviceID int, award int, status bool) error {
+// GetLatestRound returns the number of the most recent scoring round recorded in the database, or 0 if there are none.
+func GetLatestRound(db *sql.DB) (int, error) {
+	var round int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(round_id), 0) FROM rounds`).Scan(&round); err != nil {
+		return 0, fmt.Errorf("failed to get the latest round: %w", err)
+	}
+	return round, nil
+}
+
+// StartRound records the start of a scoring round.
+func StartRound(db *sql.DB, round int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO rounds (round_id, started_at)
+		VALUES ($1, now())
+		ON CONFLICT (round_id) DO UPDATE SET started_at = now(), ended_at = NULL
+	`, round)
+	if err != nil {
+		return fmt.Errorf("failed to start round %d: %w", round, err)
+	}
+	return nil
+}
+
+// EndRound records the end of a scoring round.
+func EndRound(db *sql.DB, round int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `UPDATE rounds SET ended_at = now() WHERE round_id = $1`, round)
+	if err != nil {
+		return fmt.Errorf("failed to end round %d: %w", round, err)
+	}
+	return nil
+}
+
+// PruneCheckHistory deletes service checks older than the most recent keepRounds rounds, returning
+// the number of checks removed. A keepRounds of 0 or less keeps the full history. Checks from
+// before rounds were recorded have no round, so there's no telling how old they are, and they are
+// always kept.
+func PruneCheckHistory(db *sql.DB, keepRounds int) (int64, error) {
+	if keepRounds <= 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `
+		DELETE FROM service_checks
+		WHERE round_id <= (SELECT COALESCE(MAX(round_id), 0) FROM rounds) - $1
+	`, keepRounds)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune service_checks: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// UpdateServiceScore updates the score a team has for a certain service, as well as its status (up/down),
+// and records the check in the service's history under the given round. It returns the service's
+// status before this check, or "" if this was its first check.
+func UpdateServiceScore(db *sql.DB, round int, teamID int, serviceID int, result enum.CheckResult) (enum.CheckStatus, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	// A partially up service still counts as up, with the partial flag recorded alongside it
+	status := result.Status != enum.StatusDown
+	partial := result.Status == enum.StatusPartial
+
 	// Start a transaction to ensure atomic operations
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
+		return "", fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	// Lock the row and read the status it had at the last check
+	var wasUp, wasPartial bool
+	var totalChecks int
+	err = tx.QueryRowContext(ctx, `
+		SELECT is_up, is_partial, total_checks FROM team_services
+		WHERE team_id = $1 AND service_id = $2
+		FOR UPDATE
+	`, teamID, serviceID).Scan(&wasUp, &wasPartial, &totalChecks)
+	if err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("failed to read team_services: %w", err)
+	}
+	var previous enum.CheckStatus
+	switch {
+	case totalChecks == 0:
+		previous = ""
+	case wasPartial:
+		previous = enum.StatusPartial
+	case wasUp:
+		previous = enum.StatusUp
+	default:
+		previous = enum.StatusDown
 	}
 
 	// Update points and status in team_services
@@ -399,58 +474,34 @@ func UpdateServiceScore(db *sql.DB, teamID int, serviceID int, award int, status
 	 SET 
 		 points = points + $1, 
 		 is_up = $2,
+		 is_partial = $3,
 		 total_checks = total_checks + 1,
 		 successful_checks = successful_checks + CASE WHEN $2 THEN 1 ELSE 0 END
-	 WHERE team_id = $3 AND service_id = $4
+	 WHERE team_id = $4 AND service_id = $5
  `
-	_, err = tx.ExecContext(ctx, queryUpdate, award, status, teamID, serviceID)
+	_, err = tx.ExecContext(ctx, queryUpdate, result.Points, status, partial, teamID, serviceID)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to update team_services: %w", err)
+		return "", fmt.Errorf("failed to update team_services: %w", err)
 	}
 
 	queryInsert := `
-		INSERT INTO service_checks (team_service_id, status)
-		SELECT team_service_id, $1
+		INSERT INTO service_checks (team_service_id, round_id, status, is_partial, points, latency_ms, reason, evidence)
+		SELECT team_service_id, $1, $2, $3, $4, $5, $6, $7
 		FROM team_services
-		WHERE team_id = $2 AND service_id = $3
+		WHERE team_id = $8 AND service_id = $9
 	`
-	_, err = tx.ExecContext(ctx, queryInsert, status, teamID, serviceID)
+	_, err = tx.ExecContext(ctx, queryInsert, round, status, partial, result.Points, result.Latency.Milliseconds(),
+		result.Reason, result.Evidence, teamID, serviceID)
 	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to insert into service_checks: %w", err)
-	}
-
-	// Trim to keep only the last 10 checks
-	queryTrim := `
-		DELETE FROM service_checks
-		WHERE team_service_id = (
-			SELECT team_service_id
-			FROM team_services
-			WHERE team_id = $1 AND service_id = $2
-		)
-		AND check_id NOT IN (
-			SELECT check_id
-			FROM service_checks
-			WHERE team_service_id = (
-				SELECT team_service_id
-				FROM team_services
-				WHERE team_id = $1 AND service_id = $2
-			)
-			ORDER BY timestamp DESC
-			LIMIT 10
-		)
-	`
-	_, err = tx.ExecContext(ctx, queryTrim, teamID, serviceID)
-	if err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to trim service_checks: %w", err)
+		return "", fmt.Errorf("failed to insert into service_checks: %w", err)
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return nil
+	return previous, nil
 }
@@ -0,0 +1,46 @@
+package database
+
+import "testing"
+
+func TestPruneCheckHistoryKeepsEverything(t *testing.T) {
+	// Without a retention policy nothing is deleted, so the database isn't touched at all
+	for _, keep := range []int{0, -1} {
+		if pruned, err := PruneCheckHistory(nil, keep); pruned != 0 || err != nil {
+			t.Fatalf("expected keeping %d rounds to prune nothing, got %d, %v", keep, pruned, err)
+		}
+	}
+}
+
+func TestHashTeamPassword(t *testing.T) {
+	hash, err := hashTeamPassword("CoffeeBean")
+	if err != nil || hash == "CoffeeBean" {
+		t.Fatalf("expected a plain password to be hashed, got %q, %v", hash, err)
+	}
+	if kept, err := hashTeamPassword(hash); err != nil || kept != hash {
+		t.Fatalf("expected a hash to be stored as is, got %q, %v", kept, err)
+	}
+
+	// A hash team logins couldn't be checked against is refused rather than stored
+	if _, err := hashTeamPassword("$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5"); err == nil {
+		t.Fatal("expected a hash with no rounds to be refused")
+	}
+}
+
+func TestConfiguredPasswordApplied(t *testing.T) {
+	applied, err := hashTeamPassword("CoffeeBean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configuredPasswordApplied("CoffeeBean", applied) || !configuredPasswordApplied(applied, applied) {
+		t.Fatal("expected the password and its hash to match the hash it was applied as")
+	}
+
+	// A changed password, in plain text or hashed, is applied again
+	changed, err := hashTeamPassword("Chai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuredPasswordApplied("Chai", applied) || configuredPasswordApplied(changed, applied) {
+		t.Fatal("expected a changed password not to match the hash the old one was applied as")
+	}
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+const injectColumns = `inject_id, title, description, points, release_at, due_at, created_at`
+
+func scanInject(row userScanner) (enum.Inject, error) {
+	var inject enum.Inject
+	err := row.Scan(&inject.ID, &inject.Title, &inject.Description, &inject.Points, &inject.ReleaseAt, &inject.DueAt, &inject.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return inject, ErrNotFound
+	}
+	return inject, err
+}
+
+// CreateInject defines an inject and returns its ID. A zero ReleaseAt releases it immediately.
+func CreateInject(db *sql.DB, inject enum.Inject) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO injects (title, description, points, release_at, due_at)
+		VALUES ($1, $2, $3, COALESCE($4, now()), $5)
+		RETURNING inject_id
+	`, inject.Title, inject.Description, inject.Points, nullable(inject.ReleaseAt), inject.DueAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create inject %s: %w", inject.Title, err)
+	}
+	return id, nil
+}
+
+// GetInject returns the inject with the given ID, or ErrNotFound.
+func GetInject(db *sql.DB, injectID int) (enum.Inject, error) {
+	return scanInject(db.QueryRow(`SELECT `+injectColumns+` FROM injects WHERE inject_id = $1`, injectID))
+}
+
+// ListInjects returns injects in release order. If releasedOnly is set, injects that haven't been
+// released yet are left out.
+func ListInjects(db *sql.DB, releasedOnly bool) ([]enum.Inject, error) {
+	query := `SELECT ` + injectColumns + ` FROM injects`
+	if releasedOnly {
+		query += ` WHERE release_at <= now()`
+	}
+	query += ` ORDER BY release_at, inject_id`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	injects := []enum.Inject{}
+	for rows.Next() {
+		inject, err := scanInject(rows)
+		if err != nil {
+			return nil, err
+		}
+		injects = append(injects, inject)
+	}
+	return injects, rows.Err()
+}
+
+// UpdateInject saves an inject's details, points and timing.
+func UpdateInject(db *sql.DB, inject enum.Inject) error {
+	res, err := db.Exec(`
+		UPDATE injects SET title = $1, description = $2, points = $3, release_at = $4, due_at = $5
+		WHERE inject_id = $6
+	`, inject.Title, inject.Description, inject.Points, inject.ReleaseAt, inject.DueAt, inject.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update inject %d: %w", inject.ID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteInject removes an inject along with every submission to it.
+func DeleteInject(db *sql.DB, injectID int) error {
+	res, err := db.Exec(`DELETE FROM injects WHERE inject_id = $1`, injectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete inject %d: %w", injectID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// File contents are left out so listing submissions stays cheap; see GetSubmissionFile
+const submissionColumns = `submission_id, inject_id, team_id, content, COALESCE(file_name, ''),
+	COALESCE(octet_length(file_data), 0), submitted_at, is_late, score IS NOT NULL, COALESCE(score, 0),
+	comments, COALESCE(graded_by, ''), COALESCE(graded_at, 'epoch'::timestamp)`
+
+func scanSubmission(row userScanner) (enum.InjectSubmission, error) {
+	var s enum.InjectSubmission
+	err := row.Scan(&s.ID, &s.InjectID, &s.TeamID, &s.Content, &s.FileName, &s.FileSize, &s.SubmittedAt,
+		&s.Late, &s.Graded, &s.Score, &s.Comments, &s.GradedBy, &s.GradedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, ErrNotFound
+	}
+	if !s.Graded {
+		s.GradedAt = time.Time{}
+	}
+	return s, err
+}
+
+// CreateSubmission records a team's answer to an inject, with an optional uploaded file, and
+// returns its ID. Submissions made after the inject is due are marked late.
+func CreateSubmission(db *sql.DB, submission enum.InjectSubmission, fileData []byte) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO inject_submissions (inject_id, team_id, content, file_name, file_data, is_late)
+		SELECT $1, $2, $3, $4, $5, now() > due_at FROM injects WHERE inject_id = $1
+		RETURNING submission_id
+	`, submission.InjectID, submission.TeamID, submission.Content, nullable(submission.FileName), fileData).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to save submission for inject %d: %w", submission.InjectID, err)
+	}
+	return id, nil
+}
+
+// GetSubmission returns the submission with the given ID, without its file, or ErrNotFound.
+func GetSubmission(db *sql.DB, submissionID int) (enum.InjectSubmission, error) {
+	return scanSubmission(db.QueryRow(`SELECT `+submissionColumns+` FROM inject_submissions WHERE submission_id = $1`, submissionID))
+}
+
+// GetSubmissionFile returns the name and contents of a submission's uploaded file, or ErrNotFound
+// if the submission doesn't exist or has no file.
+func GetSubmissionFile(db *sql.DB, submissionID int) (string, []byte, error) {
+	var name string
+	var data []byte
+	err := db.QueryRow(`
+		SELECT file_name, file_data FROM inject_submissions
+		WHERE submission_id = $1 AND file_data IS NOT NULL
+	`, submissionID).Scan(&name, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, ErrNotFound
+	}
+	return name, data, err
+}
+
+// ListSubmissions returns the submissions to an inject, oldest first, or only one team's if teamID isn't 0.
+func ListSubmissions(db *sql.DB, injectID, teamID int) ([]enum.InjectSubmission, error) {
+	query := `SELECT ` + submissionColumns + ` FROM inject_submissions WHERE inject_id = $1`
+	args := []any{injectID}
+	if teamID != 0 {
+		query += ` AND team_id = $2`
+		args = append(args, teamID)
+	}
+	query += ` ORDER BY submitted_at, submission_id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	submissions := []enum.InjectSubmission{}
+	for rows.Next() {
+		s, err := scanSubmission(rows)
+		if err != nil {
+			return nil, err
+		}
+		submissions = append(submissions, s)
+	}
+	return submissions, rows.Err()
+}
+
+// GradeSubmission records a grader's score and comments, replacing any earlier grade.
+func GradeSubmission(db *sql.DB, submissionID, score int, comments, grader string) error {
+	res, err := db.Exec(`
+		UPDATE inject_submissions SET score = $1, comments = $2, graded_by = $3, graded_at = now()
+		WHERE submission_id = $4
+	`, score, comments, grader, submissionID)
+	if err != nil {
+		return fmt.Errorf("failed to grade submission %d: %w", submissionID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("loading the embedded migrations failed: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("expected migration %d, got %04d_%s", i+1, m.Version, m.Name)
+		}
+		if m.Applied() {
+			t.Fatalf("migration %04d_%s shouldn't be applied before running", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0010_later.sql":  {Data: []byte("SELECT 10;")},
+		"m/0002_second.sql": {Data: []byte("SELECT 2;")},
+		"m/README.md":       {Data: []byte("not a migration")},
+	}
+	migrations, err := loadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "second" || migrations[1].Version != 10 || migrations[1].SQL != "SELECT 10;" {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+
+	for name, files := range map[string]fstest.MapFS{
+		"unnumbered": {"m/initial.sql": {}},
+		"unnamed":    {"m/0001.sql": {}},
+		"duplicate":  {"m/0001_a.sql": {}, "m/1_b.sql": {}},
+	} {
+		if _, err := loadMigrations(files, "m"); err == nil {
+			t.Fatalf("loading %s migrations should fail", name)
+		}
+	}
+}
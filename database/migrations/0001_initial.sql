@@ -1,7 +1,8 @@
--- Database: scoring
+-- The schema as it was before migrations, when schema.sql was run on every start. IF NOT EXISTS
+-- lets databases set up that way adopt migrations without being dropped.
 
 -- Teams Table
-CREATE TABLE teams (
+CREATE TABLE IF NOT EXISTS teams (
     team_id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     team_name VARCHAR(50) UNIQUE NOT NULL,
     team_password TEXT NOT NULL,
@@ -9,7 +10,7 @@ CREATE TABLE teams (
 );
 
 -- Services Table
-CREATE TABLE services (
+CREATE TABLE IF NOT EXISTS services (
     service_id SERIAL PRIMARY KEY,
     service_name VARCHAR(50) NOT NULL,
     box_name VARCHAR(50) NOT NULL,
@@ -18,7 +19,7 @@ CREATE TABLE services (
 );
 
 -- Team Services Table (associates teams with their services)
-CREATE TABLE team_services (
+CREATE TABLE IF NOT EXISTS team_services (
     team_service_id SERIAL PRIMARY KEY,
     team_id INT REFERENCES teams(team_id) ON DELETE CASCADE,
     service_id INT REFERENCES services(service_id) ON DELETE CASCADE,
@@ -30,14 +31,14 @@ CREATE TABLE team_services (
 );
 
 -- A table that stores all updates for each team-service combination for reference on frontend
-CREATE TABLE service_checks (
+CREATE TABLE IF NOT EXISTS service_checks (
     check_id SERIAL PRIMARY KEY,
     team_service_id INT REFERENCES team_services(team_service_id) ON DELETE CASCADE,
     status BOOLEAN NOT NULL,           -- true = up, false = down
     timestamp TIMESTAMP DEFAULT now()  -- check time
 );
 
-CREATE TABLE announcements (
+CREATE TABLE IF NOT EXISTS announcements (
     announcement_id SERIAL PRIMARY KEY,            -- Unique ID for each announcement
     title VARCHAR(255) NOT NULL,                   -- Title of the announcement
     content TEXT NOT NULL,                         -- Main content/body of the announcement
@@ -47,7 +48,6 @@ CREATE TABLE announcements (
 );
 
 -- Indexes for optimized lookups
-CREATE INDEX idx_team_services_team_id ON team_services(team_id);
-CREATE INDEX idx_team_services_service_id ON team_services(service_id);
-CREATE INDEX idx_service_checks_team_service ON service_checks(team_service_id, timestamp DESC);
-
+CREATE INDEX IF NOT EXISTS idx_team_services_team_id ON team_services(team_id);
+CREATE INDEX IF NOT EXISTS idx_team_services_service_id ON team_services(service_id);
+CREATE INDEX IF NOT EXISTS idx_service_checks_team_service ON service_checks(team_service_id, timestamp DESC);
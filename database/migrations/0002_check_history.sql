@@ -0,0 +1,21 @@
+-- Full check history, recorded per scoring round, with partial credit
+
+-- Scoring rounds, numbered the same way as the engine's scoring round counter
+CREATE TABLE IF NOT EXISTS rounds (
+    round_id INT PRIMARY KEY,                     -- The engine's round number
+    started_at TIMESTAMP NOT NULL DEFAULT now(),  -- When scoring for the round began
+    ended_at TIMESTAMP                            -- When scoring for the round finished, NULL while running
+);
+
+ALTER TABLE team_services
+    ADD COLUMN IF NOT EXISTS is_partial BOOLEAN DEFAULT FALSE;  -- Whether the service was only partially up at its last check
+
+ALTER TABLE service_checks
+    ADD COLUMN IF NOT EXISTS round_id INT REFERENCES rounds(round_id) ON DELETE CASCADE, -- The round the check was made in
+    ADD COLUMN IF NOT EXISTS is_partial BOOLEAN DEFAULT FALSE,  -- true if only some parts of the check passed
+    ADD COLUMN IF NOT EXISTS points INT DEFAULT 0,              -- Points awarded by the check
+    ADD COLUMN IF NOT EXISTS latency_ms INT DEFAULT 0,          -- How long the check took to run
+    ADD COLUMN IF NOT EXISTS reason TEXT,                       -- Why the service was scored the way it was
+    ADD COLUMN IF NOT EXISTS evidence TEXT;                     -- Raw detail gathered during the check
+
+CREATE INDEX IF NOT EXISTS idx_service_checks_round ON service_checks(round_id);
@@ -0,0 +1,23 @@
+-- Users Table (accounts that sign in to the API; teams can also sign in with their own credentials)
+CREATE TABLE IF NOT EXISTS users (
+    user_id SERIAL PRIMARY KEY,
+    username VARCHAR(50) UNIQUE NOT NULL,
+    email TEXT UNIQUE,
+    display_name TEXT,
+    password_hash TEXT NOT NULL,                                        -- argon2id, PHC string format
+    role TEXT NOT NULL CHECK (role IN ('admin', 'white', 'blue')),
+    team_id INT REFERENCES teams(team_id) ON DELETE SET NULL,           -- The team a blue team user is on
+    created_at TIMESTAMP DEFAULT now(),
+    updated_at TIMESTAMP DEFAULT now()
+);
+
+-- Refresh tokens handed out at sign in, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id INT REFERENCES users(user_id) ON DELETE CASCADE,  -- Set for user sign ins
+    team_id INT REFERENCES teams(team_id) ON DELETE CASCADE,  -- Set for team sign ins
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_team_id ON users(team_id);
@@ -0,0 +1,5 @@
+-- Announcements can be queued ahead of the game, hidden until publish_at
+ALTER TABLE announcements
+    ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_announcements_publish_at ON announcements(publish_at DESC);
@@ -0,0 +1,28 @@
+-- Injects: business tasks handed to teams, answered with documents and graded by white team
+CREATE TABLE IF NOT EXISTS injects (
+    inject_id SERIAL PRIMARY KEY,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    points INT NOT NULL CHECK (points >= 0),        -- The most a submission can be graded
+    release_at TIMESTAMP NOT NULL DEFAULT now(),    -- Teams can't see the inject before this time
+    due_at TIMESTAMP NOT NULL,                      -- Submissions after this time are marked late
+    created_at TIMESTAMP DEFAULT now()
+);
+
+-- Team answers to injects, either text, a file or both
+CREATE TABLE IF NOT EXISTS inject_submissions (
+    submission_id SERIAL PRIMARY KEY,
+    inject_id INT NOT NULL REFERENCES injects(inject_id) ON DELETE CASCADE,
+    team_id INT NOT NULL REFERENCES teams(team_id) ON DELETE CASCADE,
+    content TEXT NOT NULL DEFAULT '',
+    file_name TEXT,
+    file_data BYTEA,
+    submitted_at TIMESTAMP NOT NULL DEFAULT now(),
+    is_late BOOLEAN NOT NULL DEFAULT FALSE,
+    score INT CHECK (score >= 0),                   -- NULL until graded
+    comments TEXT NOT NULL DEFAULT '',
+    graded_by TEXT,
+    graded_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_inject_submissions_inject_team ON inject_submissions(inject_id, team_id);
@@ -0,0 +1,11 @@
+-- Manual score changes such as penalties and bonuses, added on top of a team's other points
+CREATE TABLE IF NOT EXISTS adjustments (
+    adjustment_id SERIAL PRIMARY KEY,
+    team_id INT NOT NULL REFERENCES teams(team_id) ON DELETE CASCADE,
+    delta INT NOT NULL CHECK (delta <> 0),     -- Points added, negative for penalties
+    reason TEXT NOT NULL,                      -- Why the points were added or removed
+    author TEXT NOT NULL,                      -- Who made the adjustment
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_adjustments_team_id ON adjustments(team_id);
@@ -0,0 +1,11 @@
+-- SLA violations: runs of consecutive failed checks that cost a team points
+CREATE TABLE IF NOT EXISTS sla_violations (
+    violation_id SERIAL PRIMARY KEY,
+    team_service_id INT NOT NULL REFERENCES team_services(team_service_id) ON DELETE CASCADE,
+    start_round INT NOT NULL,                  -- Round of the first failed check in the run
+    end_round INT NOT NULL,                    -- Round of the last failed check in the run
+    penalty INT NOT NULL CHECK (penalty >= 0), -- Points taken away for the violation
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sla_violations_team_service ON sla_violations(team_service_id, end_round DESC);
@@ -0,0 +1,20 @@
+-- Service passwords teams have changed through password change requests (PCRs). The scorer
+-- logs in with these, so they are stored as given rather than hashed.
+CREATE TABLE IF NOT EXISTS service_credentials (
+    team_service_id INT NOT NULL REFERENCES team_services(team_service_id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    password TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (team_service_id, username)
+);
+
+-- Audit trail of password change requests, without the passwords
+CREATE TABLE IF NOT EXISTS credential_changes (
+    change_id SERIAL PRIMARY KEY,
+    team_service_id INT NOT NULL REFERENCES team_services(team_service_id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    changed_by TEXT NOT NULL,                  -- Who submitted the change
+    changed_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_credential_changes_team_service ON credential_changes(team_service_id);
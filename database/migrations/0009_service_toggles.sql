@@ -0,0 +1,25 @@
+-- Services a resync finds are no longer configured are retired rather than disabled, so a resync
+-- never turns a service an admin disabled back on. Services already disabled stay disabled, since
+-- there is no telling whether a resync or an admin disabled them; the next resync retires the ones
+-- that aren't configured.
+ALTER TABLE services
+    ADD COLUMN IF NOT EXISTS retired BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A service can also be disabled for a single team
+ALTER TABLE team_services
+    ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Every enable and disable, including ones scheduled ahead, such as for a maintenance inject
+CREATE TABLE IF NOT EXISTS service_toggles (
+    toggle_id SERIAL PRIMARY KEY,
+    service_id INT NOT NULL REFERENCES services(service_id) ON DELETE CASCADE,
+    team_id INT REFERENCES teams(team_id) ON DELETE CASCADE, -- NULL for every team
+    disabled BOOLEAN NOT NULL,                 -- Whether the service is disabled or enabled
+    run_at TIMESTAMP NOT NULL,                 -- When the toggle takes effect
+    reason TEXT NOT NULL DEFAULT '',
+    author TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    applied_at TIMESTAMP                       -- NULL until the toggle has taken effect
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_toggles_pending ON service_toggles(run_at) WHERE applied_at IS NULL;
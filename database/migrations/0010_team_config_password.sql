@@ -0,0 +1,5 @@
+-- The hash a configured team's password was last applied as, so a resync can tell when the
+-- configuration's password changes without undoing resets made since. NULL until a resync records
+-- it, and for teams that aren't configured.
+ALTER TABLE teams
+    ADD COLUMN IF NOT EXISTS config_password TEXT;
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+// CheckSLA records an SLA violation if a team's service has failed its last threshold checks
+// since its previous violation, so every threshold failures in a row is one more violation.
+// Partially up checks count as up. It returns the violation and true if one was recorded.
+func CheckSLA(db *sql.DB, teamID, serviceID int, rule enum.SLARule) (enum.SLAViolation, bool, error) {
+	if rule.Threshold <= 0 {
+		return enum.SLAViolation{}, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var teamServiceID, lastEnd int
+	var serviceName string
+	err := db.QueryRowContext(ctx, `
+		SELECT ts.team_service_id, s.service_name, COALESCE(MAX(v.end_round), 0)
+		FROM team_services ts
+		JOIN services s ON s.service_id = ts.service_id
+		LEFT JOIN sla_violations v ON v.team_service_id = ts.team_service_id
+		WHERE ts.team_id = $1 AND ts.service_id = $2
+		GROUP BY ts.team_service_id, s.service_name
+	`, teamID, serviceID).Scan(&teamServiceID, &serviceName, &lastEnd)
+	if err != nil {
+		return enum.SLAViolation{}, false, fmt.Errorf("failed to read the last SLA violation: %w", err)
+	}
+
+	// The most recent checks since the last violation, newest first
+	rows, err := db.QueryContext(ctx, `
+		SELECT round_id, status FROM service_checks
+		WHERE team_service_id = $1 AND round_id > $2
+		ORDER BY round_id DESC, check_id DESC
+		LIMIT $3
+	`, teamServiceID, lastEnd, rule.Threshold)
+	if err != nil {
+		return enum.SLAViolation{}, false, fmt.Errorf("failed to read check history: %w", err)
+	}
+	defer rows.Close()
+
+	violation := enum.SLAViolation{TeamID: teamID, Service: serviceName, Penalty: rule.Penalty}
+	failed := 0
+	for rows.Next() {
+		var round int
+		var up bool
+		if err := rows.Scan(&round, &up); err != nil {
+			return enum.SLAViolation{}, false, err
+		}
+		if up {
+			return enum.SLAViolation{}, false, nil
+		}
+		if failed == 0 {
+			violation.EndRound = round
+		}
+		violation.StartRound = round
+		failed++
+	}
+	if err := rows.Err(); err != nil {
+		return enum.SLAViolation{}, false, err
+	}
+	if failed < rule.Threshold {
+		return enum.SLAViolation{}, false, nil
+	}
+
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO sla_violations (team_service_id, start_round, end_round, penalty)
+		VALUES ($1, $2, $3, $4)
+		RETURNING violation_id, created_at
+	`, teamServiceID, violation.StartRound, violation.EndRound, violation.Penalty).Scan(&violation.ID, &violation.CreatedAt)
+	if err != nil {
+		return enum.SLAViolation{}, false, fmt.Errorf("failed to record SLA violation: %w", err)
+	}
+	return violation, true, nil
+}
+
+// ListSLAViolations returns the SLA violations of a team's services, or of every team's if teamID
+// is 0, oldest first.
+func ListSLAViolations(db *sql.DB, teamID int) ([]enum.SLAViolation, error) {
+	query := `
+		SELECT v.violation_id, ts.team_id, s.service_name, v.start_round, v.end_round, v.penalty, v.created_at
+		FROM sla_violations v
+		JOIN team_services ts ON ts.team_service_id = v.team_service_id
+		JOIN services s ON s.service_id = ts.service_id`
+	args := []any{}
+	if teamID != 0 {
+		query += ` WHERE ts.team_id = $1`
+		args = append(args, teamID)
+	}
+	query += ` ORDER BY v.end_round, v.violation_id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	violations := []enum.SLAViolation{}
+	for rows.Next() {
+		var v enum.SLAViolation
+		if err := rows.Scan(&v.ID, &v.TeamID, &v.Service, &v.StartRound, &v.EndRound, &v.Penalty, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		violations = append(violations, v)
+	}
+	return violations, rows.Err()
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/lib/pq"
+)
+
+// TeamSync is what EnsureTeam had to do to bring a team in line with the configuration.
+type TeamSync int
+
+const (
+	TeamUnchanged TeamSync = iota
+	TeamCreated
+	TeamUpdated
+)
+
+// isUserLogin reports whether name is a user's username or email, which teams can't be named.
+func isUserLogin(db *sql.DB, name string) (bool, error) {
+	var taken bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1 OR email = $1)`, name).Scan(&taken)
+	return taken, err
+}
+
+// CreateTeam adds a team with a hashed password and returns its ID. Unlike teams from the
+// configuration, the database picks the ID. A team can't be named after a user's username or
+// email, giving ErrLoginTaken.
+func CreateTeam(db *sql.DB, team enum.Team) (int, error) {
+	if taken, err := isUserLogin(db, team.Name); err != nil {
+		return 0, err
+	} else if taken {
+		return 0, fmt.Errorf("failed to create team %s: %w", team.Name, ErrLoginTaken)
+	}
+	password, err := hashTeamPassword(team.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO teams (team_name, team_password, team_color)
+		VALUES ($1, $2, $3)
+		RETURNING team_id
+	`, team.Name, password, team.Color).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create team %s: %w", team.Name, err)
+	}
+	return id, nil
+}
+
+// EnsureTeam makes the database's team with the configured team's ID match it, creating the team
+// if needed. The password is left to SyncTeamPassword once the team exists. Like CreateTeam, a
+// team can't be named after a user, giving ErrLoginTaken.
+func EnsureTeam(db *sql.DB, team enum.Team) (TeamSync, error) {
+	var name, color string
+	err := db.QueryRow(`SELECT team_name, team_color FROM teams WHERE team_id = $1`, team.ID).Scan(&name, &color)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return TeamUnchanged, err
+	}
+	exists := err == nil
+	if exists && name == team.Name && color == team.Color {
+		return TeamUnchanged, nil
+	}
+
+	if name != team.Name {
+		if taken, err := isUserLogin(db, team.Name); err != nil {
+			return TeamUnchanged, err
+		} else if taken {
+			return TeamUnchanged, fmt.Errorf("failed to sync team %s: %w", team.Name, ErrLoginTaken)
+		}
+	}
+	if exists {
+		if _, err := db.Exec(`UPDATE teams SET team_name = $1, team_color = $2 WHERE team_id = $3`, team.Name, team.Color, team.ID); err != nil {
+			return TeamUnchanged, fmt.Errorf("failed to update team %s: %w", team.Name, err)
+		}
+		return TeamUpdated, nil
+	}
+
+	password, err := hashTeamPassword(team.Password)
+	if err != nil {
+		return TeamUnchanged, err
+	}
+	_, err = db.Exec(`
+		INSERT INTO teams (team_id, team_name, team_password, team_color, config_password)
+		OVERRIDING SYSTEM VALUE VALUES ($1, $2, $3, $4, $3)
+	`, team.ID, team.Name, password, team.Color)
+	if err != nil {
+		return TeamUnchanged, fmt.Errorf("failed to create team %s: %w", team.Name, err)
+	}
+
+	// Move the ID sequence past the configured ID, so teams created later don't collide with it
+	_, err = db.Exec(`SELECT setval(pg_get_serial_sequence('teams', 'team_id'), (SELECT MAX(team_id) FROM teams))`)
+	if err != nil {
+		return TeamCreated, fmt.Errorf("failed to advance the team ID sequence: %w", err)
+	}
+	return TeamCreated, nil
+}
+
+// SyncTeamPassword gives a configured team the configured password if it has changed since it
+// was last applied, reporting whether it did. Resets made since then are kept until the
+// configuration's password changes. Like SetTeamPassword, changing it signs the team out.
+func SyncTeamPassword(db *sql.DB, team enum.Team) (bool, error) {
+	var applied sql.NullString
+	err := db.QueryRow(`SELECT config_password FROM teams WHERE team_id = $1`, team.ID).Scan(&applied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNotFound
+	} else if err != nil {
+		return false, err
+	}
+	if applied.Valid && configuredPasswordApplied(team.Password, applied.String) {
+		return false, nil
+	}
+
+	password, err := hashTeamPassword(team.Password)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash the password of team %s: %w", team.Name, err)
+	}
+	if !applied.Valid {
+		// Teams from before configured passwords were recorded may have been reset since, so the
+		// configured password is only recorded, and applied once it changes
+		_, err := db.Exec(`UPDATE teams SET config_password = $1 WHERE team_id = $2`, password, team.ID)
+		return false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE teams SET team_password = $1, config_password = $1 WHERE team_id = $2`, password, team.ID); err != nil {
+		return false, fmt.Errorf("failed to update the password of team %s: %w", team.Name, err)
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE team_id = $1`, team.ID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// configuredPasswordApplied reports whether applied, the hash a team's configured password was
+// last applied as, came from the configured password, which may be in plain text or a hash.
+func configuredPasswordApplied(configured, applied string) bool {
+	if auth.IsPasswordHash(configured) {
+		return configured == applied
+	}
+	ok, err := auth.VerifyPassword(configured, applied)
+	return err == nil && ok
+}
+
+// RenameTeam changes a team's name, which can't be a user's username or email (ErrLoginTaken).
+func RenameTeam(db *sql.DB, teamID int, name string) error {
+	if taken, err := isUserLogin(db, name); err != nil {
+		return err
+	} else if taken {
+		return fmt.Errorf("failed to rename team %d to %s: %w", teamID, name, ErrLoginTaken)
+	}
+	result, err := db.Exec(`UPDATE teams SET team_name = $1 WHERE team_id = $2`, name, teamID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetTeamColor changes a team's color.
+func SetTeamColor(db *sql.DB, teamID int, color string) error {
+	result, err := db.Exec(`UPDATE teams SET team_color = $1 WHERE team_id = $2`, color, teamID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteTeam removes a team along with its services, check history, submissions and
+// adjustments. Members of the team keep their accounts but are no longer on a team.
+func DeleteTeam(db *sql.DB, teamID int) error {
+	result, err := db.Exec(`DELETE FROM teams WHERE team_id = $1`, teamID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SyncServices retires every service that isn't in serviceNames, so the engine stops scoring
+// services removed from the configuration, and brings back any retired ones that are. It
+// returns how many services were retired and restored. Team scores for retired services are kept.
+func SyncServices(db *sql.DB, serviceNames []string) (retired, restored int64, err error) {
+	names := pq.Array(serviceNames)
+	result, err := db.Exec(`UPDATE services SET retired = TRUE WHERE NOT retired AND NOT (service_name = ANY($1))`, names)
+	if err != nil {
+		return 0, 0, err
+	}
+	if retired, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	result, err = db.Exec(`UPDATE services SET retired = FALSE WHERE retired AND service_name = ANY($1)`, names)
+	if err != nil {
+		return retired, 0, err
+	}
+	restored, err = result.RowsAffected()
+	return retired, restored, err
+}
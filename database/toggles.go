@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+	"github.com/lib/pq"
+)
+
+const toggleColumns = `t.toggle_id, t.service_id, s.service_name, COALESCE(t.team_id, 0), t.disabled, t.run_at,
+	t.reason, t.author, t.created_at, t.applied_at`
+
+func scanToggle(row userScanner) (enum.ServiceToggle, error) {
+	var t enum.ServiceToggle
+	var appliedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.ServiceID, &t.Service, &t.TeamID, &t.Disabled, &t.RunAt,
+		&t.Reason, &t.Author, &t.CreatedAt, &appliedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrNotFound
+	}
+	t.AppliedAt = appliedAt.Time
+	return t, err
+}
+
+// FindServices returns the configured services with the given service name, or every
+// configured service on the box with the given name. It returns ErrNotFound if there are none.
+func FindServices(db *sql.DB, name string) ([]enum.ScoringService, error) {
+	rows, err := db.Query(`
+		SELECT service_id, service_name, box_name, disabled FROM services
+		WHERE NOT retired AND (service_name = $1 OR box_name = $1)
+		ORDER BY service_name
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []enum.ScoringService{}
+	for rows.Next() {
+		var service enum.ScoringService
+		if err := rows.Scan(&service.ID, &service.Name, &service.VMName, &service.Disabled); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, ErrNotFound
+	}
+	return services, nil
+}
+
+// ListServices returns every service by name, with whether it is disabled, for every team or
+// for some of them, or retired.
+func ListServices(db *sql.DB) ([]enum.ServiceStatus, error) {
+	rows, err := db.Query(`
+		SELECT s.service_id, s.service_name, s.box_name, s.disabled, s.retired,
+		       COALESCE(array_agg(ts.team_id ORDER BY ts.team_id) FILTER (WHERE ts.disabled), '{}')
+		FROM services s
+		LEFT JOIN team_services ts ON ts.service_id = s.service_id
+		GROUP BY s.service_id
+		ORDER BY s.service_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []enum.ServiceStatus{}
+	for rows.Next() {
+		var service enum.ServiceStatus
+		var teams pq.Int64Array
+		if err := rows.Scan(&service.ID, &service.Name, &service.VMName, &service.Disabled, &service.Retired, &teams); err != nil {
+			return nil, err
+		}
+		service.DisabledTeams = make([]int, 0, len(teams))
+		for _, team := range teams {
+			service.DisabledTeams = append(service.DisabledTeams, int(team))
+		}
+		services = append(services, service)
+	}
+	return services, rows.Err()
+}
+
+// CreateServiceToggle records a toggle and returns it with its ID. A toggle whose RunAt has
+// passed, or is zero, takes effect straight away; others are left for ApplyServiceToggles. It
+// returns ErrNotFound if the toggle is for a team that doesn't have the service.
+func CreateServiceToggle(db *sql.DB, t enum.ServiceToggle) (enum.ServiceToggle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return t, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if t.TeamID != 0 {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM team_services WHERE service_id = $1 AND team_id = $2)
+		`, t.ServiceID, t.TeamID).Scan(&exists)
+		if err != nil {
+			return t, err
+		}
+		if !exists {
+			return t, ErrNotFound
+		}
+	}
+
+	now := time.Now()
+	immediate := !t.RunAt.After(now)
+	if immediate {
+		t.RunAt, t.AppliedAt = now, now
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO service_toggles (service_id, team_id, disabled, run_at, reason, author, applied_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING toggle_id, created_at
+	`, t.ServiceID, nullable(t.TeamID), t.Disabled, t.RunAt, t.Reason, t.Author, nullable(t.AppliedAt)).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return t, fmt.Errorf("failed to record the toggle: %w", err)
+	}
+	if immediate {
+		if err := applyToggle(ctx, tx, t); err != nil {
+			return t, err
+		}
+	}
+	return t, tx.Commit()
+}
+
+// applyToggle sets the disabled flag a toggle is for.
+func applyToggle(ctx context.Context, tx *sql.Tx, t enum.ServiceToggle) error {
+	if t.TeamID != 0 {
+		_, err := tx.ExecContext(ctx, `UPDATE team_services SET disabled = $1 WHERE service_id = $2 AND team_id = $3`,
+			t.Disabled, t.ServiceID, t.TeamID)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE services SET disabled = $1 WHERE service_id = $2`, t.Disabled, t.ServiceID); err != nil {
+		return err
+	}
+	// Enabling a service for every team includes the teams it was disabled for on their own
+	if !t.Disabled {
+		if _, err := tx.ExecContext(ctx, `UPDATE team_services SET disabled = FALSE WHERE service_id = $1`, t.ServiceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyServiceToggles applies every scheduled toggle whose time has come, oldest first, and
+// returns them.
+func ApplyServiceToggles(db *sql.DB) ([]enum.ServiceToggle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+toggleColumns+` FROM service_toggles t
+		JOIN services s ON s.service_id = t.service_id
+		WHERE t.applied_at IS NULL AND t.run_at <= $1
+		ORDER BY t.run_at, t.toggle_id
+		FOR UPDATE OF t
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	toggles := []enum.ServiceToggle{}
+	for rows.Next() {
+		t, err := scanToggle(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		toggles = append(toggles, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, t := range toggles {
+		if err := applyToggle(ctx, tx, t); err != nil {
+			return nil, fmt.Errorf("failed to apply toggle %d: %w", t.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE service_toggles SET applied_at = $1 WHERE toggle_id = $2`, now, t.ID); err != nil {
+			return nil, err
+		}
+		toggles[i].AppliedAt = now
+	}
+	return toggles, tx.Commit()
+}
+
+// ListServiceToggles returns toggles, latest first. Unless all is set, only the
+// scheduled ones are returned.
+func ListServiceToggles(db *sql.DB, all bool) ([]enum.ServiceToggle, error) {
+	query := `SELECT ` + toggleColumns + ` FROM service_toggles t JOIN services s ON s.service_id = t.service_id`
+	if !all {
+		query += ` WHERE t.applied_at IS NULL`
+	}
+	query += ` ORDER BY t.run_at DESC, t.toggle_id DESC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	toggles := []enum.ServiceToggle{}
+	for rows.Next() {
+		t, err := scanToggle(rows)
+		if err != nil {
+			return nil, err
+		}
+		toggles = append(toggles, t)
+	}
+	return toggles, rows.Err()
+}
+
+// CancelServiceToggle deletes a scheduled toggle. It returns ErrNotFound if there is no such
+// toggle or it has already taken effect.
+func CancelServiceToggle(db *sql.DB, id int) error {
+	result, err := db.Exec(`DELETE FROM service_toggles WHERE toggle_id = $1 AND applied_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
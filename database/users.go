@@ -0,0 +1,297 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LTSEC/NEST/auth"
+	"github.com/LTSEC/NEST/enum"
+)
+
+// ErrNotFound is returned when a looked up row does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrLoginTaken is returned when a user or team would sign in with a name another team or user
+// already signs in with. Sign ins check users before teams, so one would lock the other out.
+var ErrLoginTaken = errors.New("the name is already used to sign in by another team or user")
+
+const userColumns = `user_id, username, COALESCE(email, ''), COALESCE(display_name, ''), password_hash, role, COALESCE(team_id, 0), created_at`
+
+// userScanner is satisfied by both *sql.Row and *sql.Rows, for sharing scan functions between single and list queries
+type userScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row userScanner) (enum.User, error) {
+	var user enum.User
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.PasswordHash, &user.Role, &user.TeamID, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+// nullable turns the zero value into NULL for optional columns
+func nullable[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
+// isTeamName reports whether a user's username or email is a team's name, which users can't
+// sign in with.
+func isTeamName(db *sql.DB, username, email string) (bool, error) {
+	var taken bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM teams WHERE team_name = $1 OR team_name = $2)`, username, email).Scan(&taken)
+	return taken, err
+}
+
+// CreateUser adds a user account and returns its ID. Its username and email can't be a team's
+// name, giving ErrLoginTaken.
+func CreateUser(db *sql.DB, user enum.User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if taken, err := isTeamName(db, user.Username, user.Email); err != nil {
+		return 0, err
+	} else if taken {
+		return 0, fmt.Errorf("failed to create user %s: %w", user.Username, ErrLoginTaken)
+	}
+
+	var id int
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO users (username, email, display_name, password_hash, role, team_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING user_id
+	`, user.Username, nullable(user.Email), nullable(user.DisplayName), user.PasswordHash, user.Role, nullable(user.TeamID)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create user %s: %w", user.Username, err)
+	}
+	return id, nil
+}
+
+// GetUser returns the user with the given ID, or ErrNotFound.
+func GetUser(db *sql.DB, userID int) (enum.User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE user_id = $1`, userID))
+}
+
+// GetUserByLogin returns the user whose username or email is login, or ErrNotFound.
+func GetUserByLogin(db *sql.DB, login string) (enum.User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1 OR email = $1`, login))
+}
+
+// ListUsers returns every user, or only the members of a team if teamID isn't 0.
+func ListUsers(db *sql.DB, teamID int) ([]enum.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY user_id`
+	args := []any{}
+	if teamID != 0 {
+		query = `SELECT ` + userColumns + ` FROM users WHERE team_id = $1 ORDER BY user_id`
+		args = append(args, teamID)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []enum.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateUser saves a user's details, role, team and password hash. Like the username, the email
+// can't be a team's name, giving ErrLoginTaken.
+func UpdateUser(db *sql.DB, user enum.User) error {
+	if taken, err := isTeamName(db, "", user.Email); err != nil {
+		return err
+	} else if taken {
+		return fmt.Errorf("failed to update user %d: %w", user.ID, ErrLoginTaken)
+	}
+	res, err := db.Exec(`
+		UPDATE users
+		SET email = $1, display_name = $2, password_hash = $3, role = $4, team_id = $5, updated_at = now()
+		WHERE user_id = $6
+	`, nullable(user.Email), nullable(user.DisplayName), user.PasswordHash, user.Role, nullable(user.TeamID), user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user %d: %w", user.ID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes a user account along with its refresh tokens.
+func DeleteUser(db *sql.DB, userID int) error {
+	res, err := db.Exec(`DELETE FROM users WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user %d: %w", userID, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnsureUser creates the user if no account with its username exists yet, reporting whether it
+// was created. Used to bootstrap the first admin account.
+func EnsureUser(db *sql.DB, user enum.User) (bool, error) {
+	if _, err := GetUserByLogin(db, user.Username); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if _, err := CreateUser(db, user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// hashTeamPassword hashes a team password for storage, keeping it as is if it is already a hash,
+// as long as the hash is one team logins can be checked against.
+func hashTeamPassword(password string) (string, error) {
+	if auth.IsPasswordHash(password) {
+		if err := auth.ValidatePasswordHash(password); err != nil {
+			return "", fmt.Errorf("invalid password hash: %w", err)
+		}
+		return password, nil
+	}
+	return auth.HashPassword(password)
+}
+
+// VerifyTeamPassword checks a team's name and password, returning the team's ID. A wrong name or
+// password gives ErrNotFound.
+func VerifyTeamPassword(db *sql.DB, teamName, password string) (int, error) {
+	var id int
+	var hash string
+	err := db.QueryRow(`SELECT team_id, team_password FROM teams WHERE team_name = $1`, teamName).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	if ok, err := auth.VerifyPassword(password, hash); err != nil || !ok {
+		return 0, ErrNotFound
+	}
+	return id, nil
+}
+
+// SetTeamPassword hashes and stores a new password for a team, signing the team out everywhere.
+func SetTeamPassword(db *sql.DB, teamID int, password string) error {
+	hash, err := hashTeamPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE teams SET team_password = $1 WHERE team_id = $2`, hash, teamID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE team_id = $1`, teamID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HashTeamPasswords hashes any team passwords stored in plain text, from before team passwords
+// were hashed, returning how many it hashed.
+func HashTeamPasswords(db *sql.DB) (int, error) {
+	rows, err := db.Query(`SELECT team_id, team_password FROM teams WHERE team_password NOT LIKE '$argon2id$%'`)
+	if err != nil {
+		return 0, err
+	}
+	plain := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var password string
+		if err := rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain[id] = password
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, password := range plain {
+		hash, err := auth.HashPassword(password)
+		if err != nil {
+			return 0, err
+		}
+		// Only replace the password that was read, in case it changed in the meantime
+		if _, err := db.Exec(`UPDATE teams SET team_password = $1 WHERE team_id = $2 AND team_password = $3`, hash, id, password); err != nil {
+			return 0, err
+		}
+	}
+	return len(plain), nil
+}
+
+// GetTeam returns the team with the given ID, or ErrNotFound.
+func GetTeam(db *sql.DB, teamID int) (enum.ScoringTeam, error) {
+	var team enum.ScoringTeam
+	err := db.QueryRow(`SELECT team_id, team_name, team_color FROM teams WHERE team_id = $1`, teamID).Scan(&team.ID, &team.Name, &team.Color)
+	if errors.Is(err, sql.ErrNoRows) {
+		return team, ErrNotFound
+	}
+	return team, err
+}
+
+// StoreRefreshToken saves the hash of a refresh token issued to a user (userID) or a team (teamID).
+func StoreRefreshToken(db *sql.DB, tokenHash string, userID, teamID int, expires time.Time) error {
+	// Clear out expired tokens while we're here
+	if _, err := db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("failed to remove expired refresh tokens: %w", err)
+	}
+
+	_, err := db.Exec(`INSERT INTO refresh_tokens (token_hash, user_id, team_id, expires_at) VALUES ($1, $2, $3, $4)`,
+		tokenHash, nullable(userID), nullable(teamID), expires)
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshToken returns who an unexpired refresh token was issued to, or ErrNotFound.
+func GetRefreshToken(db *sql.DB, tokenHash string) (userID int, teamID int, err error) {
+	err = db.QueryRow(`
+		SELECT COALESCE(user_id, 0), COALESCE(team_id, 0)
+		FROM refresh_tokens
+		WHERE token_hash = $1 AND expires_at > now()
+	`, tokenHash).Scan(&userID, &teamID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrNotFound
+	}
+	return userID, teamID, err
+}
+
+// DeleteRefreshToken revokes a refresh token.
+func DeleteRefreshToken(db *sql.DB, tokenHash string) error {
+	_, err := db.Exec(`DELETE FROM refresh_tokens WHERE token_hash = $1`, tokenHash)
+	return err
+}
@@ -1,5 +1,7 @@
 package enum
 
+import "time"
+
 // Config structure for database configuration parameters
 type DatabaseConfig struct {
 	User     string
@@ -14,13 +16,33 @@ type YamlConfig struct {
 	VirtualMachines         map[string]VirtualMachine         `yaml:"virtual-machines"`
 	OfficialVirtualMachines map[string]OfficialVirtualMachine `yaml:"official-virtual-machines"`
 	Teams                   map[string]Team                   `yaml:"teams"`
+	Scoring                 ScoringConfig                     `yaml:"scoring,omitempty"`
+}
+
+// ScoringConfig holds the optional tuning parameters for the scoring engine.
+// Any value left at zero falls back to the engine's default.
+type ScoringConfig struct {
+	RefreshTime      int `yaml:"refresh-time,omitempty"`      // Seconds between the start of each scoring round
+	Workers          int `yaml:"workers,omitempty"`           // Maximum number of checks that may run at the same time
+	RoundDeadline    int `yaml:"round-deadline,omitempty"`    // Seconds a round may run before outstanding checks are abandoned
+	HistoryRetention int `yaml:"history-retention,omitempty"` // Rounds of check history kept in the database, 0 keeps everything
+
+	SLA *SLARule `yaml:"sla,omitempty"` // The SLA rule for every service without one of its own
+}
+
+// SLARule penalizes a service that fails too many checks in a row. Every Threshold consecutive
+// failed checks is one violation, costing Penalty points.
+type SLARule struct {
+	Threshold int `yaml:"threshold"` // Consecutive failed checks that make a violation, 0 turns SLA penalties off
+	Penalty   int `yaml:"penalty"`   // Points taken away for each violation
 }
 
 // VirtualMachine represents a virtual machine configuration.
 type VirtualMachine struct {
-	IPSchema string             `yaml:"ip-schema"`
-	Services map[string]Service `yaml:"services,omitempty"`
-	Config   string             `yaml:"config,omitempty"`
+	IPSchema  string             `yaml:"ip-schema"`           // Address template for every team, see the address package
+	Addresses map[string]string  `yaml:"addresses,omitempty"` // Per team addresses keyed by team ID or name, used instead of the ip-schema
+	Services  map[string]Service `yaml:"services,omitempty"`
+	Config    string             `yaml:"config,omitempty"`
 }
 
 // Service represents each service configuration for a virtual machine.
@@ -34,17 +56,152 @@ type Service struct {
 	Password string `yaml:"password,omitempty"`   // The password of a user for a service
 	QFile    string `yaml:"query_file,omitempty"` // The query file for a service
 	QDir     string `yaml:"query_dir,omitempty"`  // The query directory for a service
+	// // WEB
+	Pages []string `yaml:"pages,omitempty"` // The paths checked by web80 and webssl, defaults to "/"
+	// // SSH
+	KeyFile  string        `yaml:"key_file,omitempty"` // A private key to log in with, alongside or instead of a password
+	Commands []CommandCase `yaml:"commands,omitempty"` // The commands to run and the output each should produce
+	// // SQL
+	Database  string `yaml:"database,omitempty"`  // The database to connect to
+	Queries   string `yaml:"queries,omitempty"`   // A file of SQL queries to run, separated by semicolons
+	Reference string `yaml:"reference,omitempty"` // A file with the expected result of each query, one per line
+	// // MAIL
+	Domain   string `yaml:"domain,omitempty"`   // The domain of the team's mail addresses, "<t>" is replaced with the team ID
+	StartTLS bool   `yaml:"starttls,omitempty"` // Whether to upgrade the connection with STARTTLS (mail and LDAP)
+	// // LDAP
+	BindDN string   `yaml:"bind_dn,omitempty"` // The DN to bind as, "<u>" is replaced with the username and "<t>" with the team ID
+	BaseDN string   `yaml:"base_dn,omitempty"` // Where the search starts, "<t>" is replaced with the team ID
+	Filter string   `yaml:"filter,omitempty"`  // The search to run after binding, "<u>" and "<t>" are replaced
+	Expect []string `yaml:"expect,omitempty"`  // Entries ("dn:<dn>") or attributes ("<attr>=<value>") the search must return
+	TLS    bool     `yaml:"tls,omitempty"`     // Whether to use TLS from the start of the connection (LDAPS)
 	// // TRUE OPTIONAL
-	Award   int  `yaml:"award,omitempty"`   // The awarded points for having a service up at scoring time
-	Partial bool `yaml:"partial,omitempty"` // Whether or not partial points should be awarded
+	Award   int      `yaml:"award,omitempty"`   // The awarded points for having a service up at scoring time
+	Partial bool     `yaml:"partial,omitempty"` // Whether multi-part checks award a share of the points for the parts that pass
+	SLA     *SLARule `yaml:"sla,omitempty"`     // Overrides the global SLA rule for this service
+
+	// Per team changes to the service, keyed by team ID or name
+	Teams map[string]ServiceOverride `yaml:"teams,omitempty"`
+}
+
+// ServiceOverride changes a service's configuration for a single team. Fields left empty keep
+// the service's value.
+type ServiceOverride struct {
+	Port     int    `yaml:"port,omitempty"`       // The port the team's service runs on
+	User     string `yaml:"user,omitempty"`       // A user to log in as instead of the service's user or query file
+	Password string `yaml:"password,omitempty"`   // The password of User
+	QFile    string `yaml:"query_file,omitempty"` // A query file to use instead of the service's, replacing its user if it logs in
+	QDir     string `yaml:"query_dir,omitempty"`  // A directory of files to use instead of the service's, for FTP reads and writes
+	Award    int    `yaml:"award,omitempty"`      // The points awarded to the team for the service being up
+}
+
+// CommandCase is a single command run over SSH, and the output it is expected to produce.
+// If both Expect and Regex are given, the output must satisfy both.
+type CommandCase struct {
+	Command string `yaml:"command"`          // The command to run
+	Expect  string `yaml:"expect,omitempty"` // A string that stdout must contain
+	Regex   string `yaml:"regex,omitempty"`  // A regular expression that stdout must match
 }
 
 // Team represents each team's configuration.
 type Team struct {
-	ID       int    `yaml:"id"`
-	Name     string `yaml:"name"`
-	Password string `yaml:"password"`
-	Color    string `yaml:"color"`
+	ID           int    `yaml:"id"`
+	Name         string `yaml:"name"`
+	Password     string `yaml:"password,omitempty"`      // The password itself or its argon2id hash
+	PasswordFile string `yaml:"password_file,omitempty"` // A file holding the password or its hash, instead of password
+	Color        string `yaml:"color"`
+}
+
+// A user account that can sign in to the API
+type User struct {
+	ID           int       // Corresponds to user_id in the database
+	Username     string    // Corresponds to username in the database
+	Email        string    // Corresponds to email in the database, optional
+	DisplayName  string    // Corresponds to display_name in the database, optional
+	PasswordHash string    // An argon2id hash of the user's password
+	Role         string    // admin, white or blue
+	TeamID       int       // The team a blue team user belongs to, 0 for none
+	CreatedAt    time.Time // When the account was made
+}
+
+// An announcement shown to every team
+type Announcement struct {
+	ID        int       // Corresponds to announcement_id in the database
+	Title     string    // Corresponds to title in the database
+	Content   string    // Corresponds to content in the database
+	Author    string    // Corresponds to author in the database
+	CreatedAt time.Time // When the announcement was made
+	PublishAt time.Time // When the announcement becomes visible
+	Visible   bool      // Corresponds to is_visible in the database, false once hidden
+}
+
+// A business task handed out to teams, answered with a submission and graded by white team
+type Inject struct {
+	ID          int       // Corresponds to inject_id in the database
+	Title       string    // Corresponds to title in the database
+	Description string    // What the team is asked to do
+	Points      int       // The most a submission can be graded
+	ReleaseAt   time.Time // When teams can first see the inject
+	DueAt       time.Time // Submissions after this are marked late
+	CreatedAt   time.Time // When the inject was defined
+}
+
+// A team's answer to an inject
+type InjectSubmission struct {
+	ID          int       // Corresponds to submission_id in the database
+	InjectID    int       // The inject being answered
+	TeamID      int       // The team that submitted it
+	Content     string    // Text answer, may be empty if a file was uploaded
+	FileName    string    // Name of the uploaded file, empty if there isn't one
+	FileSize    int       // Size of the uploaded file in bytes
+	SubmittedAt time.Time // When the submission was made
+	Late        bool      // Submitted after the inject was due
+	Graded      bool      // Whether Score, Comments, GradedBy and GradedAt are set
+	Score       int       // Points awarded by the grader
+	Comments    string    // Grader feedback
+	GradedBy    string    // Who graded the submission
+	GradedAt    time.Time // When the submission was graded
+}
+
+// A manual change to a team's score, such as a penalty for breaking the rules
+type Adjustment struct {
+	ID        int       // Corresponds to adjustment_id in the database
+	TeamID    int       // The team whose score changed
+	Delta     int       // Points added, negative for penalties
+	Reason    string    // Why the score was changed
+	Author    string    // Who changed it
+	CreatedAt time.Time // When it was changed
+}
+
+// A recorded breach of a service's SLA rule
+type SLAViolation struct {
+	ID         int       // Corresponds to violation_id in the database
+	TeamID     int       // The team whose service was down
+	Service    string    // The service's name, as in the services table
+	StartRound int       // The round of the first failed check in the run
+	EndRound   int       // The round of the last failed check in the run
+	Penalty    int       // Points taken away
+	CreatedAt  time.Time // When the violation was recorded
+}
+
+// A recorded password change request, without the new password
+type CredentialChange struct {
+	ID        int       // Corresponds to change_id in the database
+	TeamID    int       // The team that changed the password
+	Service   string    // The service's name, as in the services table
+	Username  string    // The user whose password changed
+	ChangedBy string    // Who submitted the change
+	ChangedAt time.Time // When the change was submitted
+}
+
+// A team's score broken down by where the points came from
+type TeamTotal struct {
+	ID               int    // The team's ID
+	Name             string // The team's name
+	ServicePoints    int    // Points from service checks
+	InjectPoints     int    // Points from graded injects
+	AdjustmentPoints int    // Sum of manual adjustments, negative if penalties outweigh bonuses
+	SLAPenalty       int    // Points lost to SLA violations, subtracted from the total
+	Total            int    // Everything added together
 }
 
 // A service type used explicitly for scoring
@@ -55,6 +212,30 @@ type ScoringService struct {
 	Disabled bool   // Corresponds to disabled in the database
 }
 
+// A service and whether it is being scored, for listing services
+type ServiceStatus struct {
+	ID            int    // Corresponds to service_id in the database
+	Name          string // Corresponds to service_name in the database
+	VMName        string // Corresponds to box_name in the database
+	Disabled      bool   // Disabled for every team
+	Retired       bool   // No longer in the configuration
+	DisabledTeams []int  // Teams the service is disabled for on its own
+}
+
+// A change to whether a service is scored, for every team or for one, that takes effect now or later
+type ServiceToggle struct {
+	ID        int       // Corresponds to toggle_id in the database
+	ServiceID int       // Corresponds to service_id in the database
+	Service   string    // The service's name
+	TeamID    int       // The team the toggle is for, 0 for every team
+	Disabled  bool      // Whether the toggle disables or enables the service
+	RunAt     time.Time // When the toggle takes effect
+	Reason    string    // Why, such as a maintenance inject
+	Author    string    // Who made the toggle
+	CreatedAt time.Time // When the toggle was made
+	AppliedAt time.Time // When the toggle took effect, zero while scheduled
+}
+
 // A team type used explicitly for scoring
 type ScoringTeam struct {
 	ID    int    // Corresponds to team_id in the database
@@ -62,6 +243,24 @@ type ScoringTeam struct {
 	Color string // Corresponds to team_color in the database
 }
 
+// CheckStatus is the outcome of a single service check
+type CheckStatus string
+
+const (
+	StatusUp      CheckStatus = "up"      // The service passed the check
+	StatusPartial CheckStatus = "partial" // The service passed some parts of a multi-part check
+	StatusDown    CheckStatus = "down"    // The service failed the check
+)
+
+// The result a scorer reports after checking a team's service
+type CheckResult struct {
+	Status   CheckStatus   // Whether the service passed the check
+	Points   int           // The points awarded for the check
+	Latency  time.Duration // How long the check took to run
+	Reason   string        // A human-readable explanation of the result, i.e. why a service is down
+	Evidence string        // Raw detail gathered during the check, kept for disputes and debugging
+}
+
 // Official competition virtual machines
 type OfficialVirtualMachine struct {
 	IP string
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types published by the scoring engine
+const (
+	TypeCheck      = "check"      // A check result was recorded
+	TypeTransition = "transition" // A service changed between up, partial and down
+	TypeRound      = "round"      // A scoring round finished
+	TypeSLA        = "sla"        // A service failed enough checks in a row to violate its SLA
+)
+
+// How many events a subscriber can fall behind by before it starts missing them
+const subscriberBuffer = 256
+
+// Event is a single message sent to subscribers.
+type Event struct {
+	ID   int64     // Increases by one for every event published
+	Type string    // One of the Type constants
+	Time time.Time // When the event was published
+	Data any       // The payload, encoded as JSON for clients
+}
+
+// Broker fans events out to every current subscriber. Publishing never blocks: a subscriber that
+// isn't keeping up has events dropped rather than holding up scoring.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	lastID      atomic.Int64
+}
+
+// NewBroker creates a broker with no subscribers.
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel that receives every event published from now on, and a function
+// to call once the subscriber is done, which closes the channel.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends an event to every subscriber.
+func (b *Broker) Publish(eventType string, data any) {
+	event := Event{ID: b.lastID.Add(1), Type: eventType, Time: time.Now(), Data: data}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default: // subscriber is full, drop the event for it
+		}
+	}
+}
+
+// Subscribers returns how many subscribers are connected.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// The broker the scoring engine publishes to and the API streams from
+var defaultBroker = NewBroker()
+
+// Subscribe subscribes to the default broker.
+func Subscribe() (<-chan Event, func()) { return defaultBroker.Subscribe() }
+
+// Publish publishes to the default broker.
+func Publish(eventType string, data any) { defaultBroker.Publish(eventType, data) }
+
+// CheckEvent is the payload of a check event, and of a transition event when the status changed.
+// The stream is public, so it leaves out the check's reason and evidence.
+type CheckEvent struct {
+	Round     int    `json:"round"`
+	TeamID    int    `json:"team_id"`
+	Service   string `json:"service"`
+	Status    string `json:"status"`             // up, partial or down
+	Previous  string `json:"previous,omitempty"` // The status at the previous check, absent for the first check
+	Points    int    `json:"points"`             // Points awarded by this check
+	LatencyMS int64  `json:"latency_ms"`         // How long the check took
+}
+
+// RoundEvent is the payload of a round event.
+type RoundEvent struct {
+	Round      int       `json:"round"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Up         int       `json:"up"`      // Checks that passed
+	Partial    int       `json:"partial"` // Checks that partly passed
+	Down       int       `json:"down"`    // Checks that failed
+	Skipped    int       `json:"skipped"` // Checks that never ran before the round deadline
+}
+
+// SLAEvent is the payload of an sla event.
+type SLAEvent struct {
+	TeamID     int    `json:"team_id"`
+	Service    string `json:"service"`
+	StartRound int    `json:"start_round"` // The round of the first failed check in the run
+	EndRound   int    `json:"end_round"`   // The round of the last failed check in the run
+	Penalty    int    `json:"penalty"`     // Points taken away
+}
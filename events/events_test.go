@@ -0,0 +1,36 @@
+package events
+
+import "testing"
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker()
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	broker.Publish(TypeRound, RoundEvent{Round: 1})
+	for _, ch := range []<-chan Event{first, second} {
+		event := <-ch
+		if event.ID != 1 || event.Type != TypeRound || event.Data.(RoundEvent).Round != 1 {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+
+	// Unsubscribing closes the channel and stops delivery
+	unsubscribeFirst()
+	unsubscribeFirst() // safe to call twice
+	if _, ok := <-first; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	if broker.Subscribers() != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", broker.Subscribers())
+	}
+
+	// A subscriber that falls behind misses events instead of blocking the publisher
+	for i := 0; i < subscriberBuffer+10; i++ {
+		broker.Publish(TypeCheck, CheckEvent{Round: 2})
+	}
+	if len(second) != subscriberBuffer {
+		t.Fatalf("expected a full buffer of %d events, got %d", subscriberBuffer, len(second))
+	}
+}
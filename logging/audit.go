@@ -0,0 +1,31 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditLogFile is where every administrative action is recorded, from the CLI and the API alike
+const AuditLogFile = "audit.log"
+
+var auditMu sync.Mutex
+
+// AuditLog writes a timestamped line describing an administrative action to the audit log.
+func AuditLog(action string) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	f, err := os.OpenFile(AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("Error opening audit log file: %v\n", err)
+		return
+	}
+	defer f.Close()
+	timestamp := time.Now().Format(time.RFC3339)
+	logLine := fmt.Sprintf("%s: %s\n", timestamp, action)
+	if _, err := f.WriteString(logLine); err != nil {
+		fmt.Printf("Error writing to audit log file: %v\n", err)
+	}
+}
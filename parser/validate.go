@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/services"
+	"github.com/go-yaml/yaml"
+)
+
+// requiredOfficialVMs are the official virtual machines the engine relies on. Any others are
+// allowed, but nothing uses them.
+var requiredOfficialVMs = []string{"router", "scorer", "dns"}
+
+// Validate runs every check ParseYAML does, and then lints the configuration further: the files
+// services read when scored must exist and parse, and keys NEST doesn't know and official virtual
+// machines nothing uses are pointed out. Problems that would stop the configuration from loading
+// or a service from ever being scored are returned as errors, the rest as warnings. Service files
+// are read relative to the working directory, as they are when scoring.
+func Validate(configsFolder, path string) (errs, warnings []error) {
+	cfg, errs := parseYAML(configsFolder, path)
+	if cfg == nil {
+		return errs, nil
+	}
+
+	// The configuration as written, before services were loaded from the virtual machines' files
+	var declared enum.YamlConfig
+	warnings = append(warnings, unknownKeys(path, &declared)...)
+
+	for _, vmName := range slices.Sorted(maps.Keys(cfg.VirtualMachines)) {
+		vm := cfg.VirtualMachines[vmName]
+		if vm.Config != "" {
+			if len(declared.VirtualMachines[vmName].Services) > 0 {
+				warnings = append(warnings, fmt.Errorf("virtual machine %s defines its services inline, so its config file %s is not used", vmName, vm.Config))
+			} else {
+				warnings = append(warnings, unknownKeys(filepath.Join(configsFolder, vm.Config), &map[string]enum.Service{})...)
+			}
+		}
+		for _, svcName := range slices.Sorted(maps.Keys(vm.Services)) {
+			for _, err := range services.CheckFiles(svcName, vm.Services[svcName]) {
+				errs = append(errs, fmt.Errorf("service '%s' in virtual machine '%s': %w", svcName, vmName, err))
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.OfficialVirtualMachines)) {
+		if !slices.Contains(requiredOfficialVMs, name) {
+			warnings = append(warnings, fmt.Errorf("official VM '%s' is not used, only 'router', 'scorer' and 'dns' are", name))
+		}
+	}
+	return errs, warnings
+}
+
+// unknownKeys unmarshals the YAML file at path into out strictly, returning a problem for every
+// key that doesn't belong to the configuration, such as a misspelled field, or that is repeated.
+// out is filled in as far as the file could be unmarshalled.
+func unknownKeys(path string, out interface{}) []error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil // Reported when the configuration is parsed
+	}
+
+	var typeErr *yaml.TypeError
+	if err := yaml.UnmarshalStrict(file, out); !errors.As(err, &typeErr) {
+		return nil
+	}
+	var problems []error
+	for _, message := range typeErr.Errors {
+		problems = append(problems, fmt.Errorf("%s: %s", filepath.Base(path), message))
+	}
+	return problems
+}
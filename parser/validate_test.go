@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validConfig = `virtual-machines:
+  web:
+    ip-schema: 192.168.T.5
+    services:
+      web80:
+        port: 80
+official-virtual-machines:
+  router:
+    ip: 10.20.0.1
+  scorer:
+    ip: 10.20.0.5
+  dns:
+    ip: 10.20.0.10
+teams:
+  team1:
+    id: 1
+    name: team1
+    password: team1
+    color: "#02c21f"
+`
+
+// writeConfig writes a main.yaml with the given contents to a new configs folder.
+func writeConfig(t *testing.T, contents string) (string, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, path
+}
+
+func TestValidate(t *testing.T) {
+	dir, path := writeConfig(t, validConfig)
+	if cfg, err := ParseYAML(dir, path); err != nil || cfg.VirtualMachines["web"].Services["web80"].Award != 1 {
+		t.Fatalf("expected the configuration to parse with default awards, got %v", err)
+	}
+	if errs, warnings := Validate(dir, path); len(errs) != 0 || len(warnings) != 0 {
+		t.Fatalf("expected no problems, got %v and warnings %v", errs, warnings)
+	}
+
+	// Every problem is reported, not just the first
+	broken := strings.NewReplacer(
+		"web80:", "webby:",
+		"    password: team1\n", "    password: team1\n  team2:\n    id: 1\n    name: team2\n    colour: red\n",
+		"  dns:\n", "  backup:\n    ip: 10.20.0.11\n  dns:\n",
+	).Replace(validConfig)
+	dir, path = writeConfig(t, broken)
+	_, err := ParseYAML(dir, path)
+	for _, want := range []string{"unknown service type 'webby'", "have the same id 1", "team 'team2' must have a password"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected the error to mention %q, got %v", want, err)
+		}
+	}
+
+	errs, warnings := Validate(dir, path)
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0].Error(), "field colour not found") || !strings.Contains(warnings[1].Error(), "official VM 'backup' is not used") {
+		t.Fatalf("expected the unknown key and unused official VM to be flagged, got %v", warnings)
+	}
+
+	// Files services read are checked too
+	dir, path = writeConfig(t, strings.Replace(validConfig, "web80:\n        port: 80\n", "dnsinternalfwd:\n        port: 53\n        query_file: missing.txt\n", 1))
+	if _, err := ParseYAML(dir, path); err != nil {
+		t.Fatalf("expected the configuration to parse, got %v", err)
+	}
+	if errs, _ := Validate(dir, path); len(errs) != 1 || !strings.Contains(errs[0].Error(), "missing.txt") {
+		t.Fatalf("expected the missing query file to be reported, got %v", errs)
+	}
+}
+
+func TestNegativeHistoryRetention(t *testing.T) {
+	dir, path := writeConfig(t, validConfig+"scoring:\n  history-retention: -5\n")
+	if _, err := ParseYAML(dir, path); err == nil || !strings.Contains(err.Error(), `"scoring" values cannot be negative`) {
+		t.Fatalf("expected a negative history retention to be refused, got %v", err)
+	}
+}
+
+func TestMalformedPasswordHash(t *testing.T) {
+	dir, path := writeConfig(t, strings.Replace(validConfig, "password: team1", `password: "$argon2id$v=19$m=65536,t=0,p=0$c2FsdA$a2V5"`, 1))
+	if _, err := ParseYAML(dir, path); err == nil || !strings.Contains(err.Error(), "team 'team1' has an invalid password hash") {
+		t.Fatalf("expected the malformed hash to be reported, got %v", err)
+	}
+}
+
+func TestUnusedOverride(t *testing.T) {
+	dir, path := writeConfig(t, strings.Replace(validConfig, "        port: 80\n", "        port: 80\n        teams:\n          \"1\":\n            query_dir: team1\n", 1))
+	if _, err := ParseYAML(dir, path); err == nil || !strings.Contains(err.Error(), "can't be overridden for team '1'") {
+		t.Fatalf("expected a query directory override web80 doesn't use to be refused, got %v", err)
+	}
+}
@@ -3,11 +3,16 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/LTSEC/NEST/address"
+	"github.com/LTSEC/NEST/auth"
 	"github.com/LTSEC/NEST/enum"
 	"github.com/LTSEC/NEST/services"
 	"github.com/go-yaml/yaml"
@@ -15,128 +20,303 @@ import (
 
 // Parse loads and validates the configuration from the given YAML file path.
 // It enforces that the main YAML has "virtual-machines" and "teams" sections,
-// that there is at least one virtual machine, and that each virtual machine has a valid ip-schema
+// that there is at least one virtual machine, and that each virtual machine has an address for every team
 // and at least one service with a defined port (either inline or in an external config file).
+// Every problem found is returned, joined into one error.
 func ParseYAML(configsFolder, path string) (*enum.YamlConfig, error) {
+	cfg, problems := parseYAML(configsFolder, path)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return cfg, nil
+}
+
+// parseYAML loads the configuration and runs every check on it, carrying on past failed checks so
+// all of the problems are found at once. The configuration is nil only if the file couldn't be
+// read or unmarshalled.
+func parseYAML(configsFolder, path string) (*enum.YamlConfig, []error) {
 	// Read main YAML file.
 	file, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open the file: %w", err)
+		return nil, []error{fmt.Errorf("failed to open the file: %w", err)}
 	}
 
 	var cfg enum.YamlConfig
 	if err := yaml.Unmarshal(file, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal the YAML: %w", err)
+		return nil, []error{fmt.Errorf("failed to unmarshal the YAML: %w", err)}
 	}
 
 	// Validate required top-level sections.
+	var problems []error
 	if cfg.VirtualMachines == nil {
-		return nil, errors.New(`configuration missing required "virtual-machines" section`)
+		problems = append(problems, errors.New(`configuration missing required "virtual-machines" section`))
 	}
 	if cfg.OfficialVirtualMachines == nil {
-		return nil, errors.New(`configuration missing required "official-virtual-machines" section`)
+		problems = append(problems, errors.New(`configuration missing required "official-virtual-machines" section`))
 	}
 	if cfg.Teams == nil {
-		return nil, errors.New(`configuration missing required "teams" section`)
+		problems = append(problems, errors.New(`configuration missing required "teams" section`))
+	}
+	if len(problems) > 0 {
+		return &cfg, problems
 	}
 
-	// Don't allow a team ID of 0 or less
-	for _, team := range cfg.Teams {
-		if team.ID <= 0 {
-			return nil, errors.New(`configuration cannot have a team with id of "0" or less`)
-		}
+	// Don't allow a team ID of 0 or less, or two teams with the same ID or name
+	problems = append(problems, validateTeams(cfg.Teams)...)
+
+	// Read team passwords kept in secrets files
+	problems = append(problems, loadTeamPasswords(configsFolder, cfg.Teams)...)
+
+	// Engine tuning values are optional, but they can never be negative
+	if cfg.Scoring.RefreshTime < 0 || cfg.Scoring.Workers < 0 || cfg.Scoring.RoundDeadline < 0 || cfg.Scoring.HistoryRetention < 0 {
+		problems = append(problems, errors.New(`"scoring" values cannot be negative`))
 	}
 
 	// Ensure there is at least one virtual machine.
 	if len(cfg.VirtualMachines) == 0 {
-		return nil, errors.New("there must be at least one virtual machine defined")
-	}
-
-	if len(cfg.OfficialVirtualMachines) < 3 {
-		return nil, errors.New("there must be router, scorer, and dns official virtual machines defined")
+		problems = append(problems, errors.New("there must be at least one virtual machine defined"))
 	}
 
 	// Check to make sure the three vms in OfficialVirtualMachines are named correctly
-	required := []string{"router", "scorer", "dns"}
 	var missing []string
-
-	for _, key := range required {
+	for _, key := range requiredOfficialVMs {
 		if _, ok := cfg.OfficialVirtualMachines[key]; !ok {
-			missing = append(missing, key)
+			missing = append(missing, "'"+key+"'")
 		}
 	}
-
 	if len(missing) > 0 {
-		var quoted []string
-		for _, m := range missing {
-			quoted = append(quoted, "'"+m+"'")
-		}
-		return nil, fmt.Errorf("missing official VMs %s in config", strings.Join(quoted, ", "))
+		problems = append(problems, fmt.Errorf("missing official VMs %s in config", strings.Join(missing, ", ")))
 	}
 
 	// Process each virtual machine.
-	for vmName, vm := range cfg.VirtualMachines {
-		// Validate the ip-schema.
-		if err := validateIPSchema(vm.IPSchema); err != nil {
-			return nil, fmt.Errorf("invalid ip-schema for virtual machine %s: %w", vmName, err)
+	for _, vmName := range slices.Sorted(maps.Keys(cfg.VirtualMachines)) {
+		vm := cfg.VirtualMachines[vmName]
+
+		// Validate the ip-schema and per team addresses.
+		if err := validateAddresses(vm, cfg.Teams); err != nil {
+			problems = append(problems, fmt.Errorf("invalid address for virtual machine %s: %w", vmName, err))
 		}
 
 		// Validate service configuration.
 		// If no inline services are defined, try to load them from the external config file.
 		if len(vm.Services) == 0 {
 			if vm.Config == "" {
-				return nil, fmt.Errorf("virtual machine %s must define at least one service or provide a config file", vmName)
-			}
-			services, err := loadServicesFromConfig(filepath.Join(configsFolder, vm.Config), vmName)
-			if err != nil {
-				return nil, err
+				problems = append(problems, fmt.Errorf("virtual machine %s must define at least one service or provide a config file", vmName))
+				continue
 			}
+			services, errs := loadServicesFromConfig(filepath.Join(configsFolder, vm.Config), vmName)
+			problems = append(problems, errs...)
 			vm.Services = services
 			cfg.VirtualMachines[vmName] = vm
 		} else {
 			// Ensure at least one service defines a port.
-			if err := validateServices(vm.Services, vmName); err != nil {
-				return nil, err
+			problems = append(problems, validateServices(vm.Services, vmName)...)
+		}
+	}
+
+	// SLA rules and team overrides can only be checked once every service has been loaded
+	problems = append(problems, validateSLARules(&cfg)...)
+	problems = append(problems, validateTeamOverrides(&cfg)...)
+
+	return &cfg, problems
+}
+
+// validateTeams checks that every team has a positive ID, and that no two teams share an ID or a
+// name, as teams are told apart by both.
+func validateTeams(teams map[string]enum.Team) []error {
+	var problems []error
+	ids, names := make(map[int]string), make(map[string]string)
+	for _, key := range slices.Sorted(maps.Keys(teams)) {
+		team := teams[key]
+		if team.ID <= 0 {
+			problems = append(problems, fmt.Errorf(`team '%s' cannot have an id of "0" or less`, key))
+		} else if other, taken := ids[team.ID]; taken {
+			problems = append(problems, fmt.Errorf("teams '%s' and '%s' have the same id %d", other, key, team.ID))
+		} else {
+			ids[team.ID] = key
+		}
+		if other, taken := names[team.Name]; taken && team.Name != "" {
+			problems = append(problems, fmt.Errorf("teams '%s' and '%s' have the same name '%s'", other, key, team.Name))
+		} else {
+			names[team.Name] = key
+		}
+	}
+	return problems
+}
+
+// loadTeamPasswords fills in the passwords of teams that give a password_file, read relative to
+// the configs folder, and checks that every team has a password. Passwords can be given as
+// argon2id hashes, which must be well formed.
+func loadTeamPasswords(configsFolder string, teams map[string]enum.Team) []error {
+	var problems []error
+	for _, key := range slices.Sorted(maps.Keys(teams)) {
+		team := teams[key]
+		if team.PasswordFile != "" {
+			if team.Password != "" {
+				problems = append(problems, fmt.Errorf("team '%s' cannot have both a password and a password_file", key))
+				continue
+			}
+			path := team.PasswordFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(configsFolder, path)
+			}
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("failed to read the password file of team '%s': %w", key, err))
+				continue
+			}
+			team.Password = strings.TrimSpace(string(contents))
+		}
+
+		if team.Password == "" {
+			problems = append(problems, fmt.Errorf("team '%s' must have a password or password_file", key))
+		} else if auth.IsPasswordHash(team.Password) {
+			if err := auth.ValidatePasswordHash(team.Password); err != nil {
+				problems = append(problems, fmt.Errorf("team '%s' has an invalid password hash: %w", key, err))
+			}
+		}
+		teams[key] = team
+	}
+	return problems
+}
+
+// validateSLARules checks the global and per-service SLA rules. A rule can't be negative, and
+// with history retention on it can't need more consecutive checks than the history keeps.
+func validateSLARules(cfg *enum.YamlConfig) []error {
+	check := func(rule *enum.SLARule, where string) error {
+		if rule == nil {
+			return nil
+		}
+		if rule.Threshold < 0 || rule.Penalty < 0 {
+			return fmt.Errorf("the SLA rule %s cannot be negative", where)
+		}
+		if retention := cfg.Scoring.HistoryRetention; retention > 0 && rule.Threshold > retention {
+			return fmt.Errorf("the SLA rule %s needs %d consecutive checks but history-retention only keeps %d rounds", where, rule.Threshold, retention)
+		}
+		return nil
+	}
+
+	var problems []error
+	if err := check(cfg.Scoring.SLA, `in "scoring"`); err != nil {
+		problems = append(problems, err)
+	}
+	for _, vmName := range slices.Sorted(maps.Keys(cfg.VirtualMachines)) {
+		vm := cfg.VirtualMachines[vmName]
+		for _, svcName := range slices.Sorted(maps.Keys(vm.Services)) {
+			if err := check(vm.Services[svcName].SLA, fmt.Sprintf("of service '%s' in virtual machine '%s'", svcName, vmName)); err != nil {
+				problems = append(problems, err)
 			}
 		}
 	}
+	return problems
+}
 
-	return &cfg, nil
+// validateAddresses checks that a virtual machine has a usable address for every team. Addresses
+// map keys must name a team, and the ip-schema, which may only be left out when the map covers
+// every team, must expand to an IP address or hostname for each of them.
+func validateAddresses(vm enum.VirtualMachine, teams map[string]enum.Team) error {
+	known := make(map[string]bool)
+	for _, team := range teams {
+		known[strconv.Itoa(team.ID)] = true
+		known[team.Name] = true
+	}
+	for key := range vm.Addresses {
+		if !known[key] {
+			return fmt.Errorf("address given for unknown team '%s'", key)
+		}
+	}
+
+	if vm.IPSchema != "" {
+		if _, err := address.ParseSchema(vm.IPSchema); err != nil {
+			return err
+		}
+	}
+	for _, team := range teams {
+		if _, err := address.ForTeam(vm, team.ID, team.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTeamOverrides checks that every per-team service override names a team in the "teams"
+// section, by ID or name, and has sensible values.
+func validateTeamOverrides(cfg *enum.YamlConfig) []error {
+	teams := make(map[string]bool)
+	for _, team := range cfg.Teams {
+		teams[strconv.Itoa(team.ID)] = true
+		teams[team.Name] = true
+	}
+
+	var problems []error
+	for _, vmName := range slices.Sorted(maps.Keys(cfg.VirtualMachines)) {
+		vm := cfg.VirtualMachines[vmName]
+		for _, svcName := range slices.Sorted(maps.Keys(vm.Services)) {
+			svc := vm.Services[svcName]
+			for _, key := range slices.Sorted(maps.Keys(svc.Teams)) {
+				override := svc.Teams[key]
+				if !teams[key] {
+					problems = append(problems, fmt.Errorf("service '%s' in virtual machine '%s' overrides unknown team '%s'", svcName, vmName, key))
+				}
+				if override.Port < 0 || override.Port > 65535 || override.Award < 0 {
+					problems = append(problems, fmt.Errorf("service '%s' in virtual machine '%s' has an invalid port or award for team '%s'", svcName, vmName, key))
+				}
+				if override.Password != "" && override.User == "" {
+					problems = append(problems, fmt.Errorf("service '%s' in virtual machine '%s' sets a password without a user for team '%s'", svcName, vmName, key))
+				}
+				for _, err := range services.CheckOverride(svcName, override) {
+					problems = append(problems, fmt.Errorf("service '%s' in virtual machine '%s' can't be overridden for team '%s': %w", svcName, vmName, key, err))
+				}
+			}
+		}
+	}
+	return problems
 }
 
 // loadServicesFromConfig attempts to read an external YAML file specified by configPath,
 // unmarshals it into a map of services, and validates that at least one service defines a port.
-func loadServicesFromConfig(configPath, vmName string) (map[string]enum.Service, error) {
+func loadServicesFromConfig(configPath, vmName string) (map[string]enum.Service, []error) {
 	serviceFile, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file %s for virtual machine %s: %w", configPath, vmName, err)
+		return nil, []error{fmt.Errorf("failed to open config file %s for virtual machine %s: %w", configPath, vmName, err)}
 	}
 	var services map[string]enum.Service
 	if err := yaml.Unmarshal(serviceFile, &services); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal services from config file %s for virtual machine %s: %w", configPath, vmName, err)
-	}
-	if err := validateServices(services, vmName); err != nil {
-		return nil, err
+		return nil, []error{fmt.Errorf("failed to unmarshal services from config file %s for virtual machine %s: %w", configPath, vmName, err)}
 	}
-	return services, nil
+	return services, validateServices(services, vmName)
 }
 
-// validateServices ensures that the provided services map contains at least one service with a nonzero port.
-func validateServices(yamlservices map[string]enum.Service, vmName string) error {
+// validateServices ensures that the provided services map contains at least one service, and that
+// every service is of a known type with a nonzero port.
+func validateServices(yamlservices map[string]enum.Service, vmName string) []error {
 	if len(yamlservices) == 0 {
-		return fmt.Errorf("virtual machine %s must have at least one service defined", vmName)
+		return []error{fmt.Errorf("virtual machine %s must have at least one service defined", vmName)}
 	}
-	valid := false
-	for svcName, svc := range yamlservices {
+	var problems []error
+	for _, svcName := range slices.Sorted(maps.Keys(yamlservices)) {
+		svc := yamlservices[svcName]
 
 		// Check is its a valid service
-		if _, ok := services.ScoringDispatch[svcName]; !ok {
-			return fmt.Errorf("unknown service type '%s' in virtual machine '%s'", svcName, vmName)
+		if _, ok := services.Lookup(svcName); !ok {
+			problems = append(problems, fmt.Errorf("unknown service type '%s' in virtual machine '%s'", svcName, vmName))
 		}
 
 		// Check if there is a port
 		if svc.Port == 0 {
-			return fmt.Errorf("service '%s' in virtual machine '%s' does not define a port", svcName, vmName)
+			problems = append(problems, fmt.Errorf("service '%s' in virtual machine '%s' does not define a port", svcName, vmName))
+		}
+
+		// Check that every command case can be run and its regex compiles
+		for i, command := range svc.Commands {
+			if strings.TrimSpace(command.Command) == "" {
+				problems = append(problems, fmt.Errorf("command %d of service '%s' in virtual machine '%s' is empty", i+1, svcName, vmName))
+			}
+			if command.Regex != "" {
+				if _, err := regexp.Compile(command.Regex); err != nil {
+					problems = append(problems, fmt.Errorf("command %d of service '%s' in virtual machine '%s' has an invalid regex: %w", i+1, svcName, vmName, err))
+				}
+			}
 		}
 
 		// Define a default award
@@ -145,45 +325,6 @@ func validateServices(yamlservices map[string]enum.Service, vmName string) error
 		}
 
 		yamlservices[svcName] = svc // svc is a copy, assign as original
-
-		// If everything is valid, we're good
-		valid = true
 	}
-	if !valid {
-		return fmt.Errorf("virtual machine %s must have at least one service with a defined port", vmName)
-	}
-	return nil
-}
-
-// validateIPSchema checks that the ip-schema is in the correct format.
-// It requires 4 octets separated by dots where:
-//   - The first and second octet must be valid numbers.
-//   - The third and fourth octet: each must be either a valid number or the letter "T" (case-insensitive),
-//     but only one of these two octets can be "T".
-func validateIPSchema(ip string) error {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return fmt.Errorf("ip-schema must have 4 octets, got %d", len(parts))
-	}
-	// Validate first and second octets are numbers.
-	for i := 0; i < 2; i++ {
-		if _, err := strconv.Atoi(parts[i]); err != nil {
-			return fmt.Errorf("octet %d (%s) is not a valid number", i+1, parts[i])
-		}
-	}
-	// Validate third and fourth octets.
-	tCount := 0
-	for i := 2; i < 4; i++ {
-		if strings.EqualFold(parts[i], "T") {
-			tCount++
-		} else {
-			if _, err := strconv.Atoi(parts[i]); err != nil {
-				return fmt.Errorf("octet %d (%s) is not a valid number or 'T'", i+1, parts[i])
-			}
-		}
-	}
-	if tCount > 1 {
-		return fmt.Errorf("only one of the third or fourth octet can be 'T'")
-	}
-	return nil
+	return problems
 }
@@ -0,0 +1,219 @@
+package scoring
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/parser"
+	"github.com/LTSEC/NEST/services"
+)
+
+// Engine tuning used when the configuration doesn't set it
+const (
+	defaultRefreshTime = 15
+	defaultMaxWorkers  = 10
+)
+
+// ErrInvalidConfig is returned by Reload when the configuration on disk doesn't parse or validate.
+var ErrInvalidConfig = errors.New("the configuration is invalid, keeping the running one")
+
+// currentConfig returns the running configuration, or nil before the engine is initialized.
+func currentConfig() *enum.YamlConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return yamlConfig
+}
+
+// setConfig makes cfg the running configuration and applies its engine tuning.
+func setConfig(cfg *enum.YamlConfig) {
+	configMu.Lock()
+	yamlConfig = cfg
+	configMu.Unlock()
+
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	RefreshTime, MaxWorkers, RoundDeadline, HistoryRetention = defaultRefreshTime, defaultMaxWorkers, 0, 0
+	if cfg.Scoring.RefreshTime > 0 {
+		RefreshTime = cfg.Scoring.RefreshTime
+	}
+	if cfg.Scoring.Workers > 0 {
+		MaxWorkers = cfg.Scoring.Workers
+	}
+	if cfg.Scoring.RoundDeadline > 0 {
+		RoundDeadline = cfg.Scoring.RoundDeadline
+	}
+	if cfg.Scoring.HistoryRetention > 0 {
+		HistoryRetention = cfg.Scoring.HistoryRetention
+	}
+}
+
+// ConfigChanges is how a reloaded configuration differs from the one it replaced. Services are
+// named "<box>_<service>" as in the database.
+type ConfigChanges struct {
+	BoxesAdded      []string `json:"boxes_added"`
+	BoxesRemoved    []string `json:"boxes_removed"`
+	BoxesChanged    []string `json:"boxes_changed"` // Boxes whose addresses changed
+	ServicesAdded   []string `json:"services_added"`
+	ServicesRemoved []string `json:"services_removed"`
+	ServicesChanged []string `json:"services_changed"`
+	TeamsAdded      []string `json:"teams_added"`
+	TeamsRemoved    []string `json:"teams_removed"`
+	TeamsChanged    []string `json:"teams_changed"`    // Teams whose name or color changed
+	OfficialChanged bool     `json:"official_changed"` // The official boxes, such as the DNS server external lookups use
+	ScoringChanged  bool     `json:"scoring_changed"`  // Engine tuning or the global SLA rule
+}
+
+func (c ConfigChanges) String() string {
+	parts := []string{}
+	for _, part := range []struct {
+		what  string
+		names []string
+	}{
+		{"boxes added", c.BoxesAdded},
+		{"boxes removed", c.BoxesRemoved},
+		{"boxes changed", c.BoxesChanged},
+		{"services added", c.ServicesAdded},
+		{"services removed", c.ServicesRemoved},
+		{"services changed", c.ServicesChanged},
+		{"teams added", c.TeamsAdded},
+		{"teams removed", c.TeamsRemoved},
+		{"teams changed", c.TeamsChanged},
+	} {
+		if len(part.names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", part.what, strings.Join(part.names, ", ")))
+		}
+	}
+	if c.OfficialChanged {
+		parts = append(parts, "official boxes changed")
+	}
+	if c.ScoringChanged {
+		parts = append(parts, "scoring settings changed")
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// diffConfigs works out how next differs from current.
+func diffConfigs(current, next *enum.YamlConfig) ConfigChanges {
+	changes := ConfigChanges{
+		BoxesAdded: []string{}, BoxesRemoved: []string{}, BoxesChanged: []string{},
+		ServicesAdded: []string{}, ServicesRemoved: []string{}, ServicesChanged: []string{},
+		TeamsAdded: []string{}, TeamsRemoved: []string{}, TeamsChanged: []string{},
+	}
+
+	for name, vm := range next.VirtualMachines {
+		old, exists := current.VirtualMachines[name]
+		if !exists {
+			changes.BoxesAdded = append(changes.BoxesAdded, name)
+		} else {
+			// The box's own settings, leaving its services to be compared one at a time
+			box, oldBox := vm, old
+			box.Services, oldBox.Services = nil, nil
+			if !reflect.DeepEqual(box, oldBox) {
+				changes.BoxesChanged = append(changes.BoxesChanged, name)
+			}
+		}
+		for serviceName, service := range vm.Services {
+			fullName := fmt.Sprintf("%s_%s", name, serviceName)
+			if oldService, exists := old.Services[serviceName]; !exists {
+				changes.ServicesAdded = append(changes.ServicesAdded, fullName)
+			} else if !reflect.DeepEqual(service, oldService) {
+				changes.ServicesChanged = append(changes.ServicesChanged, fullName)
+			}
+		}
+	}
+	for name, old := range current.VirtualMachines {
+		vm, exists := next.VirtualMachines[name]
+		if !exists {
+			changes.BoxesRemoved = append(changes.BoxesRemoved, name)
+		}
+		for serviceName := range old.Services {
+			if _, exists := vm.Services[serviceName]; !exists {
+				changes.ServicesRemoved = append(changes.ServicesRemoved, fmt.Sprintf("%s_%s", name, serviceName))
+			}
+		}
+	}
+
+	// Teams are matched by ID, since that is what they are known by in the database
+	currentTeams, nextTeams := teamsByID(current), teamsByID(next)
+	for id, team := range nextTeams {
+		if old, exists := currentTeams[id]; !exists {
+			changes.TeamsAdded = append(changes.TeamsAdded, team.Name)
+		} else if old.Name != team.Name || old.Color != team.Color {
+			changes.TeamsChanged = append(changes.TeamsChanged, team.Name)
+		}
+	}
+	for id, team := range currentTeams {
+		if _, exists := nextTeams[id]; !exists {
+			changes.TeamsRemoved = append(changes.TeamsRemoved, team.Name)
+		}
+	}
+
+	changes.OfficialChanged = !reflect.DeepEqual(current.OfficialVirtualMachines, next.OfficialVirtualMachines)
+	changes.ScoringChanged = !reflect.DeepEqual(current.Scoring, next.Scoring)
+
+	for _, names := range [][]string{
+		changes.BoxesAdded, changes.BoxesRemoved, changes.BoxesChanged,
+		changes.ServicesAdded, changes.ServicesRemoved, changes.ServicesChanged,
+		changes.TeamsAdded, changes.TeamsRemoved, changes.TeamsChanged,
+	} {
+		sort.Strings(names)
+	}
+	return changes
+}
+
+// teamsByID indexes a configuration's teams by their IDs.
+func teamsByID(cfg *enum.YamlConfig) map[int]enum.Team {
+	teams := make(map[int]enum.Team, len(cfg.Teams))
+	for _, team := range cfg.Teams {
+		teams[team.ID] = team
+	}
+	return teams
+}
+
+// ReloadSummary is what a configuration reload changed.
+type ReloadSummary struct {
+	Changes ConfigChanges `json:"changes"`
+	Resync  ResyncSummary `json:"resync"` // What bringing the database in line with the new configuration did
+}
+
+func (s ReloadSummary) String() string {
+	return fmt.Sprintf("%s (%s)", s.Changes, s.Resync)
+}
+
+// Reload re-reads the configuration from ConfigFile and, if it is valid, swaps it in for the
+// running one between rounds, then resyncs the database so new teams and services are scored
+// and removed services are retired. An invalid configuration leaves the running one in place.
+func Reload(db *sql.DB) (ReloadSummary, error) {
+	var summary ReloadSummary
+	if currentConfig() == nil {
+		return summary, errors.New("the scoring engine has not loaded the configuration yet")
+	}
+
+	cfg, err := parser.ParseYAML(ConfigFolder, ConfigFile)
+	if err != nil {
+		return summary, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	// Wait for the round being scored to finish, so no round is scored with a mix of configurations
+	roundMu.Lock()
+	defer roundMu.Unlock()
+
+	summary.Changes = diffConfigs(currentConfig(), cfg)
+	setConfig(cfg)
+	services.SetConfig(cfg)
+
+	summary.Resync, err = Resync(db)
+	if err != nil {
+		return summary, fmt.Errorf("the configuration was reloaded, but resyncing the database failed: %w", err)
+	}
+	logger.LogMessage(fmt.Sprintf("Reloaded the configuration: %s.", summary), "STATUS")
+	return summary, nil
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/LTSEC/NEST/address"
 	"github.com/LTSEC/NEST/database"
 	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/events"
 	"github.com/LTSEC/NEST/logging"
 	"github.com/LTSEC/NEST/services"
 )
@@ -16,12 +20,26 @@ import (
 var (
 	// Vars
 
-	ScoringEnabled   bool      // Whether scoring has been enabled yet
-	ScoringKilled    bool      // Represents when an order to cease scoring comes through
-	ScoringPaused    bool      // Represents when scoring has been paused
-	ScoringIteration int       // The current iteration of scoring, i.e. the 50th round of scoring
-	RefreshTime      int  = 15 // How long to wait (in seconds) between scoring rounds
-	ScoringRound     int       // The current round of scoring
+	ScoringEnabled   bool               // Whether scoring has been enabled yet
+	ScoringKilled    bool               // Represents when an order to cease scoring comes through
+	ScoringPaused    bool               // Represents when scoring has been paused
+	ScoringIteration int                // The current iteration of scoring, i.e. the 50th round of scoring
+	RefreshTime      int           = 15 // How long to wait (in seconds) between the start of each scoring round
+	ScoringRound     int                // The current round of scoring
+	LastRoundTime    time.Duration      // How long the last scoring round took
+	NextRoundAt      time.Time          // When the next round is due to start, zero while not running
+	MaxWorkers       int           = 10 // The maximum number of service checks that may run at the same time
+	RoundDeadline    int                // How long (in seconds) a round may run before outstanding checks are abandoned, 0 uses RefreshTime
+	HistoryRetention int                // How many rounds of check history to keep in the database, 0 keeps everything
+	ConfigFolder     string             // The folder of configuration files, which Reload reads from
+	ConfigFile       string             // The main yaml configuration file, which Reload reads
+	// Guards the engine state above, which is shared between the scoring loop, the CLI and the API
+	engineMu sync.Mutex
+	// Held while a round is scored, so a reload only swaps the configuration between rounds
+	roundMu sync.Mutex
+	// Guards yamlConfig, for readers outside of a round
+	configMu sync.RWMutex
+
 	// Pointers
 
 	logger     *logging.Logger  // Pointer to the active logger
@@ -44,48 +62,87 @@ func Initalize(newdb *sql.DB, newyamlConfig *enum.YamlConfig, newlogger *logging
 	logger.LogMessage("Scoring initalization started.", "STATUS")
 
 	db = newdb
-	yamlConfig = newyamlConfig
+	setConfig(newyamlConfig)
 
-	ScoringRound = 0 // Set the scoring round to 0
+	// Continue numbering rounds from wherever the database left off, so a restarted engine
+	// doesn't record checks against rounds that already happened
+	latestRound, err := database.GetLatestRound(db)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while getting the latest scoring round: %v", err), "ERROR")
+		return err
+	}
+	engineMu.Lock()
+	ScoringRound = latestRound
+	engineMu.Unlock()
 
 	logging.ConsoleLogMessage("Loading teams...")
-	// The second step is to add all the teams from the yaml configuration to the database
-	for _, team := range yamlConfig.Teams {
-		// Add the team
-		if err := database.AddTeamToDatabase(db, team, nil); err != nil {
-			logger.LogMessage(fmt.Sprintf("Error occured while adding team %s to the database: %v", team.Name, err), "ERROR")
-			return err
-		}
-		logging.ConsoleLogSuccess(fmt.Sprintf("Team %s loaded, loading services...", team.Name))
-
-		// If the team was added successfully, we add each virtual machine's services to the team
-		for vmName, vm := range yamlConfig.VirtualMachines {
-			if err := addServicesToTeam(db, team.ID, vmName, vm); err != nil {
-				logger.LogMessage(fmt.Sprintf("Error occured while adding service on box %s to team %s: %v", vmName, team.Name, err), "ERROR")
-				return fmt.Errorf("failed to add services for team %s from box %s: %w", team.Name, vmName, err)
-			}
-		}
-		logging.ConsoleLogSuccess(fmt.Sprintf("Team %s services loaded.", team.Name))
+	// The second step is to bring the teams and their services in the database in line with the
+	// yaml configuration, mapping each service to every team for scoring
+	summary, err := Resync(db)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while loading teams into the database: %v", err), "ERROR")
+		return err
 	}
+	logging.ConsoleLogSuccess(fmt.Sprintf("Teams loaded: %s.", summary))
 
 	// Scoring loop
 	for {
-		if ScoringEnabled || ScoringPaused {
-			score()
-			time.Sleep(time.Second * time.Duration(RefreshTime))
-		} else if ScoringKilled {
+		engineMu.Lock()
+		running, killed := ScoringEnabled && !ScoringPaused, ScoringKilled
+		engineMu.Unlock()
+
+		if killed {
 			break
 		}
+		if !running {
+			// Wait to be started or resumed
+			time.Sleep(engineIdleWait)
+			continue
+		}
+
+		// Rounds start every RefreshTime seconds, so the time spent scoring comes out of the wait
+		started := time.Now()
+		engineMu.Lock()
+		NextRoundAt = started.Add(time.Second * time.Duration(RefreshTime))
+		engineMu.Unlock()
+
+		score()
+
+		engineMu.Lock()
+		LastRoundTime = time.Since(started)
+		next := NextRoundAt
+		engineMu.Unlock()
+
+		waitForNextRound(next)
 	}
 
 	return nil
 }
 
+// How often an idle scoring loop checks whether it has been started or resumed
+const engineIdleWait = 250 * time.Millisecond
+
+// waitForNextRound sleeps until the next round is due, returning early if the engine is paused
+// or stopped in the meantime.
+func waitForNextRound(next time.Time) {
+	for time.Now().Before(next) {
+		engineMu.Lock()
+		running := ScoringEnabled && !ScoringPaused
+		engineMu.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(min(engineIdleWait, time.Until(next)))
+	}
+}
+
 // addServicesToTeam does as its name implies, by taking in a teamID, vmName, and vm object it is able to map each service to a team for scoring.
-func addServicesToTeam(db *sql.DB, teamID int, vmName string, vm enum.VirtualMachine) error {
+// It returns how many of the services the team didn't have yet.
+func addServicesToTeam(db *sql.DB, teamID int, vmName string, vm enum.VirtualMachine) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	added := 0
 	for serviceName := range vm.Services {
 		// Concatenate the box name with the service name for a unique service name
 		fullServiceName := fmt.Sprintf("%s_%s", vmName, serviceName)
@@ -111,26 +168,64 @@ func addServicesToTeam(db *sql.DB, teamID int, vmName string, vm enum.VirtualMac
 
 		// Insert into team_services, associating the team with the service
 		query := `INSERT INTO team_services (team_id, service_id, points, is_up) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING;`
-		_, err = db.ExecContext(ctx, query, teamID, serviceID, 0, false) // Default points = 0, is_up = false
+		result, err := db.ExecContext(ctx, query, teamID, serviceID, 0, false) // Default points = 0, is_up = false
 		if err != nil {
 			logger.LogMessage(fmt.Sprintf("Failed to insert team-service relationship for team %d and service %s: %s", teamID, fullServiceName, err.Error()), "ERROR")
+			continue
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			added += int(n)
 		}
 	}
 
-	return nil
+	return added, nil
+}
+
+// checkJob is a single team's service that needs to be scored during a round.
+type checkJob struct {
+	round   int
+	team    enum.ScoringTeam
+	service enum.ScoringService
+}
+
+// checkOutcome carries the return values of serviceSelector back from the goroutine running it.
+type checkOutcome struct {
+	result enum.CheckResult
+	err    error
 }
 
 // The function called to score all included services.
+//
+// Every (team, service) pair becomes its own job, and the jobs are fanned out over a pool of
+// at most MaxWorkers goroutines. The round is bounded by RoundDeadline; jobs that have not
+// started by then are skipped, and checks still running are abandoned.
 func score() error {
-	// First retrieve all teams in the database to account for created/deleted teams
+	roundMu.Lock()
+	defer roundMu.Unlock()
+
+	started := time.Now()
+	engineMu.Lock()
 	ScoringRound += 1
+	round := ScoringRound
+	engineMu.Unlock()
+	if err := database.StartRound(db, round); err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while starting scoring round %d: %v", round, err), "ERROR")
+		return err
+	}
+	defer finishRound(round)
+
+	// Services scheduled to be disabled or enabled by now are, before the round is scored
+	applyServiceToggles()
+
+	// First retrieve all teams in the database to account for created/deleted teams
 	teams, err := database.GetAllTeams(db)
 	if err != nil {
 		logger.LogMessage(fmt.Sprintf("Error occured while getting teams from the database: %v", err), "ERROR")
 		return fmt.Errorf("failed to retrieve teams from the database: %w", err)
 	}
 
-	// Get the services for each team and score them
+	// Build the list of jobs for this round
+	var jobs []checkJob
 	for _, team := range teams {
 		// Retrieve all services associated with the team
 		services, err := database.GetTeamServices(db, team.ID)
@@ -138,149 +233,374 @@ func score() error {
 			return fmt.Errorf("failed to retrieve services for team %d: %w", team.ID, err)
 		}
 
-		// Loop over services
 		for _, service := range services {
 			if service.Disabled {
 				continue
 			}
+			jobs = append(jobs, checkJob{round: round, team: team, service: service})
+		}
+	}
 
-			// Locate the correct virtual machine
-			vmConfig, vmExists := yamlConfig.VirtualMachines[service.VMName]
-			if !vmExists {
-				logger.LogMessage(fmt.Sprintf("Error getting VM configuration for VM %s: configuration not found in YAML", service.VMName), "ERROR")
-				continue // don't attempt to score it
-			}
+	ctx, cancel := context.WithTimeout(context.Background(), roundDeadline())
+	defer cancel()
 
-			// Get the actual service name and it's configuration
-			// Convert VMname_ServiceName to ServiceName
-			parts := strings.SplitN(service.Name, "_", 2)
-			if len(parts) != 2 {
-				logger.LogMessage(fmt.Sprintf("Error getting service %s's service name: too many or too few parts, check formatting for extra underscores.", service.Name), "ERROR")
-				continue // don't attempt to score it
-			}
-			serviceName := parts[1]
-			serviceConfig, serviceExists := vmConfig.Services[serviceName]
-			if !serviceExists {
-				logger.LogMessage(fmt.Sprintf("Error getting service %s's configuration: configuration not found in YAML", service.Name), "ERROR")
-				continue // don't attempt to score it
-			}
+	summary := runJobs(ctx, jobs, MaxWorkers, scoreJob)
+	if summary.Skipped > 0 {
+		logger.LogMessage(fmt.Sprintf("Scoring round %d hit its deadline, %d checks were never started", round, summary.Skipped), "ERROR")
+	}
 
-			// Once the services configuration, virtual machine configuration, and team are all acquired we can score the service
-			award, status, err := serviceSelector(team, serviceName, serviceConfig, vmConfig)
-			if err != nil {
-				logger.LogMessage(fmt.Sprintf("Error occured when scoring service %s for team %d: %v", service.Name, team.ID, err), "ERROR")
-				continue // don't attempt to score it
-			}
+	summary.Round, summary.StartedAt = round, started
+	summary.DurationMS = time.Since(started).Milliseconds()
+	events.Publish(events.TypeRound, summary)
+
+	logger.LogMessage(fmt.Sprintf("Finished scoring round %d", round), "INFO")
+
+	return nil
+}
 
-			if err = database.UpdateServiceScore(db, team.ID, service.ID, award, status); err != nil {
-				logger.LogMessage(fmt.Sprintf("Error occured while updating the score for service %s for team %d: %v", service.Name, team.ID, err), "ERROR")
-				// at this point we already tried, whatever
+// runJobs fans the jobs out over a pool of at most workers goroutines, each running run on the
+// jobs it is handed, until they run out or ctx ends. It returns how many checks were up, partially
+// up and down, and how many jobs were never started.
+func runJobs(ctx context.Context, jobs []checkJob, workers int, run func(context.Context, checkJob) enum.CheckStatus) events.RoundEvent {
+	if workers <= 0 {
+		workers = 1
+	}
+	queue := make(chan checkJob)
+	var wg sync.WaitGroup
+	var tallyMu sync.Mutex
+	var summary events.RoundEvent
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				status := run(ctx, job)
+
+				tallyMu.Lock()
+				switch status {
+				case enum.StatusUp:
+					summary.Up++
+				case enum.StatusPartial:
+					summary.Partial++
+				case enum.StatusDown:
+					summary.Down++
+				}
+				tallyMu.Unlock()
 			}
+		}()
+	}
+
+	// Hand out jobs until they run out or the round deadline passes
+	for i, job := range jobs {
+		select {
+		case queue <- job:
+			continue
+		case <-ctx.Done():
+			summary.Skipped = len(jobs) - i
 		}
+		break
 	}
+	close(queue)
+	wg.Wait()
+	return summary
+}
 
-	logger.LogMessage(fmt.Sprintf("Finished scoring round %d", ScoringRound), "INFO")
+// finishRound marks a round as ended and applies the check history retention policy.
+func finishRound(round int) {
+	if err := database.EndRound(db, round); err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while ending scoring round %d: %v", round, err), "ERROR")
+	}
 
-	return nil
+	pruned, err := database.PruneCheckHistory(db, HistoryRetention)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while pruning check history: %v", err), "ERROR")
+	} else if pruned > 0 {
+		logger.LogMessage(fmt.Sprintf("Pruned %d service checks older than %d rounds", pruned, HistoryRetention), "INFO")
+	}
 }
 
-// Service selector selects the correct service and scores it, returning the amount of points
-// that need to be added to a team. Any new services need to be included here.
-func serviceSelector(scoredTeam enum.ScoringTeam, serviceName string, scoredService enum.Service, scoredVM enum.VirtualMachine) (int, bool, error) {
-	address, err := constructIPAddress(scoredVM.IPSchema, scoredTeam.ID)
+// scoreJob scores a single team's service and records the result in the database, returning the
+// service's status, or "" if it couldn't be scored. If the round's context expires before the check
+// finishes, the check is abandoned.
+func scoreJob(ctx context.Context, job checkJob) enum.CheckStatus {
+	team, service := job.team, job.service
+
+	// Locate the correct virtual machine
+	vmConfig, vmExists := yamlConfig.VirtualMachines[service.VMName]
+	if !vmExists {
+		logger.LogMessage(fmt.Sprintf("Error getting VM configuration for VM %s: configuration not found in YAML", service.VMName), "ERROR")
+		return "" // don't attempt to score it
+	}
+
+	// Get the actual service name and it's configuration
+	// Convert VMname_ServiceName to ServiceName
+	parts := strings.SplitN(service.Name, "_", 2)
+	if len(parts) != 2 {
+		logger.LogMessage(fmt.Sprintf("Error getting service %s's service name: too many or too few parts, check formatting for extra underscores.", service.Name), "ERROR")
+		return "" // don't attempt to score it
+	}
+	serviceName := parts[1]
+	serviceConfig, serviceExists := vmConfig.Services[serviceName]
+	if !serviceExists {
+		logger.LogMessage(fmt.Sprintf("Error getting service %s's configuration: configuration not found in YAML", service.Name), "ERROR")
+		return "" // don't attempt to score it
+	}
+
+	// Passwords the team has changed through password change requests replace the configured ones
+	credentials, err := database.GetCredentials(db, team.ID, service.ID)
 	if err != nil {
-		return 0, false, fmt.Errorf("failed to construct IP address: %w", err)
+		logger.LogMessage(fmt.Sprintf("Error getting changed credentials for service %s for team %d: %v", service.Name, team.ID, err), "ERROR")
+		return "" // don't attempt to score it
+	}
+
+	// Once the services configuration, virtual machine configuration, and team are all acquired we can score the service.
+	outcome := runCheck(ctx, func(ctx context.Context) (enum.CheckResult, error) {
+		return serviceSelector(ctx, team, service.VMName, serviceName, serviceConfig, vmConfig, credentials)
+	})
+
+	if outcome.err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured when scoring service %s for team %d: %v", service.Name, team.ID, outcome.err), "ERROR")
+		return "" // don't attempt to score it
+	}
+
+	result := outcome.result
+	switch result.Status {
+	case enum.StatusDown:
+		logger.LogMessage(fmt.Sprintf("Service %s for team %d is down: %s", service.Name, team.ID, result.Reason), "INFO")
+	case enum.StatusPartial:
+		logger.LogMessage(fmt.Sprintf("Service %s for team %d is partially up: %s", service.Name, team.ID, result.Reason), "INFO")
 	}
 
-	scoringFunc, ok := services.ScoringDispatch[serviceName]
+	previous, err := database.UpdateServiceScore(db, job.round, team.ID, service.ID, result)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while updating the score for service %s for team %d: %v", service.Name, team.ID, err), "ERROR")
+		// at this point we already tried, whatever
+		return result.Status
+	}
+
+	// Let anyone watching the scoreboard know
+	event := events.CheckEvent{
+		Round:     job.round,
+		TeamID:    team.ID,
+		Service:   service.Name,
+		Status:    string(result.Status),
+		Previous:  string(previous),
+		Points:    result.Points,
+		LatencyMS: result.Latency.Milliseconds(),
+	}
+	events.Publish(events.TypeCheck, event)
+	if previous != result.Status {
+		events.Publish(events.TypeTransition, event)
+	}
+
+	if result.Status == enum.StatusDown {
+		checkSLA(job, serviceConfig)
+	}
+
+	return result.Status
+}
+
+// runCheck runs a check in its own goroutine so the round deadline can cut off one that ignores its
+// context. A check cut off that way is down.
+func runCheck(ctx context.Context, check func(context.Context) (enum.CheckResult, error)) checkOutcome {
+	done := make(chan checkOutcome, 1)
+	go func() {
+		result, err := check(ctx)
+		done <- checkOutcome{result: result, err: err}
+	}()
+
+	select {
+	case outcome := <-done:
+		return outcome
+	case <-ctx.Done():
+		return checkOutcome{result: services.Fail(fmt.Errorf("check did not finish before the round deadline"))}
+	}
+}
+
+// slaRule returns the SLA rule that applies to a service: its own if it has one, otherwise the
+// global rule. The rule is false if SLA penalties are off for the service.
+func slaRule(service enum.Service) (enum.SLARule, bool) {
+	rule := yamlConfig.Scoring.SLA
+	if service.SLA != nil {
+		rule = service.SLA
+	}
+	if rule == nil || rule.Threshold <= 0 {
+		return enum.SLARule{}, false
+	}
+	return *rule, true
+}
+
+// checkSLA records an SLA violation if a failed check has brought a service to its rule's threshold
+// of consecutive failures.
+func checkSLA(job checkJob, serviceConfig enum.Service) {
+	rule, ok := slaRule(serviceConfig)
 	if !ok {
-		// unknown service
-		return 0, false, fmt.Errorf("unknown service %s", serviceName)
+		return
+	}
+
+	violation, violated, err := database.CheckSLA(db, job.team.ID, job.service.ID, rule)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while checking the SLA of service %s for team %d: %v", job.service.Name, job.team.ID, err), "ERROR")
+		return
+	}
+	if !violated {
+		return
 	}
-	// Now call the scoring function
-	return scoringFunc(scoredService, address)
 
+	logger.LogMessage(fmt.Sprintf("Service %s for team %d violated its SLA, down from round %d to %d, -%d points",
+		job.service.Name, job.team.ID, violation.StartRound, violation.EndRound, violation.Penalty), "INFO")
+	events.Publish(events.TypeSLA, events.SLAEvent{
+		TeamID:     job.team.ID,
+		Service:    job.service.Name,
+		StartRound: violation.StartRound,
+		EndRound:   violation.EndRound,
+		Penalty:    violation.Penalty,
+	})
 }
 
-// Utility function that builds the IP address from the base IP, team ID, and fourth octet.
-//
-// Given a schema "192.168.T.5"
-//
-// Given a team ID "1"
-//
-// Would convert an ip schema "192.168.T.5" --> 192.168.1.5 if the team ID
-func constructIPAddress(schema string, teamID int) (string, error) {
-	// Split the base IP into quartets and check
-	ipParts := strings.Split(schema, ".")
-	if len(ipParts) != 4 {
-		return "", fmt.Errorf("invalid IP schema format: %s", schema)
+// roundDeadline returns how long a single scoring round is allowed to take.
+func roundDeadline() time.Duration {
+	if RoundDeadline > 0 {
+		return time.Second * time.Duration(RoundDeadline)
+	}
+	return time.Second * time.Duration(RefreshTime)
+}
+
+// Service selector selects the correct checker from the services registry and runs it, returning
+// the result of the check. An error is only returned when the check could not be run at all.
+func serviceSelector(ctx context.Context, scoredTeam enum.ScoringTeam, vmName, serviceName string, scoredService enum.Service, scoredVM enum.VirtualMachine, credentials map[string]string) (enum.CheckResult, error) {
+	host, err := address.ForTeam(scoredVM, scoredTeam.ID, scoredTeam.Name)
+	if err != nil {
+		return enum.CheckResult{}, fmt.Errorf("failed to construct IP address: %w", err)
 	}
 
-	finalIp := strings.Replace(schema, "t", fmt.Sprintf("%d", teamID), 1)
-	finalIp = strings.Replace(finalIp, "T", fmt.Sprintf("%d", teamID), 1)
+	target := services.CheckTarget{
+		TeamID:      scoredTeam.ID,
+		VMName:      vmName,
+		Address:     host,
+		Service:     resolveService(scoredService, scoredTeam),
+		Credentials: credentials,
+	}
 
-	return finalIp, nil
+	// Now run the checker
+	return services.RunCheck(ctx, serviceName, target)
+}
+
+// resolveService applies a team's overrides to a service's configuration. Overrides can be keyed
+// by team name or ID; if both are given, the ID's take precedence.
+func resolveService(service enum.Service, team enum.ScoringTeam) enum.Service {
+	for _, key := range []string{team.Name, strconv.Itoa(team.ID)} {
+		override, ok := service.Teams[key]
+		if !ok {
+			continue
+		}
+		if override.Port != 0 {
+			service.Port = override.Port
+		}
+		if override.Award != 0 {
+			service.Award = override.Award
+		}
+		// A user or query file replaces however the service logged in before
+		if override.User != "" {
+			service.User, service.Password, service.QFile = override.User, override.Password, ""
+		} else if override.QFile != "" {
+			service.User, service.Password, service.QFile = "", "", override.QFile
+		}
+		if override.QDir != "" {
+			service.QDir = override.QDir
+		}
+	}
+	return service
+}
+
+// ServiceUsernames returns the usernames a team's service, named "<box>_<service>" as in the
+// database, logs in with, so password change requests can be checked against them.
+func ServiceUsernames(team enum.ScoringTeam, fullServiceName string) ([]string, error) {
+	vmName, serviceName, ok := strings.Cut(fullServiceName, "_")
+	cfg := currentConfig()
+	if !ok || cfg == nil {
+		return nil, fmt.Errorf("unknown service %s", fullServiceName)
+	}
+	service, exists := cfg.VirtualMachines[vmName].Services[serviceName]
+	if !exists {
+		return nil, fmt.Errorf("unknown service %s", fullServiceName)
+	}
+	return services.ServiceUsernames(resolveService(service, team))
 }
 
 // // // START ENGINE CONTROLS SECTION
 
 // Enable the scoring engine by continuing the loop that checks services and scores them.
 // Scores immediately upon startup.
-func StartEngine() {
+func StartEngine() error {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	if ScoringKilled {
+		return fmt.Errorf("engine has been stopped and cannot be restarted")
+	}
 	if ScoringEnabled {
-		logging.ConsoleLogError("Engine is already running.")
-		return
+		return fmt.Errorf("engine is already running")
 	}
 	ScoringEnabled = true
 	ScoringPaused = false
-	logging.ConsoleLogSuccess("Engine started.")
+	return nil
 }
 
 // Disable the scoring engine by stopping the loop that checks services and scores them.
 // Exits the game, you cannot recontinue the game after stopping the scoring engine.
-func StopEngine() {
+func StopEngine() error {
+	engineMu.Lock()
+	defer engineMu.Unlock()
 	if !ScoringEnabled {
-		logging.ConsoleLogError("Engine is not running.")
-		return
+		return fmt.Errorf("engine is not running")
 	}
 	ScoringEnabled = false
 	ScoringPaused = false
 	ScoringKilled = true
-	logging.ConsoleLogSuccess("Engine stopped.")
+	NextRoundAt = time.Time{}
+	return nil
 }
 
 // Pauses the scoring engine by temporarily stopping the loop that checks services and scores them.
 // The game can be resumed by resuming the engine afterwards.
-func PauseEngine() {
+func PauseEngine() error {
+	engineMu.Lock()
+	defer engineMu.Unlock()
 	if !ScoringEnabled {
-		logging.ConsoleLogError("Engine is not running.")
-		return
+		return fmt.Errorf("engine is not running")
 	}
 	if ScoringPaused {
-		logging.ConsoleLogError("Engine is already paused.")
-		return
+		return fmt.Errorf("engine is already paused")
 	}
 	ScoringPaused = true
-	logging.ConsoleLogSuccess("Engine paused.")
+	NextRoundAt = time.Time{}
+	return nil
 }
 
 // Resumes the scoring engine by resuming in the loop that checks services and scores them.
 // This does not start scoring after stopping.
-func ResumeEngine() {
+func ResumeEngine() error {
+	engineMu.Lock()
+	defer engineMu.Unlock()
 	if !ScoringEnabled {
-		logging.ConsoleLogError("Engine is not running.")
-		return
+		return fmt.Errorf("engine is not running")
 	}
 	if !ScoringPaused {
-		logging.ConsoleLogError("Engine is not paused.")
-		return
+		return fmt.Errorf("engine is not paused")
 	}
 	ScoringPaused = false
-	logging.ConsoleLogSuccess("Engine resumed.")
+	return nil
 }
 
 // Returns "paused", "running", or "stopped" depending on current engine state.
 func GetEngineState() string {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	return engineState()
+}
+
+// engineState is GetEngineState for callers already holding engineMu.
+func engineState() string {
 	if ScoringEnabled {
 		if ScoringPaused {
 			return "paused"
@@ -289,3 +609,25 @@ func GetEngineState() string {
 	}
 	return "stopped"
 }
+
+// EngineStatus is a snapshot of the scoring engine.
+type EngineStatus struct {
+	State         string        // "running", "paused" or "stopped"
+	Round         int           // The most recent round to have started
+	NextRoundAt   time.Time     // When the next round starts, zero while not running
+	LastRoundTime time.Duration // How long the last round took to score
+	RefreshTime   time.Duration // How often rounds start
+}
+
+// GetEngineStatus returns the engine's current state, round and timing.
+func GetEngineStatus() EngineStatus {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	return EngineStatus{
+		State:         engineState(),
+		Round:         ScoringRound,
+		NextRoundAt:   NextRoundAt,
+		LastRoundTime: LastRoundTime,
+		RefreshTime:   time.Second * time.Duration(RefreshTime),
+	}
+}
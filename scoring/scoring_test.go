@@ -0,0 +1,319 @@
+package scoring
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/services"
+)
+
+func TestEngineControls(t *testing.T) {
+	// Controls that don't apply to the current state are refused
+	if err := PauseEngine(); err == nil {
+		t.Fatal("pausing a stopped engine should fail")
+	}
+	if err := StartEngine(); err != nil {
+		t.Fatalf("starting the engine failed: %v", err)
+	}
+	if err := StartEngine(); err == nil {
+		t.Fatal("starting a running engine should fail")
+	}
+	if err := ResumeEngine(); err == nil {
+		t.Fatal("resuming a running engine should fail")
+	}
+
+	if err := PauseEngine(); err != nil {
+		t.Fatalf("pausing the engine failed: %v", err)
+	}
+	if status := GetEngineStatus(); status.State != "paused" || !status.NextRoundAt.IsZero() {
+		t.Fatalf("expected a paused engine with no next round, got %+v", status)
+	}
+	if err := ResumeEngine(); err != nil {
+		t.Fatalf("resuming the engine failed: %v", err)
+	}
+	if state := GetEngineState(); state != "running" {
+		t.Fatalf("expected the engine to be running, got %s", state)
+	}
+
+	// A stopped engine can't be restarted
+	if err := StopEngine(); err != nil {
+		t.Fatalf("stopping the engine failed: %v", err)
+	}
+	if err := StartEngine(); err == nil {
+		t.Fatal("restarting a stopped engine should fail")
+	}
+	if state := GetEngineState(); state != "stopped" {
+		t.Fatalf("expected the engine to be stopped, got %s", state)
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	// A check that ignores its context and never finishes on its own
+	release := make(chan struct{})
+	defer close(release)
+	stuck := func(ctx context.Context) (enum.CheckResult, error) {
+		<-release
+		return services.Pass(services.CheckTarget{}, "too late"), nil
+	}
+	quick := func(ctx context.Context) (enum.CheckResult, error) {
+		time.Sleep(5 * time.Millisecond)
+		return services.Pass(services.CheckTarget{}, "up"), nil
+	}
+
+	var active, most atomic.Int32
+	var stuckStatus atomic.Value
+	run := func(ctx context.Context, job checkJob) enum.CheckStatus {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+
+		check := quick
+		if job.team.ID == 1 {
+			check = stuck
+		}
+		outcome := runCheck(ctx, check)
+		if job.team.ID == 1 {
+			stuckStatus.Store(outcome.result.Status)
+		}
+		return outcome.result.Status
+	}
+
+	jobs := make([]checkJob, 6)
+	for i := range jobs {
+		jobs[i] = checkJob{team: enum.ScoringTeam{ID: i + 1}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	summary := runJobs(ctx, jobs, 2, run)
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected the round to end at its deadline, it took %s", elapsed)
+	}
+	if most.Load() > 2 {
+		t.Fatalf("expected at most 2 checks at a time, saw %d", most.Load())
+	}
+	if stuckStatus.Load() != enum.StatusDown {
+		t.Fatalf("expected the check cut off by the deadline to be down, got %v", stuckStatus.Load())
+	}
+	if summary.Up != 5 || summary.Down != 1 || summary.Skipped != 0 {
+		t.Fatalf("expected 5 up and 1 down, got %+v", summary)
+	}
+
+	// Once the deadline passes, jobs that haven't started are skipped or cut off straight away
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	summary = runJobs(ctx, []checkJob{{team: enum.ScoringTeam{ID: 1}}, {team: enum.ScoringTeam{ID: 1}}, {team: enum.ScoringTeam{ID: 1}}}, 1, run)
+	if summary.Up != 0 || summary.Down < 1 || summary.Down+summary.Skipped != 3 {
+		t.Fatalf("expected every check to be down or skipped, got %+v", summary)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	defer setConfig(&enum.YamlConfig{})
+
+	setConfig(&enum.YamlConfig{Scoring: enum.ScoringConfig{HistoryRetention: 30}})
+	if HistoryRetention != 30 {
+		t.Fatalf("expected 30 rounds of history to be kept, got %d", HistoryRetention)
+	}
+
+	// Leaving it out of the configuration goes back to keeping everything
+	setConfig(&enum.YamlConfig{})
+	if HistoryRetention != 0 {
+		t.Fatalf("expected the whole history to be kept, got %d", HistoryRetention)
+	}
+}
+
+func TestSLARule(t *testing.T) {
+	global := &enum.SLARule{Threshold: 5, Penalty: 50}
+	yamlConfig = &enum.YamlConfig{Scoring: enum.ScoringConfig{SLA: global}}
+	defer func() { yamlConfig = nil }()
+
+	// Services fall back to the global rule
+	if rule, ok := slaRule(enum.Service{Port: 22}); !ok || rule != *global {
+		t.Fatalf("expected the global rule, got %+v, %v", rule, ok)
+	}
+
+	// A service's own rule takes precedence, and a threshold of 0 turns SLA penalties off for it
+	if rule, ok := slaRule(enum.Service{Port: 80, SLA: &enum.SLARule{Threshold: 3, Penalty: 25}}); !ok || rule.Threshold != 3 || rule.Penalty != 25 {
+		t.Fatalf("expected the service's rule, got %+v, %v", rule, ok)
+	}
+	if _, ok := slaRule(enum.Service{Port: 53, SLA: &enum.SLARule{}}); ok {
+		t.Fatal("expected a zero threshold to turn SLA penalties off")
+	}
+
+	// Without a global rule only services with their own are penalized
+	yamlConfig.Scoring.SLA = nil
+	if _, ok := slaRule(enum.Service{Port: 22}); ok {
+		t.Fatal("expected no rule without a global or service rule")
+	}
+}
+
+func TestResolveService(t *testing.T) {
+	service := enum.Service{
+		Port:  22,
+		Award: 10,
+		QFile: "users.txt",
+		Teams: map[string]enum.ServiceOverride{
+			"team2": {Port: 2222, User: "henry", Password: "pass"},
+			"2":     {Award: 5},
+			"3":     {QFile: "team3_users.txt", QDir: "team3_files"},
+		},
+	}
+
+	// Teams without overrides get the service as configured
+	if got := resolveService(service, enum.ScoringTeam{ID: 1, Name: "team1"}); got.Port != 22 || got.Award != 10 || got.QFile != "users.txt" {
+		t.Fatalf("expected the service unchanged, got %+v", got)
+	}
+
+	// Overrides by name and by ID are combined
+	got := resolveService(service, enum.ScoringTeam{ID: 2, Name: "team2"})
+	if got.Port != 2222 || got.Award != 5 || got.User != "henry" || got.Password != "pass" || got.QFile != "" {
+		t.Fatalf("expected team 2's overrides, got %+v", got)
+	}
+
+	// A query file replaces the service's login
+	got = resolveService(enum.Service{Port: 21, User: "ftp", Password: "ftp", Teams: service.Teams}, enum.ScoringTeam{ID: 3, Name: "team3"})
+	if got.User != "" || got.QFile != "team3_users.txt" || got.QDir != "team3_files" {
+		t.Fatalf("expected team 3's query file and directory, got %+v", got)
+	}
+}
+
+func TestToggleServices(t *testing.T) {
+	if got := DescribeToggle(enum.ServiceToggle{Service: "web_http", Disabled: true, TeamID: 3, Reason: "maintenance"}); got != "web_http disabled for team 3: maintenance" {
+		t.Fatalf("unexpected description %q", got)
+	}
+	if got := DescribeToggle(enum.ServiceToggle{Service: "web_http"}); got != "web_http enabled for every team" {
+		t.Fatalf("unexpected description %q", got)
+	}
+
+	// Only disables can end, and only after they start; these are refused before the database is used
+	now := time.Now()
+	for _, c := range []struct {
+		toggle enum.ServiceToggle
+		until  time.Time
+	}{
+		{enum.ServiceToggle{Disabled: false}, now.Add(time.Hour)},
+		{enum.ServiceToggle{Disabled: true, RunAt: now.Add(2 * time.Hour)}, now.Add(time.Hour)},
+		{enum.ServiceToggle{Disabled: true}, now.Add(-time.Hour)},
+	} {
+		if _, err := ToggleServices(nil, "web", c.toggle, c.until); !errors.Is(err, ErrInvalidToggle) {
+			t.Fatalf("expected %+v until %v to be refused, got %v", c.toggle, c.until, err)
+		}
+	}
+}
+
+func TestReloadRereadsServiceFiles(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	os.WriteFile(page, []byte("old page"), 0644)
+	config := fmt.Sprintf(`virtual-machines:
+  web:
+    ip-schema: 192.168.T.5
+    services:
+      webcontent:
+        port: 80
+        query_file: %s
+official-virtual-machines:
+  router:
+    ip: 10.20.0.1
+  scorer:
+    ip: 10.20.0.5
+  dns:
+    ip: 10.20.0.10
+teams:
+  team1:
+    id: 1
+    name: team1
+    password: team1
+    color: "#02c21f"
+`, page)
+	os.WriteFile(filepath.Join(dir, "main.yaml"), []byte(config), 0644)
+
+	ConfigFolder, ConfigFile = dir, filepath.Join(dir, "main.yaml")
+	defer setConfig(&enum.YamlConfig{})
+	setConfig(&enum.YamlConfig{})
+	if content, err := services.LoadWebFiles(page); err != nil || string(content) != "old page" {
+		t.Fatalf("expected the page to load, got %q, %v", content, err)
+	}
+
+	// Fixing the file and reloading picks up the fix, even though there's no database to resync
+	os.WriteFile(page, []byte("new page"), 0644)
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := Reload(db); err == nil || !strings.Contains(err.Error(), "resyncing the database failed") {
+		t.Fatalf("expected the configuration to reload and the resync to fail, got %v", err)
+	}
+	if content, err := services.LoadWebFiles(page); err != nil || string(content) != "new page" {
+		t.Fatalf("expected the reload to re-read the page, got %q, %v", content, err)
+	}
+}
+
+func TestDeleteConfiguredTeam(t *testing.T) {
+	defer setConfig(&enum.YamlConfig{})
+	setConfig(&enum.YamlConfig{Teams: map[string]enum.Team{"team1": {ID: 1, Name: "team1"}}})
+
+	// A configured team would come back on the next resync, so it isn't deleted
+	if !IsConfiguredTeam(1) || IsConfiguredTeam(2) {
+		t.Fatal("expected only team 1 to be configured")
+	}
+	if err := DeleteTeam(nil, 1); !errors.Is(err, ErrTeamConfigured) {
+		t.Fatalf("expected deleting a configured team to be refused, got %v", err)
+	}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	current := &enum.YamlConfig{
+		VirtualMachines: map[string]enum.VirtualMachine{
+			"web": {IPSchema: "10.0.T.5", Services: map[string]enum.Service{"http": {Port: 80}, "ssh": {Port: 22}}},
+			"db":  {IPSchema: "10.0.T.6", Services: map[string]enum.Service{"mysql": {Port: 3306}}},
+		},
+		Teams: map[string]enum.Team{"Red": {ID: 1, Name: "Red", Color: "#ff0000"}, "Blue": {ID: 2, Name: "Blue", Color: "#0000ff"}},
+	}
+	next := &enum.YamlConfig{
+		VirtualMachines: map[string]enum.VirtualMachine{
+			"web":  {IPSchema: "10.1.T.5", Services: map[string]enum.Service{"http": {Port: 8080}, "https": {Port: 443}}},
+			"mail": {IPSchema: "10.0.T.7", Services: map[string]enum.Service{"smtp": {Port: 25}}},
+		},
+		Teams:   map[string]enum.Team{"Red": {ID: 1, Name: "Red", Color: "#aa0000"}, "Green": {ID: 3, Name: "Green"}},
+		Scoring: enum.ScoringConfig{RefreshTime: 30},
+	}
+
+	changes := diffConfigs(current, next)
+	expected := map[string][2][]string{
+		"boxes added":      {changes.BoxesAdded, {"mail"}},
+		"boxes removed":    {changes.BoxesRemoved, {"db"}},
+		"boxes changed":    {changes.BoxesChanged, {"web"}},
+		"services added":   {changes.ServicesAdded, {"mail_smtp", "web_https"}},
+		"services removed": {changes.ServicesRemoved, {"db_mysql", "web_ssh"}},
+		"services changed": {changes.ServicesChanged, {"web_http"}},
+		"teams added":      {changes.TeamsAdded, {"Green"}},
+		"teams removed":    {changes.TeamsRemoved, {"Blue"}},
+		"teams changed":    {changes.TeamsChanged, {"Red"}},
+	}
+	for what, pair := range expected {
+		if strings.Join(pair[0], ",") != strings.Join(pair[1], ",") {
+			t.Errorf("%s: expected %v, got %v", what, pair[1], pair[0])
+		}
+	}
+	if !changes.ScoringChanged || changes.OfficialChanged {
+		t.Errorf("expected only the scoring settings to change, got %+v", changes)
+	}
+
+	if got := diffConfigs(current, current).String(); got != "no changes" {
+		t.Errorf("expected no changes, got %q", got)
+	}
+}
@@ -0,0 +1,184 @@
+package scoring
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+)
+
+// Keeps resyncs and team creation from the CLI and the API from interleaving
+var teamsMu sync.Mutex
+
+// ResyncSummary is what a resync changed to bring the database in line with the configuration.
+type ResyncSummary struct {
+	TeamsCreated      int      `json:"teams_created"`
+	TeamsUpdated      int      `json:"teams_updated"`      // Teams whose name or color changed
+	PasswordsChanged  int      `json:"passwords_changed"`  // Teams whose configured password changed, and was applied
+	ServicesAttached  int      `json:"services_attached"`  // Services added to teams, for new teams or new services
+	ServicesRetired   int      `json:"services_retired"`   // Services no longer configured, which stop being scored
+	ServicesRestored  int      `json:"services_restored"`  // Retired services that are configured again
+	UnconfiguredTeams []string `json:"unconfigured_teams"` // Teams in the database but not the configuration, such as ones made with team create
+}
+
+func (s ResyncSummary) String() string {
+	summary := fmt.Sprintf("%d teams created, %d updated, %d passwords changed, %d services attached, %d retired, %d restored",
+		s.TeamsCreated, s.TeamsUpdated, s.PasswordsChanged, s.ServicesAttached, s.ServicesRetired, s.ServicesRestored)
+	if len(s.UnconfiguredTeams) > 0 {
+		summary += fmt.Sprintf(" (not in the configuration: %s)", strings.Join(s.UnconfiguredTeams, ", "))
+	}
+	return summary
+}
+
+// configuredServices returns the full name of every service in the configuration.
+func configuredServices(cfg *enum.YamlConfig) []string {
+	names := []string{}
+	for vmName, vm := range cfg.VirtualMachines {
+		for serviceName := range vm.Services {
+			names = append(names, fmt.Sprintf("%s_%s", vmName, serviceName))
+		}
+	}
+	return names
+}
+
+// attachServices maps every configured service to a team, returning how many it didn't have yet.
+func attachServices(db *sql.DB, cfg *enum.YamlConfig, teamID int) (int, error) {
+	attached := 0
+	for vmName, vm := range cfg.VirtualMachines {
+		added, err := addServicesToTeam(db, teamID, vmName, vm)
+		if err != nil {
+			return attached, fmt.Errorf("failed to add services from box %s to team %d: %w", vmName, teamID, err)
+		}
+		attached += added
+	}
+	return attached, nil
+}
+
+// ErrTeamConfigured is returned when deleting a team that is still in the configuration, since the
+// next resync, reload or restart would bring it back.
+var ErrTeamConfigured = errors.New("the team is in the configuration; remove it from main.yaml first, or the next resync brings it back")
+
+// IsConfiguredTeam reports whether the team with the given ID is in the running configuration.
+func IsConfiguredTeam(teamID int) bool {
+	cfg := currentConfig()
+	if cfg == nil {
+		return false
+	}
+	for _, team := range cfg.Teams {
+		if team.ID == teamID {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteTeam removes a team that isn't in the configuration, along with its services and scores.
+// Configured teams are refused with ErrTeamConfigured.
+func DeleteTeam(db *sql.DB, teamID int) error {
+	teamsMu.Lock()
+	defer teamsMu.Unlock()
+	if currentConfig() == nil {
+		return errors.New("the scoring engine has not loaded the configuration yet")
+	}
+	if IsConfiguredTeam(teamID) {
+		return ErrTeamConfigured
+	}
+	if err := database.DeleteTeam(db, teamID); err != nil {
+		return err
+	}
+	logger.LogMessage(fmt.Sprintf("Team %d deleted.", teamID), "INFO")
+	return nil
+}
+
+// CreateTeam adds a team that isn't in the configuration, such as a late entrant, and gives it
+// every configured service. It returns the new team's ID.
+func CreateTeam(db *sql.DB, team enum.Team) (int, error) {
+	teamsMu.Lock()
+	defer teamsMu.Unlock()
+	cfg := currentConfig()
+	if cfg == nil {
+		return 0, errors.New("the scoring engine has not loaded the configuration yet")
+	}
+
+	id, err := database.CreateTeam(db, team)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := attachServices(db, cfg, id); err != nil {
+		return id, err
+	}
+	logger.LogMessage(fmt.Sprintf("Team %s created with ID %d.", team.Name, id), "INFO")
+	return id, nil
+}
+
+// Resync reconciles the database with the configuration: configured teams are created or get
+// their configured name and color, and their configured password when it changes, every team
+// gets every configured service, and services that are no longer configured stop being scored.
+// Nothing is deleted, so no scores are lost.
+func Resync(db *sql.DB) (ResyncSummary, error) {
+	teamsMu.Lock()
+	defer teamsMu.Unlock()
+	summary := ResyncSummary{UnconfiguredTeams: []string{}}
+	cfg := currentConfig()
+	if cfg == nil {
+		return summary, errors.New("the scoring engine has not loaded the configuration yet")
+	}
+
+	// Configured teams, in ID order so new teams are created in a predictable order
+	configured := make([]enum.Team, 0, len(cfg.Teams))
+	for _, team := range cfg.Teams {
+		configured = append(configured, team)
+	}
+	sort.Slice(configured, func(i, j int) bool { return configured[i].ID < configured[j].ID })
+
+	configuredIDs := make(map[int]bool)
+	for _, team := range configured {
+		configuredIDs[team.ID] = true
+		change, err := database.EnsureTeam(db, team)
+		if err != nil {
+			return summary, err
+		}
+		switch change {
+		case database.TeamCreated:
+			summary.TeamsCreated++
+		case database.TeamUpdated:
+			summary.TeamsUpdated++
+		}
+		changed, err := database.SyncTeamPassword(db, team)
+		if err != nil {
+			return summary, err
+		}
+		if changed {
+			summary.PasswordsChanged++
+		}
+	}
+
+	teams, err := database.GetAllTeams(db)
+	if err != nil {
+		return summary, fmt.Errorf("failed to retrieve teams from the database: %w", err)
+	}
+	for _, team := range teams {
+		if !configuredIDs[team.ID] {
+			summary.UnconfiguredTeams = append(summary.UnconfiguredTeams, team.Name)
+		}
+		attached, err := attachServices(db, cfg, team.ID)
+		summary.ServicesAttached += attached
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	retired, restored, err := database.SyncServices(db, configuredServices(cfg))
+	if err != nil {
+		return summary, fmt.Errorf("failed to retire unconfigured services: %w", err)
+	}
+	summary.ServicesRetired, summary.ServicesRestored = int(retired), int(restored)
+
+	logger.LogMessage(fmt.Sprintf("Resynced the database with the configuration: %s.", summary), "INFO")
+	return summary, nil
+}
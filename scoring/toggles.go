@@ -0,0 +1,87 @@
+package scoring
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LTSEC/NEST/database"
+	"github.com/LTSEC/NEST/enum"
+	"github.com/LTSEC/NEST/logging"
+)
+
+// ErrInvalidToggle is returned by ToggleServices for a toggle that makes no sense.
+var ErrInvalidToggle = errors.New("invalid toggle")
+
+// DescribeToggle says what a toggle does, such as "web_http disabled for team 3".
+func DescribeToggle(t enum.ServiceToggle) string {
+	action := "enabled"
+	if t.Disabled {
+		action = "disabled"
+	}
+	target := "every team"
+	if t.TeamID != 0 {
+		target = fmt.Sprintf("team %d", t.TeamID)
+	}
+	description := fmt.Sprintf("%s %s for %s", t.Service, action, target)
+	if t.Reason != "" {
+		description += ": " + t.Reason
+	}
+	return description
+}
+
+// ToggleServices disables or enables the service named target, or every service on the box
+// named target, as toggle describes. A disable with a non-zero until is followed by an enable at
+// that time, for example to take a box out of scoring for a maintenance inject. It returns the
+// toggles it made, ErrInvalidToggle if until is out of place, or database.ErrNotFound if there
+// is no such service or box, or the toggle is for a team that doesn't have it.
+func ToggleServices(db *sql.DB, target string, toggle enum.ServiceToggle, until time.Time) ([]enum.ServiceToggle, error) {
+	if !until.IsZero() {
+		if !toggle.Disabled {
+			return nil, fmt.Errorf("%w: only a disable can be given a time to end", ErrInvalidToggle)
+		}
+		if !until.After(toggle.RunAt) || !until.After(time.Now()) {
+			return nil, fmt.Errorf("%w: a disable must end after it starts", ErrInvalidToggle)
+		}
+	}
+
+	services, err := database.FindServices(db, target)
+	if err != nil {
+		return nil, err
+	}
+
+	toggles := []enum.ServiceToggle{}
+	for _, service := range services {
+		t := toggle
+		t.ServiceID, t.Service = service.ID, service.Name
+		created, err := database.CreateServiceToggle(db, t)
+		if err != nil {
+			return toggles, err
+		}
+		toggles = append(toggles, created)
+
+		if !until.IsZero() {
+			t.Disabled, t.RunAt = false, until
+			created, err := database.CreateServiceToggle(db, t)
+			if err != nil {
+				return toggles, err
+			}
+			toggles = append(toggles, created)
+		}
+	}
+	return toggles, nil
+}
+
+// applyServiceToggles applies the scheduled toggles that are due, before a round is scored.
+func applyServiceToggles() {
+	toggles, err := database.ApplyServiceToggles(db)
+	if err != nil {
+		logger.LogMessage(fmt.Sprintf("Error occured while applying scheduled service toggles: %v", err), "ERROR")
+		return
+	}
+	for _, t := range toggles {
+		logging.AuditLog(fmt.Sprintf("scheduled toggle %d applied: %s", t.ID, DescribeToggle(t)))
+		logger.LogMessage(fmt.Sprintf("Applied scheduled toggle %d: %s.", t.ID, DescribeToggle(t)), "INFO")
+	}
+}
@@ -2,73 +2,60 @@ package services
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/LTSEC/NEST/address"
 	"github.com/LTSEC/NEST/enum"
 	"github.com/miekg/dns"
 )
 
-// getTeamNumberFromAddress extracts the team number from the given resolver IP address.
-// For external scoring (mode == "external"), it returns the last octet.
-// For internal scoring (mode == "internal"), it returns the third octet.
-func getTeamNumberFromAddress(address, mode string) (string, error) {
-	// In case the address includes a port (e.g. "10.20.1.1:53"), extract the host.
-	host := address
-	if strings.Contains(address, ":") {
-		var err error
-		host, _, err = net.SplitHostPort(address)
-		if err != nil {
-			// If splitting fails, fall back to the full address.
-			host = address
-		}
-	}
-	parts := strings.Split(host, ".")
-	if len(parts) != 4 {
-		return "", fmt.Errorf("invalid IPv4 address: %s", host)
-	}
-
-	switch mode {
-	case "external":
-		// Last octet
-		return parts[2], nil
-	case "internal":
-		// Third octet (0-indexed)
-		return parts[2], nil
-	default:
-		return "", fmt.Errorf("unknown mode: %s", mode)
-	}
-}
-
 // replaceTeamToken replaces all occurrences of "<t>" in the given string with team.
 func replaceTeamToken(s, team string) string {
 	return strings.ReplaceAll(s, "<t>", team)
 }
 
-// reverseIP returns the reverse lookup domain for an IPv4 address.
-// For example, "10.20.1.1" becomes "1.1.20.10.in-addr.arpa."
+// reverseIP returns the reverse lookup domain for an IPv4 or IPv6 address.
+// For example, "10.20.1.1" becomes "1.1.20.10.in-addr.arpa.", and IPv6 addresses become
+// nibbles under "ip6.arpa."
 func reverseIP(ip string) (string, error) {
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return "", fmt.Errorf("invalid IPv4 address: %s", ip)
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid IP address: %s", ip)
 	}
-	// Reverse the octets.
-	return fmt.Sprintf("%s.%s.%s.%s.in-addr.arpa.", parts[3], parts[2], parts[1], parts[0]), nil
+	return dns.ReverseAddr(ip)
+}
+
+// addressType returns the record type that holds ip: A for IPv4 and AAAA for IPv6.
+func addressType(ip string) (uint16, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return 0, fmt.Errorf("invalid IP address: %s", ip)
+	}
+	if parsed.To4() != nil {
+		return dns.TypeA, nil
+	}
+	return dns.TypeAAAA, nil
+}
+
+// dnsServer returns the address DNS queries are sent to for a server's IP or hostname.
+func dnsServer(server string) string {
+	return net.JoinHostPort(server, "53")
 }
 
 // queryDNS sends a DNS query (of type qtype) for the given domain to the resolver.
-func queryDNS(resolver, domain string, qtype uint16) ([]string, error) {
+func queryDNS(ctx context.Context, resolver, domain string, qtype uint16) ([]string, error) {
 	client := dns.Client{
-		Timeout: dns_timeout * time.Second,
+		Timeout: dns_timeout * time.Millisecond,
 	}
 	msg := dns.Msg{}
 	// Ensure the domain is fully qualified.
 	msg.SetQuestion(dns.Fqdn(domain), qtype)
 
-	response, _, err := client.Exchange(&msg, resolver)
+	response, _, err := client.ExchangeContext(ctx, &msg, resolver)
 	if err != nil {
 		return nil, fmt.Errorf("DNS query error: %v", err)
 	}
@@ -81,6 +68,8 @@ func queryDNS(resolver, domain string, qtype uint16) ([]string, error) {
 		switch record := answer.(type) {
 		case *dns.A:
 			results = append(results, record.A.String())
+		case *dns.AAAA:
+			results = append(results, record.AAAA.String())
 		case *dns.PTR:
 			results = append(results, record.Ptr)
 		}
@@ -88,22 +77,16 @@ func queryDNS(resolver, domain string, qtype uint16) ([]string, error) {
 	return results, nil
 }
 
-// ScoreDNSExternalFwd checks that for each line in the query file,
-// a forward DNS query (A record) for the external domain returns the expected external IP.
-// The team number is derived from the resolver’s last octet (but the actual DNS server is now 10.20.0.10).
-func ScoreDNSExternalFwd(service enum.Service, address string) (int, bool, error) {
-	// If you still need the team number for domain/IP token replacements:
-	team, err := getTeamNumberFromAddress(address, "external")
+// readDNSQueryFile reads the service's query file, returning the fields of every non-empty line.
+// Each line is expected to hold 4 fields: externalIP externalDomain internalIP internalDomain
+func readDNSQueryFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return 0, false, err
-	}
-
-	f, err := os.Open(service.QFile)
-	if err != nil {
-		return 0, false, fmt.Errorf("failed to open query file: %v", err)
+		return nil, fmt.Errorf("failed to open query file: %v", err)
 	}
 	defer f.Close()
 
+	var lines [][]string
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -111,220 +94,159 @@ func ScoreDNSExternalFwd(service enum.Service, address string) (int, bool, error
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
-		// Expecting 4 fields: externalIP externalDomain internalIP internalDomain
 		fields := strings.Fields(line)
 		if len(fields) < 4 {
-			return 0, false, fmt.Errorf("invalid query file format: %s", line)
-		}
-
-		// Replace "<t>" with team number
-		expectedIP := replaceTeamToken(fields[0], team)
-		domain := replaceTeamToken(fields[1], team)
-
-		// Use the config's official DNS as the DNS for external scoring
-		dnsServer := cfg.OfficialVirtualMachines["dns"].IP
-
-		// Query for an A record
-		results, err := queryDNS(dnsServer+":53", domain, dns.TypeA)
-		if err != nil {
-			return 0, false, fmt.Errorf("DNS A query for %s failed: %v", domain, err)
-		}
-
-		// Check that the expected IP is present
-		found := false
-		for _, ip := range results {
-			if ip == expectedIP {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return 0, false, fmt.Errorf("external forward lookup mismatch for %s: got %v, expected %s",
-				domain, results, expectedIP)
+			return nil, fmt.Errorf("invalid query file format: %s", line)
 		}
+		lines = append(lines, fields)
 	}
 	if err := scanner.Err(); err != nil {
-		return 0, false, fmt.Errorf("error reading query file: %v", err)
+		return nil, fmt.Errorf("error reading query file: %v", err)
 	}
 
-	return service.Award, true, nil
+	return lines, nil
 }
 
-// ScoreDNSExternalRev checks that for each line in the query file,
-// a reverse DNS (PTR) query for the external IP returns the expected external domain.
-// The team number is derived from the resolver’s last octet (but the actual DNS server is now 10.20.0.10).
-func ScoreDNSExternalRev(service enum.Service, address string) (int, bool, error) {
-	// If you still need the team number for domain/IP token replacements:
-	team, err := getTeamNumberFromAddress(address, "external")
-	if err != nil {
-		return 0, false, err
-	}
-
-	f, err := os.Open(service.QFile)
-	if err != nil {
-		return 0, false, fmt.Errorf("failed to open query file: %v", err)
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
-			return 0, false, fmt.Errorf("invalid query file format: %s", line)
+// contains reports whether want is one of the results.
+func contains(results []string, want string) bool {
+	for _, result := range results {
+		if result == want {
+			return true
 		}
+	}
+	return false
+}
 
-		// For external reverse lookup, use the external IP and domain.
-		expectedIP := replaceTeamToken(fields[0], team)
-		expectedDomain := replaceTeamToken(fields[1], team)
+// dnsLookup checks a single line of a DNS query file, returning a description of what resolved.
+type dnsLookup func(ctx context.Context, fields []string) (string, error)
 
-		// Compute the reverse lookup (PTR) domain
-		ptrDomain, err := reverseIP(expectedIP)
+// expandFields fills in the team tokens of a query file line, like "10.{T*10}.0.5" or
+// "team<t>.local", the same way virtual machine addresses are.
+func expandFields(fields []string, teamID int) ([]string, error) {
+	expanded := make([]string, len(fields))
+	for i, field := range fields {
+		value, err := address.Expand(field, teamID)
 		if err != nil {
-			return 0, false, fmt.Errorf("failed to compute PTR domain for %s: %v", expectedIP, err)
+			return nil, fmt.Errorf("invalid query file field: %v", err)
 		}
+		expanded[i] = value
+	}
+	return expanded, nil
+}
 
-		// Use the config's official DNS as the DNS for external scoring
-		dnsServer := cfg.OfficialVirtualMachines["dns"].IP
+// scoreDNSLines runs lookup against every line of the service's query file, with its team tokens
+// filled in. Each line is one part of the check, so services that award partial points earn a
+// share for each line that resolves.
+func scoreDNSLines(ctx context.Context, target CheckTarget, lookup dnsLookup) enum.CheckResult {
+	lines, err := readDNSQueryFile(target.Service.QFile)
+	if err != nil {
+		return Fail(err)
+	}
 
-		// Query for a PTR record
-		results, err := queryDNS(dnsServer+":53", ptrDomain, dns.TypePTR)
+	var passed []string
+	var failures []error
+	for _, fields := range lines {
+		fields, err := expandFields(fields, target.TeamID)
 		if err != nil {
-			return 0, false, fmt.Errorf("DNS PTR query for %s failed: %v", ptrDomain, err)
+			return Fail(err)
 		}
-
-		// Normalize the expected domain as an FQDN
-		fqdnExpected := dns.Fqdn(expectedDomain)
-		found := false
-		for _, ptr := range results {
-			if ptr == fqdnExpected {
-				found = true
+		evidence, err := lookup(ctx, fields)
+		if err != nil {
+			failures = append(failures, err)
+			// Without partial points the first mismatch decides the check
+			if !target.Service.Partial {
 				break
 			}
+			continue
 		}
-		if !found {
-			return 0, false, fmt.Errorf("external reverse lookup mismatch for %s: got %v, expected %s",
-				expectedIP, results, fqdnExpected)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return 0, false, fmt.Errorf("error reading query file: %v", err)
+		passed = append(passed, evidence)
 	}
 
-	return service.Award, true, nil
+	return Tally(target, passed, failures)
 }
 
-// ScoreDNSInternalFwd checks that for each line in the query file,
-// a forward DNS query (A record) for the internal domain returns the expected internal IP.
-// The team number is derived from the resolver’s third octet.
-func ScoreDNSInternalFwd(service enum.Service, address string) (int, bool, error) {
-	team, err := getTeamNumberFromAddress(address, "internal")
+// lookupAddress checks that a forward query for domain sent to server returns expectedIP, asking
+// for an A record for IPv4 addresses and an AAAA record for IPv6 ones.
+func lookupAddress(ctx context.Context, server, domain, expectedIP string) (string, error) {
+	qtype, err := addressType(expectedIP)
 	if err != nil {
-		return 0, false, err
+		return "", err
 	}
-
-	f, err := os.Open(service.QFile)
+	results, err := queryDNS(ctx, dnsServer(server), domain, qtype)
 	if err != nil {
-		return 0, false, fmt.Errorf("failed to open query file: %v", err)
+		return "", fmt.Errorf("DNS %s query for %s failed: %v", dns.TypeToString[qtype], domain, err)
 	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
-			return 0, false, fmt.Errorf("invalid query file format: %s", line)
-		}
-
-		// For internal forward lookup, use the internal IP (field 3) and domain (field 4).
-		expectedIP := replaceTeamToken(fields[2], team)
-		domain := replaceTeamToken(fields[3], team)
-
-		results, err := queryDNS(address+":53", domain, dns.TypeA)
-		if err != nil {
-			return 0, false, fmt.Errorf("DNS A query for %s failed: %v", domain, err)
-		}
-
-		found := false
-		for _, ip := range results {
-			if ip == expectedIP {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return 0, false, fmt.Errorf("internal forward lookup mismatch for %s: got %v, expected %s", domain, results, expectedIP)
+	// Compare parsed addresses, since IPv6 addresses can be written several ways
+	for _, result := range results {
+		if net.ParseIP(result).Equal(net.ParseIP(expectedIP)) {
+			return fmt.Sprintf("%s -> %s", domain, expectedIP), nil
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return 0, false, fmt.Errorf("error reading query file: %v", err)
-	}
-
-	return service.Award, true, nil
+	return "", fmt.Errorf("forward lookup mismatch for %s: got %v, expected %s", domain, results, expectedIP)
 }
 
-// ScoreDNSInternalRev checks that for each line in the query file,
-// a reverse DNS (PTR) query for the internal IP returns the expected internal domain.
-// The team number is derived from the resolver’s third octet.
-func ScoreDNSInternalRev(service enum.Service, address string) (int, bool, error) {
-	team, err := getTeamNumberFromAddress(address, "internal")
+// lookupPTR checks that a PTR query for ip sent to server returns expectedDomain.
+func lookupPTR(ctx context.Context, server, ip, expectedDomain string) (string, error) {
+	// Compute the reverse lookup (PTR) domain
+	ptrDomain, err := reverseIP(ip)
 	if err != nil {
-		return 0, false, err
+		return "", fmt.Errorf("failed to compute PTR domain for %s: %v", ip, err)
 	}
 
-	f, err := os.Open(service.QFile)
+	results, err := queryDNS(ctx, dnsServer(server), ptrDomain, dns.TypePTR)
 	if err != nil {
-		return 0, false, fmt.Errorf("failed to open query file: %v", err)
+		return "", fmt.Errorf("DNS PTR query for %s failed: %v", ptrDomain, err)
 	}
-	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
-			return 0, false, fmt.Errorf("invalid query file format: %s", line)
-		}
+	// Normalize the expected domain as an FQDN
+	fqdnExpected := dns.Fqdn(expectedDomain)
+	if !contains(results, fqdnExpected) {
+		return "", fmt.Errorf("reverse lookup mismatch for %s: got %v, expected %s", ip, results, fqdnExpected)
+	}
+	return fmt.Sprintf("%s -> %s", ip, fqdnExpected), nil
+}
 
-		// For internal reverse lookup, use the internal IP (field 3) and expected domain (field 4).
-		expectedIP := replaceTeamToken(fields[2], team)
-		expectedDomain := replaceTeamToken(fields[3], team)
+// ScoreDNSExternalFwd checks that for each line in the query file,
+// a forward DNS query (A, or AAAA for an IPv6 address) for the external domain returns the expected external IP.
+// The queries are sent to the official DNS server rather than the team's own.
+func ScoreDNSExternalFwd(ctx context.Context, target CheckTarget) enum.CheckResult {
+	// Use the config's official DNS as the DNS for external scoring
+	dnsServer := officialDNS()
+
+	return scoreDNSLines(ctx, target, func(ctx context.Context, fields []string) (string, error) {
+		return lookupAddress(ctx, dnsServer, fields[1], fields[0])
+	})
+}
 
-		ptrDomain, err := reverseIP(expectedIP)
-		if err != nil {
-			return 0, false, fmt.Errorf("failed to compute PTR domain for %s: %v", expectedIP, err)
-		}
+// ScoreDNSExternalRev checks that for each line in the query file,
+// a reverse DNS (PTR) query for the external IP returns the expected external domain.
+// The queries are sent to the official DNS server rather than the team's own.
+func ScoreDNSExternalRev(ctx context.Context, target CheckTarget) enum.CheckResult {
+	// Use the config's official DNS as the DNS for external scoring
+	dnsServer := officialDNS()
 
-		results, err := queryDNS(address+":53", ptrDomain, dns.TypePTR)
-		if err != nil {
-			return 0, false, fmt.Errorf("DNS PTR query for %s failed: %v", ptrDomain, err)
-		}
+	return scoreDNSLines(ctx, target, func(ctx context.Context, fields []string) (string, error) {
+		// For external reverse lookup, use the external IP and domain.
+		return lookupPTR(ctx, dnsServer, fields[0], fields[1])
+	})
+}
 
-		fqdnExpected := dns.Fqdn(expectedDomain)
-		found := false
-		for _, ptr := range results {
-			if ptr == fqdnExpected {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return 0, false, fmt.Errorf("internal reverse lookup mismatch for %s: got %v, expected %s", expectedIP, results, fqdnExpected)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return 0, false, fmt.Errorf("error reading query file: %v", err)
-	}
+// ScoreDNSInternalFwd checks that for each line in the query file,
+// a forward DNS query (A, or AAAA for an IPv6 address) for the internal domain returns the expected internal IP.
+// The queries are sent to the team's own DNS server.
+func ScoreDNSInternalFwd(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return scoreDNSLines(ctx, target, func(ctx context.Context, fields []string) (string, error) {
+		// For internal forward lookup, use the internal IP (field 3) and domain (field 4).
+		return lookupAddress(ctx, target.Address, fields[3], fields[2])
+	})
+}
 
-	return service.Award, true, nil
+// ScoreDNSInternalRev checks that for each line in the query file,
+// a reverse DNS (PTR) query for the internal IP returns the expected internal domain.
+// The queries are sent to the team's own DNS server.
+func ScoreDNSInternalRev(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return scoreDNSLines(ctx, target, func(ctx context.Context, fields []string) (string, error) {
+		// For internal reverse lookup, use the internal IP (field 3) and expected domain (field 4).
+		return lookupPTR(ctx, target.Address, fields[2], fields[3])
+	})
 }
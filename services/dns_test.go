@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestReverseIP(t *testing.T) {
+	cases := map[string]string{
+		"10.20.1.1":   "1.1.20.10.in-addr.arpa.",
+		"fd00::1:5":   "5.0.0.0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	}
+	for ip, want := range cases {
+		got, err := reverseIP(ip)
+		if err != nil {
+			t.Fatalf("reverseIP(%q) failed: %v", ip, err)
+		}
+		if got != want {
+			t.Fatalf("reverseIP(%q): expected %q, got %q", ip, want, got)
+		}
+	}
+	if _, err := reverseIP("team1.local"); err == nil {
+		t.Fatal("reverseIP should reject a hostname")
+	}
+}
+
+func TestAddressType(t *testing.T) {
+	cases := map[string]uint16{"10.20.1.1": dns.TypeA, "fd00::5": dns.TypeAAAA, "::ffff:10.0.0.1": dns.TypeA}
+	for ip, want := range cases {
+		got, err := addressType(ip)
+		if err != nil {
+			t.Fatalf("addressType(%q) failed: %v", ip, err)
+		}
+		if got != want {
+			t.Fatalf("addressType(%q): expected %s, got %s", ip, dns.TypeToString[want], dns.TypeToString[got])
+		}
+	}
+	if _, err := addressType("10.20.1"); err == nil {
+		t.Fatal("addressType should reject an invalid address")
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/LTSEC/NEST/enum"
+	"golang.org/x/crypto/ssh"
+)
+
+// loginServices are the service types that log in as a user from their query file when they
+// don't have a single configured user.
+var loginServices = map[string]bool{
+	"ftplogin": true, "ftpread": true, "ftpwrite": true, "ssh": true, "sshcommand": true,
+	"mysql": true, "postgres": true, "smtp": true, "smtpauth": true, "pop3": true, "imap": true, "ldap": true,
+}
+
+// CheckFiles reads every file a service of the given type would use when it is scored, and
+// reports the ones that are missing or can't be parsed, so a configuration can be checked before
+// the engine starts. Paths are relative to the working directory, as they are when scoring.
+func CheckFiles(name string, service enum.Service) []error {
+	var problems []error
+
+	switch {
+	case strings.HasPrefix(name, "dns") && service.QFile == "":
+		problems = append(problems, fmt.Errorf("needs a query_file of domains to look up"))
+	case name == "webcontent" && service.QFile == "":
+		problems = append(problems, fmt.Errorf("needs a query_file of the expected page content"))
+	case service.QFile != "":
+		if err := checkQueryFile(name, service.QFile); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if usesQueryDir(name) {
+		if service.QDir == "" {
+			problems = append(problems, fmt.Errorf("needs a query_dir of files to check"))
+		} else if _, err := os.Stat(service.QDir); err != nil {
+			problems = append(problems, fmt.Errorf("could not read query directory: %v", err))
+		}
+	}
+
+	if service.KeyFile != "" {
+		if keyBytes, err := os.ReadFile(service.KeyFile); err != nil {
+			problems = append(problems, fmt.Errorf("could not read SSH key: %v", err))
+		} else if _, err := ssh.ParsePrivateKey(keyBytes); err != nil {
+			problems = append(problems, fmt.Errorf("could not parse SSH key %s: %v", service.KeyFile, err))
+		}
+	}
+
+	if service.Queries != "" {
+		if _, err := loadSQLQueries(service.Queries); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if service.Reference != "" {
+		if _, err := loadSQLReference(service.Reference); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(service.Teams)) {
+		override := service.Teams[key]
+		if override.QFile != "" {
+			if err := checkQueryFile(name, override.QFile); err != nil {
+				problems = append(problems, fmt.Errorf("team '%s': %w", key, err))
+			}
+		}
+		if override.QDir != "" && usesQueryDir(name) {
+			if _, err := os.Stat(override.QDir); err != nil {
+				problems = append(problems, fmt.Errorf("team '%s': could not read query directory: %v", key, err))
+			}
+		}
+	}
+	return problems
+}
+
+// CheckOverride reports the fields of a team's override that a service of the given type doesn't
+// use when it is scored, so they are refused rather than ignored.
+func CheckOverride(name string, override enum.ServiceOverride) []error {
+	var problems []error
+	if override.User != "" && !loginServices[name] {
+		problems = append(problems, fmt.Errorf("%s services don't log in, so the user can't be overridden", name))
+	}
+	if override.QFile != "" && !usesQueryFile(name) {
+		problems = append(problems, fmt.Errorf("%s services don't read a query file, so it can't be overridden", name))
+	}
+	if override.QDir != "" && !usesQueryDir(name) {
+		problems = append(problems, fmt.Errorf("only ftpread and ftpwrite services read a query directory, so %s can't override it", name))
+	}
+	return problems
+}
+
+// usesQueryFile reports whether a service of the given type reads its query file when scored.
+func usesQueryFile(name string) bool {
+	return strings.HasPrefix(name, "dns") || name == "webcontent" || loginServices[name]
+}
+
+// usesQueryDir reports whether a service of the given type reads its query directory when scored.
+func usesQueryDir(name string) bool {
+	return name == "ftpread" || name == "ftpwrite"
+}
+
+// checkQueryFile checks that a service of the given type can use the query file at path.
+func checkQueryFile(name, path string) error {
+	switch {
+	case strings.HasPrefix(name, "dns"):
+		if _, err := readDNSQueryFile(path); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case name == "webcontent":
+		if _, err := os.ReadFile(path); err != nil {
+			return fmt.Errorf("could not read query file: %v", err)
+		}
+	case loginServices[name]:
+		return checkUsersFile(path)
+	}
+	return nil
+}
+
+// checkUsersFile checks that a query file holds at least one "username:password" line.
+func checkUsersFile(path string) error {
+	users, err := ReadUsers(path)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("no valid 'username:password' lines in %s", path)
+	}
+	return nil
+}
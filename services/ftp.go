@@ -2,9 +2,13 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -14,77 +18,87 @@ import (
 )
 
 var (
-	ftpFiles    = make(map[string][]byte)
-	ftpLoadOnce sync.Once
+	ftpMu    sync.Mutex
+	ftpFiles = make(map[string]map[string][]byte) // Query directory or file -> file name -> contents
 )
 
 // establishFTPConnection is a utility function that attempts to connect to the designated ip
 // address at the designated port with the FTP protocol, and returns the connection or an error.
-func establishFTPConnection(ip string, port int) (ftp.ServerConn, error) {
+// The connection is torn down if ctx is cancelled while it is in use; call the returned stop
+// function once finished with the connection.
+func establishFTPConnection(ctx context.Context, ip string, port int) (*ftp.ServerConn, func() bool, error) {
 	connection, err := ftp.Dial(
-		fmt.Sprintf("%s:%d", ip, port),
+		net.JoinHostPort(ip, strconv.Itoa(port)),
 		ftp.DialWithTimeout(ftp_timeout*time.Millisecond),
+		ftp.DialWithContext(ctx),
 	)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return *connection, err
+	stop := context.AfterFunc(ctx, func() { connection.Quit() })
+	return connection, stop, nil
 }
 
-// loadFTPFiles is a utility function that loads a files or all the files in a directory into memory
-// for use in scoring. The function uses sync to only run one time, when it initially called.
-func LoadFTPFiles(path string) error {
-	var err error
-
-	// Ensure it can only be run once
-	ftpLoadOnce.Do(func() {
-		// Check if path is a file or a directory
-		info, statErr := os.Stat(path)
-		if statErr != nil {
-			err = fmt.Errorf("failed to stat %s: %v", path, statErr)
-			return
+// LoadFTPFiles is a utility function that loads a file or all the files in a directory into
+// memory for use in scoring, returning them by name. Each path is only read the first time it is
+// needed, and kept separately, so teams can have their own. A path that can't be read is tried
+// again the next time.
+func LoadFTPFiles(path string) (map[string][]byte, error) {
+	ftpMu.Lock()
+	defer ftpMu.Unlock()
+	if files, ok := ftpFiles[path]; ok {
+		return files, nil
+	}
+
+	// Check if path is a file or a directory
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	files := make(map[string][]byte)
+	if info.IsDir() {
+		// Process directory
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %s: %v", path, err)
 		}
 
-		if info.IsDir() {
-			// Process directory
-			entries, e := os.ReadDir(path)
-			if e != nil {
-				err = fmt.Errorf("failed to read directory %s: %v", path, e)
-				return
+		// Read all files in the directory
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue // Skip subdirectories
 			}
-
-			// Read all files in the directory
-			for _, entry := range entries {
-				if entry.IsDir() {
-					continue // Skip subdirectories
-				}
-				filePath := path + "/" + entry.Name()
-				if readErr := readAndStoreFile(filePath, entry.Name()); readErr != nil {
-					err = readErr
-					return
-				}
+			if err := readAndStoreFile(files, path+"/"+entry.Name(), entry.Name()); err != nil {
+				return nil, err
 			}
-		} else {
-			// Process a single file
-			err = readAndStoreFile(path, info.Name())
 		}
-	})
+	} else {
+		// Process a single file
+		if err := readAndStoreFile(files, path, info.Name()); err != nil {
+			return nil, err
+		}
+	}
 
-	return err
+	ftpFiles[path] = files
+	return files, nil
 }
 
-// Helper function to read a file and store it in ftpFiles
-func readAndStoreFile(filePath, fileName string) error {
+// Helper function to read a file and store it in files
+func readAndStoreFile(files map[string][]byte, filePath, fileName string) error {
 	data, readErr := os.ReadFile(filePath)
 	if readErr != nil {
 		return fmt.Errorf("failed to read file %s: %v", filePath, readErr)
 	}
-	ftpFiles[fileName] = data
+	files[fileName] = data
 	return nil
 }
 
-// Helper function to pick a random file from ftpFiles
-func getRandomFile() string {
+// Helper function to pick a random file from files
+func getRandomFile(files map[string][]byte) string {
 	var fileNames []string
-	for name := range ftpFiles {
+	for name := range files {
 		fileNames = append(fileNames, name)
 	}
 	randomIndex := rand.Intn(len(fileNames))
@@ -93,161 +107,180 @@ func getRandomFile() string {
 	return randomFile
 }
 
+// ftpFilesToCheck returns the files a read or write check should cover. Services that award
+// partial points check every file, each one being a part of the check; otherwise a single random
+// file is checked each round.
+func ftpFilesToCheck(service enum.Service, files map[string][]byte) []string {
+	if !service.Partial {
+		return []string{getRandomFile(files)}
+	}
+
+	fileNames := make([]string, 0, len(files))
+	for name := range files {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+	return fileNames
+}
+
+// ftpLoginForFiles loads the service's files, then connects to the server and logs in, for checks
+// that work with the loaded files.
+func ftpLoginForFiles(ctx context.Context, target CheckTarget) (*ftp.ServerConn, func() bool, string, map[string][]byte, error) {
+	files, err := LoadFTPFiles(target.Service.QDir)
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+	// Ensure we actually have test files loaded
+	if len(files) == 0 {
+		return nil, nil, "", nil, fmt.Errorf("no FTP test files available in %s", target.Service.QDir)
+	}
+
+	ftpConn, stop, err := establishFTPConnection(ctx, target.Address, target.Service.Port)
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+
+	// Either the single configured user or one from the related query file
+	user, pass, err := chooseCredentials(target)
+	if err != nil {
+		stop()
+		ftpConn.Quit()
+		return nil, nil, "", nil, err
+	}
+
+	if err := ftpConn.Login(user, pass); err != nil {
+		stop()
+		ftpConn.Quit()
+		return nil, nil, "", nil, fmt.Errorf("failed to login as %s: %v", user, err)
+	}
+
+	return ftpConn, stop, user, files, nil
+}
+
 // ScoreFTP is a general scorer for FTP that checks for a valid FTP connection and then returns
-func ScoreFTP(service enum.Service, address string) (int, bool, error) {
-	ftpConn, err := establishFTPConnection(address, service.Port)
+func ScoreFTP(ctx context.Context, target CheckTarget) enum.CheckResult {
+	ftpConn, stop, err := establishFTPConnection(ctx, target.Address, target.Service.Port)
 	if err != nil {
-		return 0, false, err
+		return Fail(err)
 	}
+	defer stop()
 
 	if quitErr := ftpConn.Quit(); quitErr != nil {
-		return 0, false, fmt.Errorf("quit error: %v", quitErr)
+		return Fail(fmt.Errorf("quit error: %v", quitErr))
 	}
 
-	return service.Award, true, nil
+	return Pass(target, "connection established")
 }
 
 // ScoreFTPLogin is a scorer for FTP that checks for if the user can log in
-func ScoreFTPLogin(service enum.Service, address string) (int, bool, error) {
-	ftpConn, err := establishFTPConnection(address, service.Port)
+func ScoreFTPLogin(ctx context.Context, target CheckTarget) enum.CheckResult {
+	ftpConn, stop, err := establishFTPConnection(ctx, target.Address, target.Service.Port)
 	if err != nil {
-		return 0, false, err
+		return Fail(err)
 	}
+	defer stop()
 
-	var user, pass string
-	// Check if the login user should be a single user
-	if service.User != "" {
-		user, pass = service.User, service.Password
-	} else { // Otherwise read from the related query file
-		// Login
-		user, pass, err = ChooseRandomUser(service.QFile)
-		if err != nil {
-			return 0, false, err
-		}
+	// Either the single configured user or one from the related query file
+	user, pass, err := chooseCredentials(target)
+	if err != nil {
+		return Fail(err)
 	}
 
 	// Login
 	err = ftpConn.Login(user, pass)
 	if err != nil {
-		return 0, false, fmt.Errorf("failed to log in: %v", err)
+		return Fail(fmt.Errorf("failed to log in as %s: %v", user, err))
 	}
 
 	// Logout
 	if err = ftpConn.Quit(); err != nil {
-		return 0, false, fmt.Errorf("failed to log out: %v", err)
+		return Fail(fmt.Errorf("failed to log out: %v", err))
 	}
 
-	return service.Award, true, nil
+	return Pass(target, fmt.Sprintf("logged in as %s", user))
 }
 
 // ScoreFTPWrite is a scorer for FTP that checks for if the user can write to a/many file(s).
 //
 // Requires `service.QDir` to be a directory of files expected to be in the FTP server.
-func ScoreFTPWrite(service enum.Service, address string) (int, bool, error) {
-	LoadFTPFiles(service.QDir)
-	// Ensure we actually have test files loaded
-	if len(ftpFiles) == 0 {
-		return 0, false, fmt.Errorf("no FTP test files available; did you include any in tests/ftpfiles?")
-	}
-
-	ftpConn, err := establishFTPConnection(address, service.Port)
+func ScoreFTPWrite(ctx context.Context, target CheckTarget) enum.CheckResult {
+	ftpConn, stop, user, files, err := ftpLoginForFiles(ctx, target)
 	if err != nil {
-		return 0, false, err
+		return Fail(err)
 	}
-
-	var user, pass string
-	// Check if the login user should be a single user
-	if service.User != "" {
-		user, pass = service.User, service.Password
-	} else { // Otherwise read from the related query file
-		// Login
-		user, pass, err = ChooseRandomUser(service.QFile)
-		if err != nil {
-			return 0, false, err
+	defer stop()
+
+	var passed []string
+	var failures []error
+	for _, fileName := range ftpFilesToCheck(target.Service, files) {
+		// Get the file's local contents and convert to an io.Reader
+		fileBytes := files[fileName]
+		dataReader := bytes.NewBuffer(fileBytes)
+
+		// Upload/Overwrite file on server
+		if err := ftpConn.Stor(fileName, dataReader); err != nil {
+			failures = append(failures, fmt.Errorf("failed to write %s: %v", fileName, err))
+			continue
 		}
+		passed = append(passed, fmt.Sprintf("wrote %s (%d bytes) as %s", fileName, len(fileBytes), user))
 	}
 
-	err = ftpConn.Login(user, pass)
-	if err != nil {
-		return 0, false, fmt.Errorf("failed to login: %v", err)
+	// Logout
+	if err = ftpConn.Quit(); err != nil {
+		return Fail(fmt.Errorf("failed to log out: %v", err))
 	}
 
-	// Get a random file, get the file's local contents, and convert to an io.Reader
-	randomFile := getRandomFile()
-	randomFileBytes := ftpFiles[randomFile]
-	dataReader := bytes.NewBuffer(randomFileBytes)
+	return Tally(target, passed, failures)
+}
 
-	// Upload/Overwrite file on server
-	err = ftpConn.Stor(randomFile, dataReader)
+// readFTPFile retrieves a file from the server and compares it with the locally stored version.
+func readFTPFile(ftpConn *ftp.ServerConn, fileName string, expected []byte) (int, error) {
+	// Retrieve the file
+	result, err := ftpConn.Retr(fileName)
 	if err != nil {
-		return 0, false, err
+		return 0, fmt.Errorf("failed to retrieve %s: %v", fileName, err)
 	}
 
-	// Logout
-	if err = ftpConn.Quit(); err != nil {
-		return 0, false, fmt.Errorf("failed to log out: %v", err)
+	// Read the FTP file contents
+	buf, err := io.ReadAll(result)
+	result.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", fileName, err)
 	}
 
-	return service.Award, true, nil
+	// Compare with our locally stored version
+	if !bytes.Equal(buf, expected) {
+		return 0, fmt.Errorf("contents of %s did not match: got %d bytes, expected %d", fileName, len(buf), len(expected))
+	}
+
+	return len(buf), nil
 }
 
 // ScoreFTPRead is a scorer for FTP that checks for if the user can read from a/many file(s).
 //
 // Requires `service.QDir` to be a directory of files expected to be in the FTP server.
-func ScoreFTPRead(service enum.Service, address string) (int, bool, error) {
-	LoadFTPFiles(service.QDir)
-	// Ensure we actually have test files loaded
-	if len(ftpFiles) == 0 {
-		return 0, false, fmt.Errorf("no FTP test files available; did you include any in tests/ftpfiles?")
-	}
-
-	ftpConn, err := establishFTPConnection(address, service.Port)
+func ScoreFTPRead(ctx context.Context, target CheckTarget) enum.CheckResult {
+	ftpConn, stop, user, files, err := ftpLoginForFiles(ctx, target)
 	if err != nil {
-		return 0, false, err
+		return Fail(err)
 	}
+	defer stop()
 
-	var user, pass string
-	// Check if the login user should be a single user
-	if service.User != "" {
-		user, pass = service.User, service.Password
-	} else { // Otherwise read from the related query file
-		// Login
-		user, pass, err = ChooseRandomUser(service.QFile)
+	var passed []string
+	var failures []error
+	for _, fileName := range ftpFilesToCheck(target.Service, files) {
+		size, err := readFTPFile(ftpConn, fileName, files[fileName])
 		if err != nil {
-			return 0, false, err
+			failures = append(failures, err)
+			continue
 		}
-	}
-
-	err = ftpConn.Login(user, pass)
-	if err != nil {
-		return 0, false, fmt.Errorf("failed to login: %v", err)
-	}
-
-	randomFile := getRandomFile()
-
-	// Retrieve the randomly chosen file
-	result, err := ftpConn.Retr(randomFile)
-	if err != nil {
-		return 0, false, err
-	}
-	defer result.Close()
-
-	// Read the FTP file contents
-	buf, err := io.ReadAll(result)
-	if err != nil {
-		return 0, false, err
+		passed = append(passed, fmt.Sprintf("read %s (%d bytes) as %s", fileName, size, user))
 	}
 
 	// Logout
 	if quitErr := ftpConn.Quit(); quitErr != nil {
-		return 0, false, err
-	}
-
-	// Compare with our locally stored version
-	expected := ftpFiles[randomFile]
-	if !bytes.Equal(buf, expected) {
-		return 0, false, err
+		return Fail(fmt.Errorf("failed to log out: %v", quitErr))
 	}
 
-	return service.Award, true, nil
+	return Tally(target, passed, failures)
 }
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapTemplate fills in the "<u>" (username) and "<t>" (team ID) tokens of a DN or filter.
+// Values substituted into a filter are escaped so a username can't change the filter's meaning.
+func ldapTemplate(template, username string, teamID int, filter bool) string {
+	if filter {
+		username = ldap.EscapeFilter(username)
+	}
+	return strings.NewReplacer("<u>", username, "<t>", strconv.Itoa(teamID)).Replace(template)
+}
+
+// ldapExpectation checks a single "expect" line against the entries a search returned.
+// Lines are one of:
+//
+//	dn:<dn>          an entry with the given DN was returned
+//	<attr>=<value>   some entry has the attribute with the given value
+//	<attr>           some entry has the attribute at all
+//
+// Comparisons are case-insensitive, as they are in most directories.
+func ldapExpectation(expect string, entries []*ldap.Entry) error {
+	if dn, ok := strings.CutPrefix(expect, "dn:"); ok {
+		dn = strings.TrimSpace(dn)
+		for _, entry := range entries {
+			if strings.EqualFold(entry.DN, dn) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no entry with DN %s was returned", dn)
+	}
+
+	attr, value, hasValue := strings.Cut(expect, "=")
+	attr, value = strings.TrimSpace(attr), strings.TrimSpace(value)
+	for _, entry := range entries {
+		for _, entryAttr := range entry.Attributes {
+			if !strings.EqualFold(entryAttr.Name, attr) {
+				continue
+			}
+			if !hasValue {
+				return nil
+			}
+			for _, v := range entryAttr.Values {
+				if strings.EqualFold(v, value) {
+					return nil
+				}
+			}
+		}
+	}
+	if hasValue {
+		return fmt.Errorf("no entry has %s=%s", attr, value)
+	}
+	return fmt.Errorf("no entry has the attribute %s", attr)
+}
+
+// ScoreLDAP binds to a team's directory server as one of its users over LDAP or LDAPS, then runs
+// the configured search and checks the expected entries and attributes came back.
+//
+// Without a filter only the bind is scored. With a filter and no expectations, the search must
+// return at least one entry.
+func ScoreLDAP(ctx context.Context, target CheckTarget) enum.CheckResult {
+	service := target.Service
+
+	// Either the single configured user or one from the related query file
+	username, password, err := chooseCredentials(target)
+	if err != nil {
+		return Fail(err)
+	}
+
+	scheme := "ldap"
+	if service.TLS {
+		scheme = "ldaps"
+	}
+	url := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(target.Address, strconv.Itoa(service.Port)))
+	tlsConfig := &tls.Config{ServerName: target.Address, InsecureSkipVerify: true}
+
+	conn, err := ldap.DialURL(url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldap_timeout * time.Millisecond}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return Fail(fmt.Errorf("failed to connect to %s: %v", url, err))
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldap_timeout * time.Millisecond)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if service.StartTLS && !service.TLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return Fail(fmt.Errorf("StartTLS failed: %v", err))
+		}
+	}
+
+	// Bind as the chosen user, either through the DN template or the bare username (e.g. an AD UPN)
+	bindDN := username
+	if service.BindDN != "" {
+		bindDN = ldapTemplate(service.BindDN, username, target.TeamID, false)
+	}
+	if err := conn.Bind(bindDN, password); err != nil {
+		return Fail(fmt.Errorf("failed to bind as %s: %v", bindDN, err))
+	}
+
+	if service.Filter == "" {
+		return Pass(target, fmt.Sprintf("bound as %s", bindDN))
+	}
+
+	baseDN := ldapTemplate(service.BaseDN, username, target.TeamID, false)
+	filter := ldapTemplate(service.Filter, username, target.TeamID, true)
+	request := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		100, ldap_timeout/1000+1, false,
+		filter, nil, nil,
+	)
+	response, err := conn.Search(request)
+	if err != nil {
+		return Fail(fmt.Errorf("search for %s under %s failed: %v", filter, baseDN, err))
+	}
+	if len(response.Entries) == 0 {
+		return Fail(fmt.Errorf("search for %s under %s returned no entries", filter, baseDN))
+	}
+
+	for _, expect := range service.Expect {
+		if err := ldapExpectation(ldapTemplate(expect, username, target.TeamID, false), response.Entries); err != nil {
+			return Fail(fmt.Errorf("search for %s under %s: %v", filter, baseDN, err))
+		}
+	}
+
+	return Pass(target, fmt.Sprintf("bound as %s, search for %s returned %d entries", bindDN, filter, len(response.Entries)))
+}
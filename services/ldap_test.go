@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/LTSEC/NEST/enum"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory is a stand-in LDAP server that understands just enough of the protocol for
+// ScoreLDAP: simple binds checked against a password list, and searches that return a fixed set
+// of entries for whoever asks.
+type fakeDirectory struct {
+	passwords map[string]string   // bind DN -> password
+	entries   map[string][]string // DN -> "attr=value" pairs
+	filters   chan string         // every search filter received, decompiled
+}
+
+// start listens on a random local port and serves connections until the test ends.
+func (d *fakeDirectory) start(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := request.Children[1].Data.String()
+			password := request.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if want, ok := d.passwords[dn]; ok && want == password {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationBindResponse, code).Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			d.filters <- filter
+			for dn, attrs := range d.entries {
+				conn.Write(ldapEntry(messageID, dn, attrs).Bytes())
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// ldapEnvelope wraps a protocol operation in an LDAPMessage.
+func ldapEnvelope(messageID int64, op *ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	envelope.AppendChild(op)
+	return envelope
+}
+
+// ldapResponse builds an LDAPResult style response such as a BindResponse or SearchResultDone.
+func ldapResponse(messageID int64, application ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return ldapEnvelope(messageID, op)
+}
+
+// ldapEntry builds a SearchResultEntry from "attr=value" pairs.
+func ldapEntry(messageID int64, dn string, attrs []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, attr := range attrs {
+		name, value, _ := strings.Cut(attr, "=")
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+	return ldapEnvelope(messageID, op)
+}
+
+func TestScoreLDAP(t *testing.T) {
+	directory := &fakeDirectory{
+		passwords: map[string]string{
+			"uid=alice,ou=people,dc=team2,dc=local": "CoffeeBean",
+		},
+		entries: map[string][]string{
+			"uid=alice,ou=people,dc=team2,dc=local": {"uid=alice", "mail=alice@team2.local", "objectClass=inetOrgPerson"},
+		},
+		filters: make(chan string, 10),
+	}
+	port := directory.start(t)
+
+	base := enum.Service{
+		Port:     port,
+		Award:    4,
+		User:     "alice",
+		Password: "CoffeeBean",
+		BindDN:   "uid=<u>,ou=people,dc=team<t>,dc=local",
+		BaseDN:   "dc=team<t>,dc=local",
+	}
+	target := func(service enum.Service) CheckTarget {
+		return CheckTarget{TeamID: 2, Address: "127.0.0.1", Service: service}
+	}
+
+	// Binding alone
+	if result := ScoreLDAP(context.Background(), target(base)); result.Status != enum.StatusUp || result.Points != 4 {
+		t.Fatalf("expected bind to pass, got %+v", result)
+	}
+
+	// A wrong password fails the bind
+	wrong := base
+	wrong.Password = "nope"
+	if result := ScoreLDAP(context.Background(), target(wrong)); result.Status != enum.StatusDown {
+		t.Fatalf("expected a bad password to fail, got %+v", result)
+	}
+
+	// Searching with expectations that are all met
+	search := base
+	search.Filter = "(&(objectClass=inetOrgPerson)(uid=<u>))"
+	search.Expect = []string{"dn:uid=alice,ou=people,dc=team<t>,dc=local", "mail=alice@team<t>.local", "objectClass"}
+	if result := ScoreLDAP(context.Background(), target(search)); result.Status != enum.StatusUp {
+		t.Fatalf("expected search to pass, got %+v", result)
+	}
+	if filter := <-directory.filters; filter != "(&(objectClass=inetOrgPerson)(uid=alice))" {
+		t.Fatalf("server received unexpected filter %q", filter)
+	}
+
+	// Searching with an expectation that isn't met
+	missing := search
+	missing.Expect = []string{"mail=bob@team2.local"}
+	if result := ScoreLDAP(context.Background(), target(missing)); result.Status != enum.StatusDown {
+		t.Fatalf("expected a missing attribute to fail, got %+v", result)
+	}
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+const (
+	// How long a message must have been sent before POP3/IMAP checks expect to find it
+	mailDeliveryGrace = 5 * time.Second
+	// How many sent messages are remembered per team and virtual machine
+	mailPendingLimit = 10
+	// How many of the newest messages in a mailbox are searched for a tag over POP3
+	pop3SearchLimit = 25
+)
+
+// sentMessage is a tagged message the engine sent through a team's SMTP server.
+type sentMessage struct {
+	tag      string
+	username string // The user it was sent to, who the POP3 and IMAP checks log in as to find it
+	sent     time.Time
+	fetched  map[string]bool // The protocols that have already looked for it
+}
+
+// mailServer identifies a team's mail server by the virtual machine it runs on. POP3 and IMAP
+// checks only look for messages sent through the SMTP service on the same virtual machine.
+type mailServer struct {
+	teamID int
+	vmName string
+}
+
+var (
+	mailMu   sync.Mutex
+	sentMail = make(map[mailServer][]*sentMessage) // The messages most recently sent through each mail server
+)
+
+// mailServerOf returns the mail server a check target's service runs on.
+func mailServerOf(target CheckTarget) mailServer {
+	return mailServer{teamID: target.TeamID, vmName: target.VMName}
+}
+
+// recordSentMail remembers a tagged message sent through a mail server so later POP3 and IMAP
+// checks of that server can look for it.
+func recordSentMail(server mailServer, username, tag string) {
+	mailMu.Lock()
+	defer mailMu.Unlock()
+	pending := append(sentMail[server], &sentMessage{tag: tag, username: username, sent: time.Now(), fetched: make(map[string]bool)})
+	if len(pending) > mailPendingLimit {
+		pending = pending[len(pending)-mailPendingLimit:]
+	}
+	sentMail[server] = pending
+}
+
+// nextDeliverableMail returns the newest message sent through a mail server that has had time
+// to be delivered and that the protocol hasn't looked for yet, marking it and every older message
+// as looked for by that protocol. Each protocol looks for every message on its own, so POP3 and
+// IMAP checks can both find the same one. It returns false if there is nothing to look for.
+func nextDeliverableMail(server mailServer, protocol string) (sentMessage, bool) {
+	mailMu.Lock()
+	defer mailMu.Unlock()
+	pending := sentMail[server]
+
+	cutoff := time.Now().Add(-mailDeliveryGrace)
+	newest := -1
+	for i, msg := range pending {
+		if msg.sent.Before(cutoff) {
+			newest = i
+		}
+	}
+	if newest < 0 || pending[newest].fetched[protocol] {
+		return sentMessage{}, false
+	}
+
+	for _, msg := range pending[:newest+1] {
+		msg.fetched[protocol] = true
+	}
+	return *pending[newest], true
+}
+
+// userPassword returns the password a service has for one of the team's users, from its single
+// configured user or its query file, or one the team changed through a password change request.
+func userPassword(target CheckTarget, username string) (string, bool) {
+	if changed, ok := target.Credentials[username]; ok {
+		return changed, true
+	}
+	if target.Service.User != "" {
+		return target.Service.Password, target.Service.User == username
+	}
+	users, err := ReadUsers(target.Service.QFile)
+	if err != nil {
+		return "", false
+	}
+	for _, user := range users {
+		if user.Username == username {
+			return user.Password, true
+		}
+	}
+	return "", false
+}
+
+// fetchCredentials picks who a POP3 or IMAP check logs in as: the recipient of the newest
+// delivered message the protocol hasn't looked for yet, so its arrival can be confirmed, or when
+// there is none a user as for any other login. The message is false if there is nothing to find.
+func fetchCredentials(target CheckTarget, protocol string) (string, string, sentMessage, bool, error) {
+	if msg, ok := nextDeliverableMail(mailServerOf(target), protocol); ok {
+		if password, known := userPassword(target, msg.username); known {
+			return msg.username, password, msg, true, nil
+		}
+	}
+	username, password, err := chooseCredentials(target)
+	return username, password, sentMessage{}, false, err
+}
+
+// mailNotFound scores a login that worked but didn't turn up the message sent to the user, which
+// is down, or partially up for services that award partial points.
+func mailNotFound(target CheckTarget, protocol string, msg sentMessage) enum.CheckResult {
+	return Tally(target, []string{"logged in as " + msg.username}, []error{
+		fmt.Errorf("message %s sent to %s at %s was not found over %s", msg.tag, msg.username, msg.sent.Format(time.TimeOnly), protocol),
+	})
+}
+
+// newMailTag generates a unique tag to identify a message sent by the engine.
+func newMailTag(teamID int) string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return fmt.Sprintf("NEST-%d-%s", teamID, hex.EncodeToString(buf))
+}
+
+// mailDomain returns the domain used for a team's mail addresses, falling back to an address
+// literal for the team's server when no domain is configured.
+func mailDomain(target CheckTarget) string {
+	if target.Service.Domain != "" {
+		return replaceTeamToken(target.Service.Domain, strconv.Itoa(target.TeamID))
+	}
+	if ip := net.ParseIP(target.Address); ip != nil && ip.To4() == nil {
+		return "[IPv6:" + target.Address + "]"
+	}
+	return "[" + target.Address + "]"
+}
+
+// dialMail opens a TCP connection to a team's mail server whose deadline follows ctx.
+func dialMail(ctx context.Context, address string, port int) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: mail_timeout * time.Millisecond}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+
+	deadline := time.Now().Add(mail_timeout * time.Millisecond)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// mailTLSConfig is used for STARTTLS. Team servers rarely have trusted certificates, so only
+// the ability to negotiate TLS is scored.
+func mailTLSConfig(address string) *tls.Config {
+	return &tls.Config{ServerName: address, InsecureSkipVerify: true}
+}
+
+// plainAuth is smtp.PlainAuth without its refusal to authenticate over unencrypted connections,
+// since STARTTLS is optional for team mail servers.
+type plainAuth struct {
+	username, password string
+}
+
+func (a plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// sendTaggedMail delivers a uniquely tagged message to one of the team's users through their
+// SMTP server, optionally authenticating as that user first, and remembers the tag.
+func sendTaggedMail(ctx context.Context, target CheckTarget, authenticate bool) enum.CheckResult {
+	service := target.Service
+
+	// Either the single configured user or one from the related query file
+	username, password, err := chooseCredentials(target)
+	if err != nil {
+		return Fail(err)
+	}
+
+	conn, err := dialMail(ctx, target.Address, service.Port)
+	if err != nil {
+		return Fail(err)
+	}
+	client, err := smtp.NewClient(conn, target.Address)
+	if err != nil {
+		conn.Close()
+		return Fail(fmt.Errorf("failed to start SMTP session: %v", err))
+	}
+	defer client.Close()
+
+	if err := client.Hello("nest"); err != nil {
+		return Fail(fmt.Errorf("EHLO failed: %v", err))
+	}
+
+	if service.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return Fail(fmt.Errorf("server does not offer STARTTLS"))
+		}
+		if err := client.StartTLS(mailTLSConfig(target.Address)); err != nil {
+			return Fail(fmt.Errorf("STARTTLS failed: %v", err))
+		}
+	}
+
+	if authenticate {
+		if err := client.Auth(plainAuth{username: username, password: password}); err != nil {
+			return Fail(fmt.Errorf("failed to authenticate as %s: %v", username, err))
+		}
+	}
+
+	domain := mailDomain(target)
+	from := "nest@" + domain
+	to := username + "@" + domain
+	tag := newMailTag(target.TeamID)
+
+	if err := client.Mail(from); err != nil {
+		return Fail(fmt.Errorf("MAIL FROM %s rejected: %v", from, err))
+	}
+	if err := client.Rcpt(to); err != nil {
+		return Fail(fmt.Errorf("RCPT TO %s rejected: %v", to, err))
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return Fail(fmt.Errorf("DATA rejected: %v", err))
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: NEST scoring check %s\r\nX-NEST-Tag: %s\r\nDate: %s\r\n\r\nThis message was sent by the NEST scoring engine.\r\n%s\r\n",
+		from, to, tag, tag, time.Now().Format(time.RFC1123Z), tag)
+	if _, err := writer.Write([]byte(message)); err != nil {
+		return Fail(fmt.Errorf("failed to write message: %v", err))
+	}
+	if err := writer.Close(); err != nil {
+		return Fail(fmt.Errorf("message was not accepted: %v", err))
+	}
+	client.Quit()
+
+	recordSentMail(mailServerOf(target), username, tag)
+
+	return Pass(target, fmt.Sprintf("sent %s to %s", tag, to))
+}
+
+// ScoreSMTP checks that the team's SMTP server accepts a message for one of its users.
+func ScoreSMTP(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return sendTaggedMail(ctx, target, false)
+}
+
+// ScoreSMTPAuth checks that one of the team's users can authenticate to their SMTP server and send a message.
+func ScoreSMTPAuth(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return sendTaggedMail(ctx, target, true)
+}
+
+// pop3Client is just enough of a POP3 client to log in and look through a mailbox.
+type pop3Client struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// cmd sends a command and returns the rest of the server's "+OK" line.
+func (c *pop3Client) cmd(format string, args ...any) (string, error) {
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.status()
+}
+
+// status reads a single status line from the server.
+func (c *pop3Client) status() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return "", fmt.Errorf("server replied %q", line)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+}
+
+// ScorePOP3 checks that one of the team's users can log in over POP3, and that the last message
+// the SMTP check on the same virtual machine sent has arrived in its recipient's mailbox.
+func ScorePOP3(ctx context.Context, target CheckTarget) enum.CheckResult {
+	service := target.Service
+
+	// The recipient of the last message sent, or any user when there is nothing to look for
+	username, password, msg, pending, err := fetchCredentials(target, "POP3")
+	if err != nil {
+		return Fail(err)
+	}
+
+	conn, err := dialMail(ctx, target.Address, service.Port)
+	if err != nil {
+		return Fail(err)
+	}
+	defer conn.Close()
+	client := &pop3Client{conn: conn, text: textproto.NewConn(conn)}
+
+	if _, err := client.status(); err != nil {
+		return Fail(fmt.Errorf("bad POP3 greeting: %v", err))
+	}
+
+	if service.StartTLS {
+		if _, err := client.cmd("STLS"); err != nil {
+			return Fail(fmt.Errorf("STLS failed: %v", err))
+		}
+		tlsConn := tls.Client(conn, mailTLSConfig(target.Address))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return Fail(fmt.Errorf("TLS handshake failed: %v", err))
+		}
+		client = &pop3Client{conn: tlsConn, text: textproto.NewConn(tlsConn)}
+	}
+
+	if _, err := client.cmd("USER %s", username); err != nil {
+		return Fail(fmt.Errorf("failed to log in as %s: %v", username, err))
+	}
+	if _, err := client.cmd("PASS %s", password); err != nil {
+		return Fail(fmt.Errorf("failed to log in as %s: %v", username, err))
+	}
+	defer client.cmd("QUIT")
+
+	stat, err := client.cmd("STAT")
+	if err != nil {
+		return Fail(fmt.Errorf("STAT failed: %v", err))
+	}
+	var count int
+	fmt.Sscanf(stat, "%d", &count)
+
+	if !pending {
+		return Pass(target, fmt.Sprintf("logged in as %s, %d messages", username, count))
+	}
+
+	// Look through the newest messages for the tag
+	for n := count; n > 0 && n > count-pop3SearchLimit; n-- {
+		if _, err := client.cmd("TOP %d 0", n); err != nil {
+			if _, err = client.cmd("RETR %d", n); err != nil {
+				return Fail(fmt.Errorf("failed to read message %d: %v", n, err))
+			}
+		}
+		lines, err := client.text.ReadDotLines()
+		if err != nil {
+			return Fail(fmt.Errorf("failed to read message %d: %v", n, err))
+		}
+		if strings.Contains(strings.Join(lines, "\n"), msg.tag) {
+			return Pass(target, fmt.Sprintf("logged in as %s and found %s", username, msg.tag))
+		}
+	}
+
+	return mailNotFound(target, "POP3", msg)
+}
+
+// imapClient is just enough of an IMAP client to log in and search a mailbox.
+type imapClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// cmd sends a tagged command, returning the untagged responses that came before the tagged "OK".
+func (c *imapClient) cmd(format string, args ...any) ([]string, error) {
+	c.tag++
+	tag := fmt.Sprintf("n%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, tag+" "+format+"\r\n", args...); err != nil {
+		return nil, err
+	}
+
+	var untagged []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, tag+" ") {
+			status := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+				return nil, fmt.Errorf("server replied %q", status)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, line)
+	}
+}
+
+// imapQuote quotes a string for use as an IMAP astring.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ScoreIMAP checks that one of the team's users can log in over IMAP, and that the last message
+// the SMTP check on the same virtual machine sent has arrived in its recipient's inbox.
+func ScoreIMAP(ctx context.Context, target CheckTarget) enum.CheckResult {
+	service := target.Service
+
+	// The recipient of the last message sent, or any user when there is nothing to look for
+	username, password, msg, pending, err := fetchCredentials(target, "IMAP")
+	if err != nil {
+		return Fail(err)
+	}
+
+	conn, err := dialMail(ctx, target.Address, service.Port)
+	if err != nil {
+		return Fail(err)
+	}
+	defer conn.Close()
+	client := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	greeting, err := client.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(strings.ToUpper(greeting), "* OK") {
+		return Fail(fmt.Errorf("bad IMAP greeting %q: %v", strings.TrimSpace(greeting), err))
+	}
+
+	if service.StartTLS {
+		if _, err := client.cmd("STARTTLS"); err != nil {
+			return Fail(fmt.Errorf("STARTTLS failed: %v", err))
+		}
+		tlsConn := tls.Client(conn, mailTLSConfig(target.Address))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return Fail(fmt.Errorf("TLS handshake failed: %v", err))
+		}
+		client = &imapClient{conn: tlsConn, reader: bufio.NewReader(tlsConn), tag: client.tag}
+	}
+
+	if _, err := client.cmd("LOGIN %s %s", imapQuote(username), imapQuote(password)); err != nil {
+		return Fail(fmt.Errorf("failed to log in as %s: %v", username, err))
+	}
+	defer client.cmd("LOGOUT")
+
+	if _, err := client.cmd("SELECT INBOX"); err != nil {
+		return Fail(fmt.Errorf("failed to select INBOX: %v", err))
+	}
+
+	if !pending {
+		return Pass(target, fmt.Sprintf("logged in as %s and opened INBOX", username))
+	}
+
+	responses, err := client.cmd("SEARCH SUBJECT %s", imapQuote(msg.tag))
+	if err != nil {
+		return Fail(fmt.Errorf("SEARCH failed: %v", err))
+	}
+	for _, response := range responses {
+		if ids, found := strings.CutPrefix(strings.ToUpper(response), "* SEARCH"); found && strings.TrimSpace(ids) != "" {
+			return Pass(target, fmt.Sprintf("logged in as %s and found %s", username, msg.tag))
+		}
+	}
+
+	return mailNotFound(target, "IMAP", msg)
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+// fakeMailServer is a stand-in mail server that understands just enough SMTP, POP3 and IMAP for
+// the mail checks. Messages sent over SMTP land in the recipient's mailbox, unless delivery is
+// turned off, and can then be read back over POP3 and IMAP by that user.
+type fakeMailServer struct {
+	passwords map[string]string // username -> password
+
+	mu        sync.Mutex
+	mailboxes map[string][]string // username -> messages
+	dropMail  bool                // Accept messages without delivering them
+}
+
+// listen serves connections on a random local port with serve until the test ends.
+func (m *fakeMailServer) listen(t *testing.T, serve func(net.Conn)) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func (m *fakeMailServer) messages(username string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mailboxes[username]
+}
+
+func (m *fakeMailServer) serveSMTP(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	var recipient string
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			text.PrintfLine("250-fake")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			encoded := strings.TrimPrefix(arg, "PLAIN ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			parts := strings.Split(string(decoded), "\x00")
+			if len(parts) == 3 && m.passwords[parts[1]] == parts[2] {
+				text.PrintfLine("235 authenticated")
+			} else {
+				text.PrintfLine("535 bad credentials")
+			}
+		case "MAIL":
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			address := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			recipient, _, _ = strings.Cut(address, "@")
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			if !m.dropMail {
+				if m.mailboxes == nil {
+					m.mailboxes = make(map[string][]string)
+				}
+				m.mailboxes[recipient] = append(m.mailboxes[recipient], strings.Join(lines, "\r\n"))
+			}
+			m.mu.Unlock()
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+func (m *fakeMailServer) servePOP3(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	text.PrintfLine("+OK fake POP3")
+	var username string
+	var loggedIn bool
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch strings.ToUpper(fields[0]) {
+		case "USER":
+			username = fields[1]
+			text.PrintfLine("+OK")
+		case "PASS":
+			if want, ok := m.passwords[username]; ok && want == fields[1] {
+				loggedIn = true
+				text.PrintfLine("+OK logged in")
+			} else {
+				text.PrintfLine("-ERR bad credentials")
+			}
+		case "STAT":
+			if !loggedIn {
+				text.PrintfLine("-ERR log in first")
+				continue
+			}
+			text.PrintfLine("+OK %d 0", len(m.messages(username)))
+		case "TOP":
+			n, _ := strconv.Atoi(fields[1])
+			messages := m.messages(username)
+			if !loggedIn || n < 1 || n > len(messages) {
+				text.PrintfLine("-ERR no such message")
+				continue
+			}
+			text.PrintfLine("+OK")
+			writer := text.DotWriter()
+			writer.Write([]byte(messages[n-1] + "\r\n"))
+			writer.Close()
+		case "QUIT":
+			text.PrintfLine("+OK bye")
+			return
+		default:
+			text.PrintfLine("-ERR unknown command")
+		}
+	}
+}
+
+func (m *fakeMailServer) serveIMAP(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "* OK fake IMAP\r\n")
+	var username string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		verb, arg, _ := strings.Cut(command, " ")
+		switch strings.ToUpper(verb) {
+		case "LOGIN":
+			user, password, _ := strings.Cut(arg, " ")
+			user, password = strings.Trim(user, `"`), strings.Trim(password, `"`)
+			if want, ok := m.passwords[user]; ok && want == password {
+				username = user
+				fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+			} else {
+				fmt.Fprintf(conn, "%s NO bad credentials\r\n", tag)
+			}
+		case "SELECT":
+			fmt.Fprintf(conn, "* %d EXISTS\r\n%s OK selected\r\n", len(m.messages(username)), tag)
+		case "SEARCH":
+			want := strings.Trim(strings.TrimPrefix(arg, "SUBJECT "), `"`)
+			var ids []string
+			for i, message := range m.messages(username) {
+				if strings.Contains(message, "Subject: NEST scoring check "+want) {
+					ids = append(ids, strconv.Itoa(i+1))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK search done\r\n", strings.Join(ids, " "), tag)
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+	}
+}
+
+// deliverSentMail makes every message sent through a mail server so far old enough to be looked for.
+func deliverSentMail(server mailServer) {
+	mailMu.Lock()
+	defer mailMu.Unlock()
+	for _, msg := range sentMail[server] {
+		msg.sent = msg.sent.Add(-mailDeliveryGrace)
+	}
+}
+
+func TestMailChecks(t *testing.T) {
+	server := &fakeMailServer{passwords: map[string]string{"alice": "CoffeeBean", "bob": "Chai"}}
+	smtpPort := server.listen(t, server.serveSMTP)
+	pop3Port := server.listen(t, server.servePOP3)
+	imapPort := server.listen(t, server.serveIMAP)
+
+	users := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(users, []byte("alice:CoffeeBean\nbob:Chai\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server1 := mailServer{teamID: 9001, vmName: "mail"}
+	target := func(port int, partial bool) CheckTarget {
+		return CheckTarget{TeamID: server1.teamID, VMName: server1.vmName, Address: "127.0.0.1", Service: enum.Service{Port: port, QFile: users, Award: 10, Partial: partial}}
+	}
+	ctx := context.Background()
+
+	// Nothing has been sent yet, so logging in is enough
+	if result := ScorePOP3(ctx, target(pop3Port, false)); result.Status != enum.StatusUp {
+		t.Fatalf("expected POP3 to be up before any mail was sent, got %+v", result)
+	}
+
+	// The message sent to a random user is found by both POP3 and IMAP, whoever it went to
+	if result := ScoreSMTPAuth(ctx, target(smtpPort, false)); result.Status != enum.StatusUp {
+		t.Fatalf("expected SMTP AUTH to be up, got %+v", result)
+	}
+	deliverSentMail(server1)
+	for name, check := range map[string]func() enum.CheckResult{
+		"POP3": func() enum.CheckResult { return ScorePOP3(ctx, target(pop3Port, false)) },
+		"IMAP": func() enum.CheckResult { return ScoreIMAP(ctx, target(imapPort, false)) },
+	} {
+		if result := check(); result.Status != enum.StatusUp || !strings.Contains(result.Evidence, "found NEST-") {
+			t.Fatalf("expected %s to find the message, got %+v", name, result)
+		}
+		// Once found it isn't looked for again
+		if result := check(); result.Status != enum.StatusUp || strings.Contains(result.Evidence, "found") {
+			t.Fatalf("expected %s to only log in once the message was found, got %+v", name, result)
+		}
+	}
+
+	// Mail that is accepted but never delivered takes the fetch checks down
+	server.mu.Lock()
+	server.dropMail = true
+	server.mu.Unlock()
+	if result := ScoreSMTP(ctx, target(smtpPort, false)); result.Status != enum.StatusUp {
+		t.Fatalf("expected SMTP to be up, got %+v", result)
+	}
+	deliverSentMail(server1)
+	if result := ScorePOP3(ctx, target(pop3Port, false)); result.Status != enum.StatusDown || !strings.Contains(result.Reason, "was not found over POP3") {
+		t.Fatalf("expected POP3 to be down without the message, got %+v", result)
+	}
+	if result := ScoreIMAP(ctx, target(imapPort, true)); result.Status != enum.StatusPartial || result.Points != 5 {
+		t.Fatalf("expected IMAP to be partially up without the message, got %+v", result)
+	}
+
+	// Bad credentials are refused
+	wrong := target(smtpPort, false)
+	wrong.Service.QFile, wrong.Service.User, wrong.Service.Password = "", "alice", "wrong"
+	if result := ScoreSMTPAuth(ctx, wrong); result.Status != enum.StatusDown {
+		t.Fatalf("expected SMTP AUTH with a wrong password to be down, got %+v", result)
+	}
+	if len(server.messages("alice"))+len(server.messages("bob")) != 1 {
+		t.Fatalf("expected exactly one delivered message, got %v and %v", server.messages("alice"), server.messages("bob"))
+	}
+}
+
+func TestNextDeliverableMail(t *testing.T) {
+	server := mailServer{teamID: 9002, vmName: "mail"}
+	recordSentMail(server, "alice", "first")
+	if _, ok := nextDeliverableMail(server, "POP3"); ok {
+		t.Fatal("expected a message that was just sent not to be looked for yet")
+	}
+
+	recordSentMail(server, "bob", "second")
+	deliverSentMail(server)
+	if msg, ok := nextDeliverableMail(server, "POP3"); !ok || msg.tag != "second" || msg.username != "bob" {
+		t.Fatalf("expected the newest message, got %+v, %v", msg, ok)
+	}
+	if _, ok := nextDeliverableMail(server, "POP3"); ok {
+		t.Fatal("expected older messages to be skipped once a newer one was looked for")
+	}
+	if msg, ok := nextDeliverableMail(server, "IMAP"); !ok || msg.tag != "second" {
+		t.Fatalf("expected IMAP to look for the message POP3 already did, got %+v, %v", msg, ok)
+	}
+
+	// Messages sent through another of the team's mail servers aren't looked for here
+	recordSentMail(mailServer{teamID: 9002, vmName: "mail2"}, "alice", "third")
+	deliverSentMail(mailServer{teamID: 9002, vmName: "mail2"})
+	if msg, ok := nextDeliverableMail(server, "IMAP"); ok {
+		t.Fatalf("expected nothing left to look for, got %+v", msg)
+	}
+}
+
+func TestMailChecksOnTwoServers(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(users, []byte("alice:CoffeeBean\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same team runs a mail server on two virtual machines, and the second one loses its mail
+	const teamID = 9003
+	ctx := context.Background()
+	ports := make(map[string]map[string]int) // virtual machine -> protocol -> port
+	tags := make(map[string]string)          // virtual machine -> the tag sent through it
+	for _, vmName := range []string{"mail1", "mail2"} {
+		server := &fakeMailServer{passwords: map[string]string{"alice": "CoffeeBean"}, dropMail: vmName == "mail2"}
+		ports[vmName] = map[string]int{
+			"SMTP": server.listen(t, server.serveSMTP),
+			"POP3": server.listen(t, server.servePOP3),
+			"IMAP": server.listen(t, server.serveIMAP),
+		}
+	}
+	target := func(vmName, protocol string) CheckTarget {
+		return CheckTarget{TeamID: teamID, VMName: vmName, Address: "127.0.0.1", Service: enum.Service{Port: ports[vmName][protocol], QFile: users, Award: 10}}
+	}
+
+	for _, vmName := range []string{"mail1", "mail2"} {
+		result := ScoreSMTP(ctx, target(vmName, "SMTP"))
+		if result.Status != enum.StatusUp {
+			t.Fatalf("expected SMTP on %s to be up, got %+v", vmName, result)
+		}
+		tags[vmName] = strings.Fields(result.Evidence)[1]
+		deliverSentMail(mailServer{teamID: teamID, vmName: vmName})
+	}
+
+	// Each server's POP3 and IMAP checks look for the message sent through that server only
+	checks := map[string]func(context.Context, CheckTarget) enum.CheckResult{"POP3": ScorePOP3, "IMAP": ScoreIMAP}
+	for protocol, check := range checks {
+		if result := check(ctx, target("mail1", protocol)); result.Status != enum.StatusUp || !strings.Contains(result.Evidence, "found "+tags["mail1"]) {
+			t.Fatalf("expected %s on mail1 to find %s, got %+v", protocol, tags["mail1"], result)
+		}
+		if result := check(ctx, target("mail2", protocol)); result.Status != enum.StatusDown || !strings.Contains(result.Reason, tags["mail2"]) {
+			t.Fatalf("expected %s on mail2 to miss %s, got %+v", protocol, tags["mail2"], result)
+		}
+	}
+}
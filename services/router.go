@@ -1,75 +1,119 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/LTSEC/NEST/enum"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
-// Checks if a router is pingable via ICMP
-func ScoreRouterICMP(service enum.Service, address string) (int, bool, error) {
-	// Listen for ICMP packets. "ip4:icmp" indicates IPv4 ICMP.
-	c, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+// icmpSequence hands out a unique sequence number to every echo request, since checks run
+// concurrently and each listener sees every reply sent to the engine.
+var icmpSequence atomic.Uint32
+
+// icmpFamily holds what differs between pinging over IPv4 and IPv6.
+type icmpFamily struct {
+	network   string    // The network to listen on
+	listen    string    // The address to listen on
+	protocol  int       // The IANA protocol number, for parsing replies
+	echo      icmp.Type // The echo request type
+	echoReply icmp.Type // The echo reply type
+}
+
+var (
+	icmpv4 = icmpFamily{"ip4:icmp", "0.0.0.0", ipv4.ICMPTypeEcho.Protocol(), ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply}
+	icmpv6 = icmpFamily{"ip6:ipv6-icmp", "::", ipv6.ICMPTypeEchoRequest.Protocol(), ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply}
+)
+
+// Checks if a router is pingable via ICMP, or ICMPv6 when its address is IPv6
+func ScoreRouterICMP(ctx context.Context, target CheckTarget) enum.CheckResult {
+	// Resolve the IP address, which decides whether to ping over IPv4 or IPv6.
+	dst, err := net.ResolveIPAddr("ip", target.Address)
+	if err != nil {
+		return Fail(fmt.Errorf("failed to resolve IP address %s: %w", target.Address, err))
+	}
+	family := icmpv4
+	if dst.IP.To4() == nil {
+		family = icmpv6
+	}
+
+	// Listen for ICMP packets of the address's family.
+	c, err := icmp.ListenPacket(family.network, family.listen)
 	if err != nil {
-		return 0, false, fmt.Errorf("could not listen for ICMP packets: %w", err)
+		return Fail(fmt.Errorf("could not listen for ICMP packets: %w", err))
 	}
 	defer c.Close()
 
 	// Create an ICMP Echo Request message.
 	// The identifier is typically set to a value unique to the process (e.g., the PID).
+	echo := &icmp.Echo{
+		ID:   os.Getpid() & 0xffff, // Use lower 16 bits of the PID.
+		Seq:  int(icmpSequence.Add(1) & 0xffff),
+		Data: []byte("PING"),
+	}
 	message := icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
+		Type: family.echo,
 		Code: 0,
-		Body: &icmp.Echo{
-			ID:   os.Getpid() & 0xffff, // Use lower 16 bits of the PID.
-			Seq:  1,
-			Data: []byte("PING"),
-		},
+		Body: echo,
 	}
+	// The kernel fills in the ICMPv6 checksum, so no pseudo header is needed
 	messageBytes, err := message.Marshal(nil)
 	if err != nil {
-		return 0, false, fmt.Errorf("could not marshal ICMP message: %w", err)
-	}
-
-	// Resolve the IP address.
-	dst, err := net.ResolveIPAddr("ip4", address)
-	if err != nil {
-		return 0, false, fmt.Errorf("failed to resolve IP address %s: %w", address, err)
+		return Fail(fmt.Errorf("could not marshal ICMP message: %w", err))
 	}
 
 	// Send the ICMP Echo Request.
 	if _, err := c.WriteTo(messageBytes, dst); err != nil {
-		return 0, false, fmt.Errorf("failed to send ICMP request: %w", err)
+		return Fail(fmt.Errorf("failed to send ICMP request: %w", err))
 	}
 
-	// Set a deadline for reading the reply.
-	if err := c.SetReadDeadline(time.Now().Add(router_timeout * time.Millisecond)); err != nil {
-		return 0, false, fmt.Errorf("failed to set read deadline: %w", err)
+	// Set a deadline for reading the reply, cutting it short if the check's context ends first.
+	deadline := time.Now().Add(router_timeout * time.Millisecond)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.SetReadDeadline(deadline); err != nil {
+		return Fail(fmt.Errorf("failed to set read deadline: %w", err))
 	}
 
 	// Buffer to hold the reply.
 	reply := make([]byte, 1500)
-	n, _, err := c.ReadFrom(reply)
-	if err != nil {
-		return 0, false, fmt.Errorf("error reading ICMP reply: %w", err)
-	}
+	for {
+		n, peer, err := c.ReadFrom(reply)
+		if err != nil {
+			return Fail(fmt.Errorf("error reading ICMP reply: %w", err))
+		}
 
-	// Parse the ICMP message.
-	parsedMessage, err := icmp.ParseMessage(1, reply[:n])
-	if err != nil {
-		return 0, false, fmt.Errorf("failed to parse ICMP message: %w", err)
-	}
+		// Other checks and hosts share the socket's view of incoming ICMP, so skip anything that
+		// isn't from the router, and anything that doesn't parse, until the deadline
+		if peer.String() != dst.String() {
+			continue
+		}
+		parsedMessage, err := icmp.ParseMessage(family.protocol, reply[:n])
+		if err != nil {
+			continue
+		}
+		if body, ok := parsedMessage.Body.(*icmp.Echo); ok && (body.ID != echo.ID || body.Seq != echo.Seq) {
+			continue
+		}
 
-	// Check if the reply is an Echo Reply.
-	switch parsedMessage.Type {
-	case ipv4.ICMPTypeEchoReply:
-		return service.Award, true, nil
-	default:
-		return 0, false, fmt.Errorf("unexpected ICMP message type: %v", parsedMessage.Type)
+		// Check if the reply is an Echo Reply.
+		switch parsedMessage.Type {
+		case family.echoReply:
+			return Pass(target, fmt.Sprintf("echo reply from %s", peer))
+		case family.echo:
+			continue // our own request looped back
+		case ipv6.ICMPTypeNeighborSolicitation, ipv6.ICMPTypeNeighborAdvertisement, ipv6.ICMPTypeRouterAdvertisement, ipv6.ICMPTypeRedirect:
+			continue // IPv6 neighbor discovery, which can arrive before the reply
+		default:
+			return Fail(fmt.Errorf("unexpected ICMP message type: %v", parsedMessage.Type))
+		}
 	}
 }
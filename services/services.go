@@ -1,9 +1,12 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/LTSEC/NEST/enum"
@@ -18,6 +21,8 @@ const (
 	sql_timeout    = 250
 	dns_timeout    = 500
 	web_timeout    = 1500
+	mail_timeout   = 1500
+	ldap_timeout   = 1000
 )
 
 var (
@@ -25,64 +30,274 @@ var (
 	randseed int
 	// Get official virtual machines
 	cfg *enum.YamlConfig
+	// Guards cfg, which is replaced when the configuration is reloaded
+	cfgMu sync.RWMutex
 )
 
-var ScoringDispatch = map[string]func(service enum.Service, address string) (int, bool, error){
-	//"ftp": ScoreFTP,.
-	// add more as needed
-	"ftp":        ScoreFTP,        // General FTP (if the connection exists)
-	"ftplogin":   ScoreFTPLogin,   // Login
-	"ftpread":    ScoreFTPRead,    // Reading files
-	"ftpwrite":   ScoreFTPWrite,   // Writing files
-	"ssh":        ScoreSSHLogin,   // Logging in with SSH
-	"web80":      ScoreWeb80,      // Insecure connections
-	"webssl":     ScoreWebSSLTLS,  // Secure connections
-	"webcontent": ScoreWebContent, // Check content against prepared content
-	"routericmp": ScoreRouterICMP, // Check if the router can be pinged via ICMP and the engine can hear back
-	"dnsexternalfwd": ScoreDNSExternalFwd,
-	"dnsexternalrev": ScoreDNSExternalRev,
-	"dnsinternalfwd": ScoreDNSInternalFwd,
-	"dnsinternalrev": ScoreDNSInternalRev,
+// CheckTarget is everything a checker needs to know about what it is scoring.
+type CheckTarget struct {
+	TeamID      int               // The ID of the team that owns the service
+	VMName      string            // The name of the virtual machine the service runs on
+	Address     string            // The resolved address of the team's virtual machine
+	Service     enum.Service      // The service's configuration from the yaml
+	Credentials map[string]string // Passwords the team has changed through password change requests, by username
+}
+
+// A Checker scores a single team's service. Implementations must return promptly once ctx is done.
+type Checker interface {
+	Check(ctx context.Context, target CheckTarget) enum.CheckResult
+}
+
+// CheckerFunc adapts an ordinary function into a Checker.
+type CheckerFunc func(ctx context.Context, target CheckTarget) enum.CheckResult
+
+// Check calls f(ctx, target).
+func (f CheckerFunc) Check(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return f(ctx, target)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Checker{
+		// add more as needed
+		"ftp":            CheckerFunc(ScoreFTP),        // General FTP (if the connection exists)
+		"ftplogin":       CheckerFunc(ScoreFTPLogin),   // Login
+		"ftpread":        CheckerFunc(ScoreFTPRead),    // Reading files
+		"ftpwrite":       CheckerFunc(ScoreFTPWrite),   // Writing files
+		"ssh":            CheckerFunc(ScoreSSHLogin),   // Logging in with SSH
+		"sshcommand":     CheckerFunc(ScoreSSHCommand), // Running commands over SSH and checking their output
+		"web80":          CheckerFunc(ScoreWeb80),      // Insecure connections
+		"webssl":         CheckerFunc(ScoreWebSSLTLS),  // Secure connections
+		"webcontent":     CheckerFunc(ScoreWebContent), // Check content against prepared content
+		"routericmp":     CheckerFunc(ScoreRouterICMP), // Check if the router can be pinged via ICMP and the engine can hear back
+		"dnsexternalfwd": CheckerFunc(ScoreDNSExternalFwd),
+		"dnsexternalrev": CheckerFunc(ScoreDNSExternalRev),
+		"dnsinternalfwd": CheckerFunc(ScoreDNSInternalFwd),
+		"dnsinternalrev": CheckerFunc(ScoreDNSInternalRev),
+		"mysql":          CheckerFunc(ScoreMySQL),    // Log in to MySQL and run the service's queries
+		"postgres":       CheckerFunc(ScorePostgres), // Log in to PostgreSQL and run the service's queries
+		"smtp":           CheckerFunc(ScoreSMTP),     // Deliver a tagged message to a user
+		"smtpauth":       CheckerFunc(ScoreSMTPAuth), // Authenticate as a user and send a tagged message
+		"pop3":           CheckerFunc(ScorePOP3),     // Log in and find the last tagged message over POP3
+		"imap":           CheckerFunc(ScoreIMAP),     // Log in and find the last tagged message over IMAP
+		"ldap":           CheckerFunc(ScoreLDAP),     // Bind as a user and check a directory search
+	}
+)
+
+// Register makes a checker available under the given service name, so plugins can add
+// their own service types. It returns an error if the name is empty or already taken.
+func Register(name string, checker Checker) error {
+	if name == "" || checker == nil {
+		return fmt.Errorf("a checker needs both a name and an implementation")
+	}
+	if strings.Contains(name, "_") {
+		return fmt.Errorf("checker name '%s' cannot contain underscores", name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		return fmt.Errorf("a checker named '%s' is already registered", name)
+	}
+	registry[name] = checker
+	return nil
+}
+
+// Lookup returns the checker registered under the given service name.
+func Lookup(name string) (Checker, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	checker, ok := registry[name]
+	return checker, ok
+}
+
+// Registered returns the names of every registered checker, sorted.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunCheck looks up the checker for the given service name, runs it against the target,
+// and records how long it took.
+func RunCheck(ctx context.Context, name string, target CheckTarget) (enum.CheckResult, error) {
+	checker, ok := Lookup(name)
+	if !ok {
+		return enum.CheckResult{}, fmt.Errorf("unknown service %s", name)
+	}
+
+	started := time.Now()
+	result := checker.Check(ctx, target)
+	result.Latency = time.Since(started)
+
+	// A check cut off by its context is always down, whatever the checker reported
+	if ctx.Err() != nil && result.Status != enum.StatusDown {
+		result = Fail(fmt.Errorf("check was cancelled: %w", ctx.Err()))
+		result.Latency = time.Since(started)
+	}
+
+	return result, nil
+}
+
+// Pass builds a passing result that awards the service's full points.
+func Pass(target CheckTarget, evidence string) enum.CheckResult {
+	return enum.CheckResult{
+		Status:   enum.StatusUp,
+		Points:   target.Service.Award,
+		Reason:   "check passed",
+		Evidence: evidence,
+	}
+}
+
+// Tally builds the result of a check made up of several parts, given the evidence from the parts
+// that passed and the errors from the ones that didn't. If every part passed the service gets its
+// full points. Otherwise the check fails, unless the service awards partial points and at least
+// one part passed, in which case it earns the matching share of its award.
+func Tally(target CheckTarget, passed []string, failures []error) enum.CheckResult {
+	total := len(passed) + len(failures)
+	if len(failures) == 0 {
+		return Pass(target, strings.Join(passed, "; "))
+	}
+
+	reasons := make([]string, len(failures))
+	for i, failure := range failures {
+		reasons[i] = failure.Error()
+	}
+	if !target.Service.Partial || len(passed) == 0 {
+		return enum.CheckResult{
+			Status:   enum.StatusDown,
+			Reason:   strings.Join(reasons, "; "),
+			Evidence: strings.Join(passed, "; "),
+		}
+	}
+
+	return enum.CheckResult{
+		Status:   enum.StatusPartial,
+		Points:   target.Service.Award * len(passed) / total,
+		Reason:   fmt.Sprintf("%d of %d parts passed: %s", len(passed), total, strings.Join(reasons, "; ")),
+		Evidence: strings.Join(passed, "; "),
+	}
+}
+
+// Fail builds a failing result, using the error as the reason the service is down.
+func Fail(err error) enum.CheckResult {
+	return enum.CheckResult{
+		Status: enum.StatusDown,
+		Reason: err.Error(),
+	}
 }
 
 func Initalize(gameConfig *enum.YamlConfig) {
 	// Set the random seed for any random operations
 	rand.Seed((uint64)(time.Now().Unix()))
+	SetConfig(gameConfig)
+}
+
+// SetConfig replaces the configuration the checkers use, when the configuration is reloaded.
+// Files loaded for the previous configuration, such as expected web pages and FTP files, are
+// forgotten, so they are read again and changes made to them take effect.
+func SetConfig(gameConfig *enum.YamlConfig) {
+	cfgMu.Lock()
 	cfg = gameConfig
+	cfgMu.Unlock()
+
+	webMu.Lock()
+	clear(siteInfo)
+	webMu.Unlock()
+	ftpMu.Lock()
+	clear(ftpFiles)
+	ftpMu.Unlock()
 }
 
-// ChooseRandomUser reads the file at `dir`, which contains lines
-// formatted as "username:password", picks one user at random, and
-// returns the parsed username and password.
-func ChooseRandomUser(dir string) (string, string, error) {
-	data, err := os.ReadFile(dir)
+// officialDNS returns the address of the official DNS server, which external lookups are sent to.
+func officialDNS() string {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg.OfficialVirtualMachines["dns"].IP
+}
+
+// chooseCredentials returns the service's single configured user if there is one,
+// otherwise a random user from its query file. If the team has changed that user's
+// password through a password change request, the new password is used instead.
+func chooseCredentials(target CheckTarget) (string, string, error) {
+	username, password := target.Service.User, target.Service.Password
+	if username == "" {
+		var err error
+		if username, password, err = ChooseRandomUser(target.Service.QFile); err != nil {
+			return "", "", err
+		}
+	}
+	if changed, ok := target.Credentials[username]; ok {
+		password = changed
+	}
+	return username, password, nil
+}
+
+// Credential is a username and password a checker logs in with.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// ReadUsers reads a query file of "username:password" lines, skipping blank lines.
+func ReadUsers(path string) ([]Credential, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return "", "", fmt.Errorf("could not read users file: %v", err)
+		return nil, fmt.Errorf("could not read users file: %v", err)
 	}
 
-	lines := strings.Split(string(data), "\n")
-	var validLines []string
-	for _, line := range lines {
+	var users []Credential
+	for _, line := range strings.Split(string(data), "\n") {
 		line = strings.TrimSpace(line)
-		if line != "" {
-			validLines = append(validLines, line)
+		if line == "" {
+			continue
+		}
+		username, password, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid user format: %s", line)
 		}
+		users = append(users, Credential{Username: username, Password: password})
 	}
+	return users, nil
+}
 
-	if len(validLines) == 0 {
-		return "", "", fmt.Errorf("no valid 'username:password' lines in %s", dir)
+// ServiceUsernames returns every username a service's checks may log in as: its single
+// configured user, or the users in its query file. Services that don't log in return none.
+func ServiceUsernames(service enum.Service) ([]string, error) {
+	if service.User != "" {
+		return []string{service.User}, nil
 	}
+	if service.QFile == "" {
+		return nil, nil
+	}
+	users, err := ReadUsers(service.QFile)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	return usernames, nil
+}
 
-	randomIndex := rand.Intn(len(validLines))
-	userLine := validLines[randomIndex]
-
-	// Parse username and password
-	parts := strings.SplitN(userLine, ":", 2)
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid user format: %s", userLine)
+// ChooseRandomUser reads the file at `dir`, which contains lines
+// formatted as "username:password", picks one user at random, and
+// returns the parsed username and password.
+func ChooseRandomUser(dir string) (string, string, error) {
+	users, err := ReadUsers(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if len(users) == 0 {
+		return "", "", fmt.Errorf("no valid 'username:password' lines in %s", dir)
 	}
-	username := parts[0]
-	password := parts[1]
 
-	return username, password, nil
+	user := users[rand.Intn(len(users))]
+	return user.Username, user.Password, nil
 }
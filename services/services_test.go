@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+)
+
+func TestRegisterAndRunCheck(t *testing.T) {
+	slow := CheckerFunc(func(ctx context.Context, target CheckTarget) enum.CheckResult {
+		select {
+		case <-time.After(20 * time.Millisecond):
+			return Pass(target, fmt.Sprintf("team %d at %s", target.TeamID, target.Address))
+		case <-ctx.Done():
+			return Fail(ctx.Err())
+		}
+	})
+
+	if err := Register("testslow", slow); err != nil {
+		t.Fatalf("registering a new checker failed: %v", err)
+	}
+	if err := Register("testslow", slow); err == nil {
+		t.Fatal("registering the same name twice should fail")
+	}
+	if err := Register("ftp", slow); err == nil {
+		t.Fatal("overriding a built in checker should fail")
+	}
+
+	target := CheckTarget{TeamID: 3, Address: "192.168.3.5", Service: enum.Service{Port: 1, Award: 5}}
+	result, err := RunCheck(context.Background(), "testslow", target)
+	if err != nil {
+		t.Fatalf("RunCheck returned an error: %v", err)
+	}
+	if result.Status != enum.StatusUp || result.Points != 5 {
+		t.Fatalf("expected an up result worth 5 points, got %+v", result)
+	}
+	if result.Latency < 20*time.Millisecond {
+		t.Fatalf("expected latency to be recorded, got %v", result.Latency)
+	}
+	if result.Evidence != "team 3 at 192.168.3.5" {
+		t.Fatalf("unexpected evidence %q", result.Evidence)
+	}
+
+	// A cancelled check should come back down
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	result, err = RunCheck(ctx, "testslow", target)
+	if err != nil {
+		t.Fatalf("RunCheck returned an error: %v", err)
+	}
+	if result.Status != enum.StatusDown || result.Points != 0 {
+		t.Fatalf("expected a cancelled check to be down, got %+v", result)
+	}
+
+	if _, err := RunCheck(context.Background(), "doesnotexist", target); err == nil {
+		t.Fatal("running an unknown checker should fail")
+	}
+}
+
+func TestTally(t *testing.T) {
+	target := CheckTarget{TeamID: 1, Service: enum.Service{Port: 53, Award: 10}}
+	passed := []string{"a", "b", "c"}
+	failures := []error{fmt.Errorf("d failed")}
+
+	// Everything passing is a full pass either way
+	if result := Tally(target, passed, nil); result.Status != enum.StatusUp || result.Points != 10 {
+		t.Fatalf("expected a full pass, got %+v", result)
+	}
+
+	// Without partial points any failure fails the check
+	if result := Tally(target, passed, failures); result.Status != enum.StatusDown || result.Points != 0 {
+		t.Fatalf("expected a failure without partial points, got %+v", result)
+	}
+
+	// With partial points the passing share is awarded
+	target.Service.Partial = true
+	result := Tally(target, passed, failures)
+	if result.Status != enum.StatusPartial || result.Points != 7 {
+		t.Fatalf("expected 7 partial points, got %+v", result)
+	}
+	if result.Reason != "3 of 4 parts passed: d failed" {
+		t.Fatalf("unexpected reason %q", result.Reason)
+	}
+
+	// Nothing passing is still down
+	if result := Tally(target, nil, failures); result.Status != enum.StatusDown {
+		t.Fatalf("expected a failure when nothing passed, got %+v", result)
+	}
+}
+
+func TestChooseCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(path, []byte("alice:CoffeeBean\n\nbob:tea:time\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	usernames, err := ServiceUsernames(enum.Service{QFile: path})
+	if err != nil || strings.Join(usernames, ",") != "alice,bob" {
+		t.Fatalf("unexpected usernames %v, %v", usernames, err)
+	}
+
+	// A single configured user is used as is, unless the team changed its password
+	target := CheckTarget{Service: enum.Service{User: "henry", Password: "pass"}}
+	if user, pass, _ := chooseCredentials(target); user != "henry" || pass != "pass" {
+		t.Fatalf("expected the configured user, got %s:%s", user, pass)
+	}
+	target.Credentials = map[string]string{"henry": "changed"}
+	if user, pass, _ := chooseCredentials(target); user != "henry" || pass != "changed" {
+		t.Fatalf("expected the changed password, got %s:%s", user, pass)
+	}
+
+	// Users from the query file pick up their changed passwords too
+	target = CheckTarget{Service: enum.Service{QFile: path}, Credentials: map[string]string{"alice": "Espresso", "bob": "Chai"}}
+	for i := 0; i < 10; i++ {
+		user, pass, err := chooseCredentials(target)
+		if err != nil || target.Credentials[user] != pass {
+			t.Fatalf("expected %s's changed password, got %q, %v", user, pass, err)
+		}
+	}
+
+	// Bad lines are reported
+	os.WriteFile(path, []byte("alice:CoffeeBean\nbroken\n"), 0644)
+	if _, err := ReadUsers(path); err == nil {
+		t.Fatal("expected a line without a password to be rejected")
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	domains := filepath.Join(dir, "domains")
+	users := filepath.Join(dir, "users.txt")
+	os.WriteFile(domains, []byte("10.20.1.<t> www.team<t>.com 192.168.<t>.5 www.team<t>.net\n"), 0644)
+	os.WriteFile(users, []byte("alice:CoffeeBean\n"), 0644)
+
+	if problems := CheckFiles("dnsexternalfwd", enum.Service{QFile: domains}); len(problems) != 0 {
+		t.Fatalf("expected a well formed domains file to pass, got %v", problems)
+	}
+	if problems := CheckFiles("ssh", enum.Service{QFile: users}); len(problems) != 0 {
+		t.Fatalf("expected a well formed users file to pass, got %v", problems)
+	}
+
+	// A users file isn't a domains file, and a missing file is missing whatever it holds
+	if problems := CheckFiles("dnsinternalrev", enum.Service{QFile: users}); len(problems) != 1 {
+		t.Fatalf("expected the users file to be rejected as a domains file, got %v", problems)
+	}
+	if problems := CheckFiles("dnsinternalrev", enum.Service{}); len(problems) != 1 {
+		t.Fatalf("expected a DNS service without a query file to be reported, got %v", problems)
+	}
+	missing := filepath.Join(dir, "missing")
+	service := enum.Service{
+		KeyFile: missing,
+		Queries: missing,
+		Teams:   map[string]enum.ServiceOverride{"1": {QFile: domains}},
+	}
+	if problems := CheckFiles("ftpread", service); len(problems) != 4 {
+		t.Fatalf("expected the query_dir, key, queries and team query file to be reported, got %v", problems)
+	}
+
+	// Services that don't read their query file aren't held to any format
+	if problems := CheckFiles("web80", enum.Service{QFile: domains}); len(problems) != 0 {
+		t.Fatalf("expected web80's unused query file to be ignored, got %v", problems)
+	}
+
+	// Team query directories are checked like the service's
+	service = enum.Service{QDir: dir, Teams: map[string]enum.ServiceOverride{"1": {QDir: missing}}}
+	if problems := CheckFiles("ftpwrite", service); len(problems) != 1 || !strings.Contains(problems[0].Error(), "team '1'") {
+		t.Fatalf("expected the team's missing query directory to be reported, got %v", problems)
+	}
+}
+
+func TestCheckOverride(t *testing.T) {
+	if problems := CheckOverride("webcontent", enum.ServiceOverride{QFile: "team1.html", Port: 8080}); len(problems) != 0 {
+		t.Fatalf("expected a webcontent query file override to be allowed, got %v", problems)
+	}
+	if problems := CheckOverride("ftpread", enum.ServiceOverride{QDir: "team1", User: "ftp"}); len(problems) != 0 {
+		t.Fatalf("expected an FTP query directory and user override to be allowed, got %v", problems)
+	}
+
+	// Overrides the service wouldn't use are refused
+	if problems := CheckOverride("web80", enum.ServiceOverride{User: "admin", QFile: "users.txt", QDir: "files"}); len(problems) != 3 {
+		t.Fatalf("expected the user, query file and query directory to be refused, got %v", problems)
+	}
+	if problems := CheckOverride("ssh", enum.ServiceOverride{QDir: "files"}); len(problems) != 1 {
+		t.Fatalf("expected the query directory to be refused for ssh, got %v", problems)
+	}
+}
+
+func TestLoadFilesPerPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, team := range []string{"team1", "team2"} {
+		os.Mkdir(filepath.Join(dir, team), 0755)
+		os.WriteFile(filepath.Join(dir, team, "flag.txt"), []byte(team+" flag"), 0644)
+		os.WriteFile(filepath.Join(dir, team+".html"), []byte("<p>\n"+team+"</p>"), 0644)
+	}
+
+	// Each team's files are kept apart, rather than the first one loaded being used for everyone
+	for _, team := range []string{"team1", "team2"} {
+		page, err := LoadWebFiles(filepath.Join(dir, team+".html"))
+		if err != nil || string(page) != "<p>"+team+"</p>" {
+			t.Fatalf("expected %s's page, got %q, %v", team, page, err)
+		}
+		files, err := LoadFTPFiles(filepath.Join(dir, team))
+		if err != nil || string(files["flag.txt"]) != team+" flag" {
+			t.Fatalf("expected %s's FTP files, got %q, %v", team, files, err)
+		}
+	}
+
+	// A file that couldn't be read is tried again
+	late := filepath.Join(dir, "late.html")
+	if _, err := LoadWebFiles(late); err == nil {
+		t.Fatal("expected a missing page to fail to load")
+	}
+	os.WriteFile(late, []byte("late"), 0644)
+	if page, err := LoadWebFiles(late); err != nil || string(page) != "late" {
+		t.Fatalf("expected the page to load once it exists, got %q, %v", page, err)
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LTSEC/NEST/enum"
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// openSQL connects to a team's database server with the given driver ("mysql" or "postgres")
+// and confirms the login works before returning the connection.
+func openSQL(ctx context.Context, driver, address string, port int, username, password, database string) (*sql.DB, error) {
+	hostAddr := net.JoinHostPort(address, strconv.Itoa(port))
+
+	var dsn string
+	switch driver {
+	case "mysql":
+		conf := mysql.NewConfig()
+		conf.User = username
+		conf.Passwd = password
+		conf.Net = "tcp"
+		conf.Addr = hostAddr
+		conf.DBName = database
+		conf.Timeout = sql_timeout * time.Millisecond
+		conf.AllowNativePasswords = true
+		dsn = conf.FormatDSN()
+	case "postgres":
+		dsn = (&url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(username, password),
+			Host:     hostAddr,
+			Path:     "/" + database,
+			RawQuery: "sslmode=disable",
+		}).String()
+	default:
+		return nil, fmt.Errorf("unsupported SQL driver %s", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s connection: %v", driver, err)
+	}
+	db.SetMaxOpenConns(1)
+
+	pingCtx, cancel := context.WithTimeout(ctx, sql_timeout*time.Millisecond)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to log in to %s as %s: %v", driver, username, err)
+	}
+
+	return db, nil
+}
+
+// loadSQLQueries reads a file of SQL statements separated by semicolons, such as
+// queries/schemas/requests.sql, skipping blank statements and "--" comment lines.
+func loadSQLQueries(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query file: %v", err)
+	}
+
+	var queries []string
+	for _, statement := range strings.Split(string(data), ";") {
+		var lines []string
+		for _, line := range strings.Split(statement, "\n") {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				lines = append(lines, trimmed)
+			}
+		}
+		if len(lines) > 0 {
+			queries = append(queries, strings.Join(lines, " "))
+		}
+	}
+
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries found in %s", path)
+	}
+	return queries, nil
+}
+
+// loadSQLReference reads the expected result of each query, one line per query in the same order.
+// Each line is one of:
+//
+//	rows:<n>       the query must return exactly n rows
+//	sha256:<hex>   the query's rows must hash to the given value (see hashSQLRows)
+//	-              the query only has to succeed
+//
+// Blank lines and lines starting with "#" are ignored.
+func loadSQLReference(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference file: %v", err)
+	}
+
+	var expectations []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expectations = append(expectations, line)
+	}
+	return expectations, nil
+}
+
+// runSQLQuery runs a query and returns how many rows it produced along with every row
+// rendered as a tab separated string.
+func runSQLQuery(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var rendered []string
+	values := make([]sql.RawBytes, len(columns))
+	scanArgs := make([]any, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+		fields := make([]string, len(values))
+		for i, value := range values {
+			fields[i] = string(value)
+		}
+		rendered = append(rendered, strings.Join(fields, "\t"))
+	}
+
+	return rendered, rows.Err()
+}
+
+// hashSQLRows hashes a query's rendered rows. The rows are sorted first so the hash doesn't
+// depend on the order the server returns them in.
+func hashSQLRows(rows []string) string {
+	sorted := append([]string(nil), rows...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// compareSQLResult checks a query's rows against a single line of the reference file.
+func compareSQLResult(expectation string, rows []string) error {
+	kind, value, _ := strings.Cut(expectation, ":")
+	switch strings.ToLower(kind) {
+	case "-":
+		return nil
+	case "rows":
+		want, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid row count %q in reference file", value)
+		}
+		if len(rows) != want {
+			return fmt.Errorf("returned %d rows, expected %d", len(rows), want)
+		}
+	case "sha256":
+		if got := hashSQLRows(rows); !strings.EqualFold(got, strings.TrimSpace(value)) {
+			return fmt.Errorf("rows hashed to %s, expected %s", got, value)
+		}
+	default:
+		return fmt.Errorf("unknown reference %q, expected rows:<n>, sha256:<hex> or -", expectation)
+	}
+	return nil
+}
+
+// scoreSQL logs into a team's database server, runs the service's queries, and compares the
+// results to the service's reference file if one is configured.
+func scoreSQL(ctx context.Context, target CheckTarget, driver string) enum.CheckResult {
+	service := target.Service
+
+	// Either the single configured user or one from the related query file
+	username, password, err := chooseCredentials(target)
+	if err != nil {
+		return Fail(err)
+	}
+
+	db, err := openSQL(ctx, driver, target.Address, service.Port, username, password, service.Database)
+	if err != nil {
+		return Fail(err)
+	}
+	defer db.Close()
+
+	// Without any queries, a successful login is enough
+	if service.Queries == "" {
+		return Pass(target, fmt.Sprintf("logged in as %s", username))
+	}
+
+	queries, err := loadSQLQueries(service.Queries)
+	if err != nil {
+		return Fail(err)
+	}
+
+	var expectations []string
+	if service.Reference != "" {
+		if expectations, err = loadSQLReference(service.Reference); err != nil {
+			return Fail(err)
+		}
+	}
+
+	var evidence []string
+	for i, query := range queries {
+		rows, err := runSQLQuery(ctx, db, query)
+		if err != nil {
+			return Fail(fmt.Errorf("query %d (%s) failed: %v", i+1, query, err))
+		}
+		if i < len(expectations) {
+			if err := compareSQLResult(expectations[i], rows); err != nil {
+				return Fail(fmt.Errorf("query %d (%s) %v", i+1, query, err))
+			}
+		}
+		evidence = append(evidence, fmt.Sprintf("%s -> %d rows", query, len(rows)))
+	}
+
+	return Pass(target, fmt.Sprintf("logged in as %s; %s", username, strings.Join(evidence, "; ")))
+}
+
+// ScoreMySQL logs into a MySQL (or MariaDB) server and runs the service's queries against it.
+func ScoreMySQL(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return scoreSQL(ctx, target, "mysql")
+}
+
+// ScorePostgres logs into a PostgreSQL server and runs the service's queries against it.
+func ScorePostgres(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return scoreSQL(ctx, target, "postgres")
+}
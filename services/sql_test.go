@@ -0,0 +1,52 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSQLQueries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.sql")
+	contents := "-- users first\nSELECT *\n  FROM users;\n\nSELECT * FROM posts;\n;\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	queries, err := loadSQLQueries(path)
+	if err != nil {
+		t.Fatalf("loadSQLQueries failed: %v", err)
+	}
+	want := []string{"SELECT * FROM users", "SELECT * FROM posts"}
+	if len(queries) != len(want) {
+		t.Fatalf("expected %d queries, got %d: %q", len(want), len(queries), queries)
+	}
+	for i := range want {
+		if queries[i] != want[i] {
+			t.Errorf("query %d: got %q, want %q", i, queries[i], want[i])
+		}
+	}
+}
+
+func TestCompareSQLResult(t *testing.T) {
+	rows := []string{"2\tbob", "1\talice"}
+	reordered := []string{"1\talice", "2\tbob"}
+
+	cases := []struct {
+		expectation string
+		ok          bool
+	}{
+		{"-", true},
+		{"rows:2", true},
+		{"rows:3", false},
+		{"sha256:" + hashSQLRows(reordered), true},
+		{"sha256:" + hashSQLRows(rows[:1]), false},
+		{"md5:abc", false},
+	}
+	for _, c := range cases {
+		err := compareSQLResult(c.expectation, rows)
+		if (err == nil) != c.ok {
+			t.Errorf("compareSQLResult(%q) = %v, expected ok=%v", c.expectation, err, c.ok)
+		}
+	}
+}
@@ -1,62 +1,183 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/LTSEC/NEST/enum"
 	"golang.org/x/crypto/ssh"
 )
 
-// Establishes an SSH connection based on the given hostname, port, and user/password combo
-// For now, does not work with ssh keys
-func establishSSHConnection(hostname string, port string, username string, password string) (bool, error) {
+// sshAuthMethods builds the ways to authenticate as a user: the service's private key if it has
+// one, and the password if one was given.
+func sshAuthMethods(service enum.Service, password string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if service.KeyFile != "" {
+		keyBytes, err := os.ReadFile(service.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read SSH key: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse SSH key %s: %v", service.KeyFile, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no password or key_file to authenticate with")
+	}
+	return methods, nil
+}
+
+// Establishes an SSH connection based on the given hostname, port, user, and authentication methods.
+// The connection is torn down if ctx is cancelled while it is in use; call the returned stop
+// function once finished with the client.
+func establishSSHConnection(ctx context.Context, hostname string, port string, username string, auth []ssh.AuthMethod) (*ssh.Client, func() bool, error) {
 	conf := &ssh.ClientConfig{
 		User:            username,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // ignoring host keys for now
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-		},
-		Timeout: ssh_timeout * time.Millisecond,
+		Auth:            auth,
+		Timeout:         ssh_timeout * time.Millisecond,
 	}
 
-	hostAddr := hostname + ":" + port
-	conn, err := ssh.Dial("tcp", hostAddr, conf)
+	hostAddr := net.JoinHostPort(hostname, port)
+	dialer := net.Dialer{Timeout: conf.Timeout}
+	tcpConn, err := dialer.DialContext(ctx, "tcp", hostAddr)
 	if err != nil {
-		return false, fmt.Errorf("failed SSH dial: %v", err)
+		return nil, nil, fmt.Errorf("failed SSH dial: %v", err)
 	}
-	defer conn.Close()
+	// Tear the connection down if the check is cancelled
+	stop := context.AfterFunc(ctx, func() { tcpConn.Close() })
 
-	session, err := conn.NewSession()
+	sshConn, chans, reqs, err := ssh.NewClientConn(tcpConn, hostAddr, conf)
 	if err != nil {
-		return false, fmt.Errorf("failed to start SSH session: %v", err)
+		stop()
+		tcpConn.Close()
+		return nil, nil, fmt.Errorf("failed SSH dial: %v", err)
 	}
-	defer session.Close()
 
-	return true, nil
+	return ssh.NewClient(sshConn, chans, reqs), stop, nil
 }
 
-func ScoreSSHLogin(service enum.Service, address string) (int, bool, error) {
-	// Check for single user
-	var servUp bool
-	if service.User != "" {
-		var err error // i hate this part of go why not just work
-		servUp, err = establishSSHConnection(address, strconv.Itoa(service.Port), service.User, service.Password)
-		if err != nil {
-			return 0, false, err
+// sshLogin picks a user, authenticates as them, and returns the connected client.
+func sshLogin(ctx context.Context, target CheckTarget) (*ssh.Client, func() bool, string, error) {
+	// Either the single configured user or one from the related query file
+	username, password, err := chooseCredentials(target)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	auth, err := sshAuthMethods(target.Service, password)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	client, stop, err := establishSSHConnection(ctx, target.Address, strconv.Itoa(target.Service.Port), username, auth)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("as %s: %w", username, err)
+	}
+	return client, stop, username, nil
+}
+
+func ScoreSSHLogin(ctx context.Context, target CheckTarget) enum.CheckResult {
+	client, stop, username, err := sshLogin(ctx, target)
+	if err != nil {
+		return Fail(err)
+	}
+	defer stop()
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return Fail(fmt.Errorf("failed to start SSH session as %s: %v", username, err))
+	}
+	session.Close()
+
+	return Pass(target, fmt.Sprintf("opened a session as %s", username))
+}
+
+// truncateOutput shortens command output for use as evidence.
+func truncateOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > 200 {
+		return output[:200] + "..."
+	}
+	return output
+}
+
+// runSSHCommand runs a single command case in its own session, checking its stdout against
+// the case's expected string and/or regular expression.
+func runSSHCommand(client *ssh.Client, command enum.CommandCase, team string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to start SSH session: %v", err)
+	}
+	defer session.Close()
+
+	cmd := replaceTeamToken(command.Command, team)
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run(cmd); err != nil {
+		return stdout.String(), fmt.Errorf("`%s` failed: %v", cmd, err)
+	}
+	output := stdout.String()
+
+	if command.Expect != "" {
+		expect := replaceTeamToken(command.Expect, team)
+		if !strings.Contains(output, expect) {
+			return output, fmt.Errorf("`%s` output %q does not contain %q", cmd, truncateOutput(output), expect)
 		}
-	} else {
-		username, password, err := ChooseRandomUser(service.QFile)
-		servUp, err = establishSSHConnection(address, strconv.Itoa(service.Port), username, password)
+	}
+	if command.Regex != "" {
+		pattern, err := regexp.Compile(replaceTeamToken(command.Regex, team))
 		if err != nil {
-			return 0, false, err
+			return output, fmt.Errorf("invalid regex for `%s`: %v", cmd, err)
+		}
+		if !pattern.MatchString(output) {
+			return output, fmt.Errorf("`%s` output %q does not match /%s/", cmd, truncateOutput(output), pattern)
 		}
 	}
-	if servUp {
-		return service.Award, true, nil
-	} else {
-		return 0, false, nil
+
+	return output, nil
+}
+
+// ScoreSSHCommand logs in over SSH and runs each of the service's commands, checking that every
+// one succeeds and prints what it is expected to, with each command counting as a part of the
+// check for partial points. "<t>" in a command, expected string or regex is replaced with the team ID.
+func ScoreSSHCommand(ctx context.Context, target CheckTarget) enum.CheckResult {
+	if len(target.Service.Commands) == 0 {
+		return Fail(fmt.Errorf("no commands configured"))
+	}
+
+	client, stop, username, err := sshLogin(ctx, target)
+	if err != nil {
+		return Fail(err)
+	}
+	defer stop()
+	defer client.Close()
+
+	team := strconv.Itoa(target.TeamID)
+	var passed []string
+	var failures []error
+	for _, command := range target.Service.Commands {
+		output, err := runSSHCommand(client, command, team)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("as %s: %v", username, err))
+			continue
+		}
+		passed = append(passed, fmt.Sprintf("`%s` -> %q", replaceTeamToken(command.Command, team), truncateOutput(output)))
 	}
 
+	// Each command is one part of the check
+	return Tally(target, passed, failures)
 }
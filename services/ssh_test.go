@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LTSEC/NEST/enum"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeShell is a stand-in SSH server that accepts a single password or public key and answers
+// exec requests from a fixed table of command outputs. Unknown commands exit with status 127.
+type fakeShell struct {
+	password  string
+	publicKey ssh.PublicKey
+	outputs   map[string]string
+}
+
+// start listens on a random local port and serves connections until the test ends.
+func (s *fakeShell) start(t *testing.T) int {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if s.password != "" && string(password) == s.password {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.publicKey != nil && string(key.Marshal()) == string(s.publicKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeShell) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				status := uint32(0)
+				if output, ok := s.outputs[payload.Command]; ok {
+					channel.Write([]byte(output))
+				} else {
+					status = 127
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+func TestScoreSSHCommand(t *testing.T) {
+	// A key the server also accepts
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("failed to write client key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(clientKey)
+	if err != nil {
+		t.Fatalf("failed to create client signer: %v", err)
+	}
+
+	shell := &fakeShell{
+		password:  "CoffeeBean",
+		publicKey: signer.PublicKey(),
+		outputs: map[string]string{
+			"hostname":                  "team3-web\n",
+			"systemctl is-active nginx": "active\n",
+		},
+	}
+	port := shell.start(t)
+
+	base := enum.Service{
+		Port:     port,
+		Award:    5,
+		User:     "alice",
+		Password: "CoffeeBean",
+		Commands: []enum.CommandCase{
+			{Command: "hostname", Expect: "team<t>"},
+			{Command: "systemctl is-active nginx", Regex: `^active\s*$`},
+		},
+	}
+	target := func(service enum.Service) CheckTarget {
+		return CheckTarget{TeamID: 3, Address: "127.0.0.1", Service: service}
+	}
+
+	// Every command prints what it should
+	if result := ScoreSSHCommand(context.Background(), target(base)); result.Status != enum.StatusUp || result.Points != 5 {
+		t.Fatalf("expected commands to pass, got %+v", result)
+	}
+
+	// Output that doesn't match
+	mismatch := base
+	mismatch.Commands = []enum.CommandCase{{Command: "hostname", Expect: "team4"}}
+	if result := ScoreSSHCommand(context.Background(), target(mismatch)); result.Status != enum.StatusDown {
+		t.Fatalf("expected mismatched output to fail, got %+v", result)
+	}
+
+	// Partial points for the commands that pass
+	partial := base
+	partial.Partial = true
+	partial.Commands = append([]enum.CommandCase{{Command: "whoami"}}, base.Commands...)
+	if result := ScoreSSHCommand(context.Background(), target(partial)); result.Status != enum.StatusPartial || result.Points != 3 {
+		t.Fatalf("expected 3 partial points, got %+v", result)
+	}
+
+	// A command that exits non-zero
+	broken := base
+	broken.Commands = []enum.CommandCase{{Command: "whoami"}}
+	if result := ScoreSSHCommand(context.Background(), target(broken)); result.Status != enum.StatusDown {
+		t.Fatalf("expected a failing command to fail, got %+v", result)
+	}
+
+	// A wrong password
+	wrong := base
+	wrong.Password = "nope"
+	if result := ScoreSSHCommand(context.Background(), target(wrong)); result.Status != enum.StatusDown {
+		t.Fatalf("expected a bad password to fail, got %+v", result)
+	}
+
+	// Logging in with a key instead of a password
+	keyed := base
+	keyed.Password = ""
+	keyed.KeyFile = keyFile
+	if result := ScoreSSHCommand(context.Background(), target(keyed)); result.Status != enum.StatusUp {
+		t.Fatalf("expected key login to pass, got %+v", result)
+	}
+	if result := ScoreSSHLogin(context.Background(), target(keyed)); result.Status != enum.StatusUp {
+		t.Fatalf("expected key login to pass for ssh, got %+v", result)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,41 +17,48 @@ import (
 )
 
 var (
-	webLoadOnce sync.Once
-	siteInfo    []byte
+	webMu    sync.Mutex
+	siteInfo = make(map[string][]byte) // Query file -> the expected page content
 )
 
-// LoadWebFiles is a function that loads all the required web files into memory at startup
-// (HTML FILES) so that they can be accessed later without inconvenience
-func LoadWebFiles(dir string) error {
-	var err error
-	webLoadOnce.Do(func() {
-		var content []byte
-		content, err = os.ReadFile(dir)
-		if err == nil {
-			// remove newlines to normalize a bit
-			siteInfo = []byte(strings.ReplaceAll(string(content), "\n", ""))
-		}
-	})
-	return err
+// LoadWebFiles loads the expected content of a page from the query file at path into memory the
+// first time it is needed, so that it can be accessed later without inconvenience. Each query
+// file is kept separately, so teams can have their own. A file that can't be read is tried again
+// the next time.
+func LoadWebFiles(path string) ([]byte, error) {
+	webMu.Lock()
+	defer webMu.Unlock()
+	if content, ok := siteInfo[path]; ok {
+		return content, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// remove newlines to normalize a bit
+	siteInfo[path] = []byte(strings.ReplaceAll(string(content), "\n", ""))
+	return siteInfo[path], nil
 }
 
 // compPageToBytes uses Chromedp to compare the bytes saved in memory and the content given by the remote server
-func compPageToBytes(ip string, port int) ([]byte, error) {
+func compPageToBytes(parent context.Context, ip string, port int) ([]byte, error) {
 	// Check if server is reachable via TCP before spinning up headless Chrome
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), web_timeout*time.Millisecond)
+	dialer := net.Dialer{Timeout: web_timeout * time.Millisecond}
+	hostAddr := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := dialer.DialContext(parent, "tcp", hostAddr)
 	if err != nil {
 		return nil, fmt.Errorf("server unreachable: %v", err)
 	}
 	_ = conn.Close()
 
 	// Build the URL
-	url := fmt.Sprintf("http://%s:%d", ip, port)
+	url := fmt.Sprintf("http://%s", hostAddr)
 	if port == 443 {
-		url = fmt.Sprintf("https://%s", ip)
+		url = fmt.Sprintf("https://%s", hostAddr)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
 	defer cancel()
 
 	// running as root in Docker, need no-sandbox:
@@ -60,8 +68,10 @@ func compPageToBytes(ip string, port int) ([]byte, error) {
 		chromedp.Flag("disable-gpu", true),
 	)
 
-	allocCtx, _ := chromedp.NewExecAllocator(ctx, opts...)
-	browserCtx, _ := chromedp.NewContext(allocCtx)
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, opts...)
+	defer cancelAlloc()
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	defer cancelBrowser()
 
 	var pageHTML string
 	// Run Chromedp tasks
@@ -86,81 +96,105 @@ func similarityRatio(a, b []byte) float64 {
 	return smetrics.JaroWinkler(string(a), string(b), 0.7, 4)
 }
 
-// ScoreWeb80 ensures that a website is accessible via http, but does not
-// check for the content on the website
-func ScoreWeb80(service enum.Service, address string) (int, bool, error) {
+// headStatus sends a HEAD request to url and returns the response once it is known to have
+// a successful status code.
+func headStatus(ctx context.Context, url string) (*http.Response, error) {
 	// Create an HTTP client with a timeout.
 	client := &http.Client{
 		Timeout: web_timeout * time.Millisecond,
 	}
 
 	// Use the HEAD method to check the URL.
-	url := fmt.Sprintf("http://%s:%d", address, service.Port)
-	resp, err := client.Head(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return 0, false, err
+		return nil, err
 	}
 	// It's a good practice to close the response body even if it is empty.
-	defer resp.Body.Close()
+	resp.Body.Close()
 
 	// Check for a successful HTTP status code.
 	if resp.StatusCode < 200 || resp.StatusCode > 400 {
-		return 0, false, fmt.Errorf("Website returned an unexpected status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("Website returned an unexpected status code: %d", resp.StatusCode)
 	}
 
-	return service.Award, true, nil
+	return resp, nil
 }
 
-// ScoreWebSSLTLS ensures that a website is accessible and is secured via SSL or TLS, but
-// does not check for the content on the website
-func ScoreWebSSLTLS(service enum.Service, address string) (int, bool, error) {
-	// Create an HTTP client with a timeout.
-	client := &http.Client{
-		Timeout: web_timeout * time.Millisecond,
+// webPages returns the paths to check on a website, defaulting to the root page.
+func webPages(service enum.Service) []string {
+	if len(service.Pages) == 0 {
+		return []string{"/"}
 	}
+	return service.Pages
+}
 
-	// Use the HEAD method to check the URL over HTTPS.
-	url := fmt.Sprintf("https://%s:%d", address, service.Port)
-	resp, err := client.Head(url)
-	if err != nil {
-		return 0, false, err
-	}
-	// Always close the response body.
-	defer resp.Body.Close()
+// scoreWebPages sends a HEAD request for each of the service's pages over the given scheme. Each
+// page is one part of the check, so services that award partial points earn a share for each page
+// that loads.
+func scoreWebPages(ctx context.Context, target CheckTarget, scheme string) enum.CheckResult {
+	base := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(target.Address, strconv.Itoa(target.Service.Port)))
+
+	var passed []string
+	var failures []error
+	for _, page := range webPages(target.Service) {
+		if !strings.HasPrefix(page, "/") {
+			page = "/" + page
+		}
+		url := base + page
 
-	// Check for a successful HTTP status code.
-	if resp.StatusCode < 200 || resp.StatusCode > 400 {
-		return 0, false, fmt.Errorf("Website returned an unexpected status code: %d", resp.StatusCode)
-	}
+		resp, err := headStatus(ctx, url)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", url, err))
+			continue
+		}
 
-	// Ensure a TLS connection was established.
-	if resp.TLS == nil {
-		return 0, false, fmt.Errorf("No TLS connection was established")
+		// Ensure a TLS connection was established.
+		if scheme == "https" && resp.TLS == nil {
+			failures = append(failures, fmt.Errorf("%s: No TLS connection was established", url))
+			continue
+		}
+		passed = append(passed, fmt.Sprintf("HEAD %s returned %s", url, resp.Status))
 	}
 
-	return service.Award, true, nil
+	return Tally(target, passed, failures)
+}
+
+// ScoreWeb80 ensures that a website's pages are accessible via http, but does not
+// check for the content on the website
+func ScoreWeb80(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return scoreWebPages(ctx, target, "http")
+}
+
+// ScoreWebSSLTLS ensures that a website's pages are accessible and are secured via SSL or TLS, but
+// does not check for the content on the website
+func ScoreWebSSLTLS(ctx context.Context, target CheckTarget) enum.CheckResult {
+	return scoreWebPages(ctx, target, "https")
 }
 
 // ScoreWebContent scores a website based on the content it's providing users, through either port 80 or SSL/TLS
 //
-// To do this, it first checks both ScoreWeb80 and ScoreWebSSLTLS to ensure the website is up before
-// continuing any operations. It will return false and 0 points if both of those functions do, but
-// if either function returns true it will work.
-func ScoreWebContent(service enum.Service, address string) (int, bool, error) {
+// To do this, it renders the page in a headless browser and compares it against the
+// prepared content in the service's query file.
+func ScoreWebContent(ctx context.Context, target CheckTarget) enum.CheckResult {
 	// Ensure web files are loaded
-	if err := LoadWebFiles(service.QFile); err != nil {
-		return 0, false, err
+	expected, err := LoadWebFiles(target.Service.QFile)
+	if err != nil {
+		return Fail(err)
 	}
 
-	serverInfo, err := compPageToBytes(address, service.Port)
+	serverInfo, err := compPageToBytes(ctx, target.Address, target.Service.Port)
 	if err != nil {
-		return 0, false, err
+		return Fail(err)
 	}
 
-	similarity := similarityRatio(siteInfo, serverInfo)
+	similarity := similarityRatio(expected, serverInfo)
 	if similarity < .8 {
-		return 0, false, fmt.Errorf("The scored website was not similar enough to the expected content.")
+		return Fail(fmt.Errorf("The scored website was not similar enough to the expected content (%.2f similar).", similarity))
 	}
 
-	return service.Award, true, nil
+	return Pass(target, fmt.Sprintf("rendered page was %.2f similar to the expected content", similarity))
 }
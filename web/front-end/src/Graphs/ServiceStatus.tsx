@@ -80,7 +80,9 @@ const ServiceStatus = () => {
                   width: "125px",
                   height: "20px",
                   backgroundColor: team.Services[service]?.is_up
-                    ? "#4CAF50"
+                    ? team.Services[service]?.is_partial
+                      ? "#FFC107"
+                      : "#4CAF50"
                     : "#F44336",
                   borderRadius: "3px",
                   display: "flex",
@@ -2,9 +2,9 @@ import React, { useState } from "react";
 import axios from 'axios';
 import { useNavigate } from "react-router-dom";
 import './login.css';
-// for testing purposes (testing login without querying)
-// might make it a functionality later
-const canLogIn = true;
+
+// Use the backend URL from environment variables
+const API_BASE_URL = import.meta.env.VITE_API_URL;
 
 const Login = () => {
     const [credentials, setCredentials] = useState({username: "", password: ""});
@@ -26,18 +26,17 @@ const Login = () => {
         setInputError(false);
         event.preventDefault();
 
-        if (canLogIn) {
-            navigate("/graphs");
-            return;
-        }
-
         if (credentials.username != "" && credentials.password != "") {
-            axios.post("https://localhost:3001/signin", {
+            axios.post(`${API_BASE_URL}/auth/login`, {
                 username: credentials.username,
                 password: credentials.password,
             })
             .then(response => {
-                console.log(response);
+                // keep the tokens for later requests
+                localStorage.setItem("accessToken", response.data.accessToken);
+                localStorage.setItem("refreshToken", response.data.refreshToken);
+                localStorage.setItem("role", response.data.role);
+                axios.defaults.headers.common["Authorization"] = `Bearer ${response.data.accessToken}`;
                 setMessage("Login Successfull");
                 navigate("/graphs");
             })
@@ -45,7 +44,10 @@ const Login = () => {
                 let errorMsg = "An error occurred";
 
                 // checks for a more detailed error
-                if (error.response && error.response.data && error.response.data.message) {
+                if (error.response && typeof error.response.data === "string" && error.response.data) {
+                    errorMsg = error.response.data;
+                }
+                else if (error.response && error.response.data && error.response.data.message) {
                     errorMsg = error.response.data.message;
                 } 
                 else if (error.message) {
@@ -5,6 +5,7 @@ import axios from "axios";
 export interface ServiceData {
   points: number;
   is_up: boolean;
+  is_partial: boolean;
   successful_checks: number;
   total_checks: number;
 }
@@ -19,6 +20,39 @@ export interface Team {
 // Use the backend URL from environment variables
 const API_BASE_URL = import.meta.env.VITE_API_URL;
 
+// A "check" event from the live event stream
+interface CheckEvent {
+  round: number;
+  team_id: number;
+  service: string;
+  status: "up" | "partial" | "down";
+  points: number;
+}
+
+// Applies a recorded check to the matching team's service
+function applyCheck(teams: Team[], check: CheckEvent): Team[] {
+  return teams.map((team) => {
+    const service = team.Services?.[check.service];
+    if (team.ID !== check.team_id || !service) {
+      return team;
+    }
+    const isUp = check.status !== "down";
+    return {
+      ...team,
+      Services: {
+        ...team.Services,
+        [check.service]: {
+          points: service.points + check.points,
+          is_up: isUp,
+          is_partial: check.status === "partial",
+          successful_checks: service.successful_checks + (isUp ? 1 : 0),
+          total_checks: service.total_checks + 1,
+        },
+      },
+    };
+  });
+}
+
 // Let the hook return an array of `Team`
 function useTeamHandler(apiUrl: string): Team[] {
   const [teams, setTeams] = useState<Team[]>([]);
@@ -34,13 +68,24 @@ function useTeamHandler(apiUrl: string): Team[] {
       }
     };
 
-    // Fetch immediately and set interval to fetch every 5 seconds
-    fetchTeams();
-    const intervalId = setInterval(fetchTeams, 5000);
+    // Without live events, fall back to fetching every 5 seconds
+    if (typeof EventSource === "undefined") {
+      fetchTeams();
+      const intervalId = setInterval(fetchTeams, 5000);
+      return () => clearInterval(intervalId);
+    }
+
+    // Fetch everything whenever the stream (re)connects, then apply checks as they're recorded
+    const source = new EventSource(`${API_BASE_URL}/events`);
+    source.onopen = () => fetchTeams();
+    source.addEventListener("check", (event) => {
+      const check: CheckEvent = JSON.parse((event as MessageEvent).data);
+      setTeams((current) => applyCheck(current, check));
+    });
 
-    // Cleanup function to clear interval when component unmounts (user goes to a different webpage)
+    // Cleanup function to close the stream when component unmounts (user goes to a different webpage)
     //  or apiUrl changes
-    return () => clearInterval(intervalId);
+    return () => source.close();
   }, [apiUrl]);
 
   return teams;